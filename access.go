@@ -0,0 +1,260 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Access levels of Invoke routes
+//
+//	Public:        anyone on the channel, no participant needed
+//	Participant:   any registered participant
+//	Register:      the identity being registered, or an admin
+//	Self:          only the participant named in the payload
+//	SelfOrAdmin:   the participant named in the payload, or an admin
+//	TicketOwner:   the creator of the ticket named in the payload, or an admin
+//	Admin:         admins only
+const (
+	AccessPublic = iota
+	AccessParticipant
+	AccessRegister
+	AccessSelf
+	AccessSelfOrAdmin
+	AccessTicketOwner
+	AccessAdmin
+)
+
+// accessRule information
+//
+//	Level:    one of the Access* levels
+//	Target:   extracts the UserID (Self*, Register) or TicketID (TicketOwner) the call acts on
+//	Check:    optional extra check run against the resolved caller
+type accessRule struct {
+	Level  int
+	Target func(args []string) (string, error)
+	Check  func(stub shim.ChaincodeStubInterface, caller Caller, args []string) error
+}
+
+// accessTable classifies every Invoke route; routes missing here are rejected
+var accessTable = map[string]accessRule{
+	"addParticipant":       {Level: AccessRegister, Target: jsonField("Participant_UserID")},
+	"readParticipant":      {Level: AccessPublic},
+	"readAllParticipant":   {Level: AccessPublic},
+	"updateParticipant":    {Level: AccessSelfOrAdmin, Target: jsonField("Participant_UserID")},
+	"deleteParticipant":    {Level: AccessAdmin},
+	"ParticipantChangeLoB": {Level: AccessAdmin},
+	"ChangePassword":       {Level: AccessSelf, Target: jsonField("UserID")},
+	"VerifyCredentials":    {Level: AccessPublic},
+	"ParticipantQuery":     {Level: AccessPublic},
+
+	"CreditCreate":   {Level: AccessAdmin},
+	"CreditRead":     {Level: AccessPublic},
+	"CreditAdd":      {Level: AccessParticipant, Check: checkCreditAdd},
+	"CreditDelete":   {Level: AccessAdmin},
+	"CreditTransfer": {Level: AccessSelf, Target: jsonField("From")},
+	"CreditReverse":  {Level: AccessAdmin},
+	"CreditJournal":  {Level: AccessPublic},
+	"CreditVerify":   {Level: AccessPublic},
+	"TopTenCredit":   {Level: AccessPublic},
+
+	"LoBReadAll": {Level: AccessPublic},
+	"LoBRead":    {Level: AccessPublic},
+	"LoBFund":    {Level: AccessAdmin},
+	"LoBCreate":  {Level: AccessAdmin},
+	"LoBRename":  {Level: AccessAdmin},
+	"LoBArchive": {Level: AccessAdmin},
+	"LoBMove":    {Level: AccessAdmin},
+
+	"TicketCreate":           {Level: AccessSelf, Target: jsonField("Ticket_UserID")},
+	"TicketRead":             {Level: AccessPublic},
+	"TicketRead2":            {Level: AccessPublic},
+	"TicketList":             {Level: AccessPublic},
+	"TicketQuery":            {Level: AccessPublic},
+	"TicketUpdate":           {Level: AccessTicketOwner, Target: jsonField("Ticket_TicketID")},
+	"AutoUpdateTicketStatus": {Level: AccessTicketOwner, Target: argAt(0)},
+	"TicketDelete":           {Level: AccessTicketOwner, Target: argAt(0)},
+	"ExpireTickets":          {Level: AccessAdmin},
+	"EscrowRead":             {Level: AccessPublic},
+
+	"OrderCreate": {Level: AccessSelf, Target: jsonField("UserID")},
+	"OrderRead":   {Level: AccessPublic},
+	"OrderRead2":  {Level: AccessPublic},
+	"OrderQuery":  {Level: AccessPublic},
+	"OrderUpdate": {Level: AccessParticipant},
+
+	"GetHistory": {Level: AccessPublic},
+
+	"MigrateKeys":     {Level: AccessAdmin},
+	"AuditInvariants": {Level: AccessPublic},
+	"Reconcile":       {Level: AccessAdmin},
+}
+
+// Helper: target extractor reading the n-th plain argument
+func argAt(n int) func(args []string) (string, error) {
+	return func(args []string) (string, error) {
+		if len(args) <= n {
+			return "", errors.New("missing argument")
+		}
+		return args[n], nil
+	}
+}
+
+// Helper: target extractor reading a string field of the JSON in the first argument
+func jsonField(field string) func(args []string) (string, error) {
+	return func(args []string) (string, error) {
+		var raw map[string]interface{}
+		if len(args) == 0 {
+			return "", errors.New("missing argument")
+		}
+		err := json.Unmarshal([]byte(args[0]), &raw)
+		if err != nil {
+			return "", errors.New("Input is not a valid JSON")
+		}
+		value, ok := raw[field].(string)
+		if !ok {
+			return "", errors.New("missing field " + field)
+		}
+		return value, nil
+	}
+}
+
+func forbidden(function string, reason string) *ChaincodeError {
+	return newError(ErrForbidden, "Forbidden: "+function+" "+reason)
+}
+
+// Helper: typed errors of an access check pass unchanged, e.g. a missing ticket stays NotFound
+func denied(function string, err error) error {
+	if _, ok := err.(*ChaincodeError); ok {
+		return err
+	}
+	return forbidden(function, err.Error())
+}
+
+// Helper: enforce the access rule of function against the submitter's client identity
+func checkAccess(stub shim.ChaincodeStubInterface, function string, args []string) error {
+	rule, ok := accessTable[function]
+	if !ok {
+		return unknownFunction(function)
+	}
+	if rule.Level == AccessPublic {
+		return nil
+	}
+
+	var target string
+	var err error
+	if rule.Target != nil {
+		target, err = rule.Target(args)
+		if err != nil {
+			return badRequest(function, err.Error())
+		}
+	}
+
+	// a new identity has no participant record yet
+	if rule.Level == AccessRegister {
+		_, userID, err := getClientIdentity(stub)
+		if err != nil {
+			return forbidden(function, err.Error())
+		}
+		if userID == target {
+			return nil
+		}
+	}
+
+	caller, err := getCaller(stub)
+	if err != nil {
+		return forbidden(function, err.Error())
+	}
+	isAdmin := caller.Participant.IsAdmin
+
+	switch rule.Level {
+	case AccessRegister, AccessAdmin:
+		if !isAdmin {
+			return forbidden(function, "requires an admin participant")
+		}
+	case AccessSelf:
+		if caller.UserID != target {
+			return forbidden(function, "can only be called by "+target+" itself, not "+caller.UserID)
+		}
+	case AccessSelfOrAdmin:
+		if caller.UserID != target && !isAdmin {
+			return forbidden(function, "can only be called by "+target+" itself or an admin")
+		}
+	case AccessTicketOwner:
+		if !isAdmin {
+			err = checkTicketOwner(stub, caller, target)
+			if err != nil {
+				return denied(function, err)
+			}
+		}
+	}
+
+	if rule.Check != nil {
+		err = rule.Check(stub, caller, args)
+		if err != nil {
+			return denied(function, err)
+		}
+	}
+	return nil
+}
+
+// Helper: the caller must have created the ticket
+func checkTicketOwner(stub shim.ChaincodeStubInterface, caller Caller, ticketID string) error {
+	var ticket Ticket
+	ticketAsBytes, err := stub.GetState(ticketKey(stub, ticketID))
+	if err != nil {
+		return errInternal("checkTicketOwner: Error getting ticket " + ticketID)
+	}
+	if ticketAsBytes == nil {
+		return errNotFound(TicketObjectType, ticketID)
+	}
+	err = json.Unmarshal(ticketAsBytes, &ticket)
+	if err != nil {
+		return errInternal("checkTicketOwner: Corrupt ticket " + ticketID)
+	}
+	if ticket.UserID != caller.UserID {
+		return errors.New("requires the owner of ticket " + ticketID + ", not " + caller.UserID)
+	}
+	return nil
+}
+
+// Helper: constant credit is admin-only, ticket credit is for the ticket owner
+func checkCreditAdd(stub shim.ChaincodeStubInterface, caller Caller, args []string) error {
+	ticketID, err := jsonField("ticketID")(args)
+	if err != nil {
+		return err
+	}
+	if caller.Participant.IsAdmin {
+		return nil
+	}
+	if ticketID == "creditADD" {
+		return errors.New("requires an admin participant to add constant credit")
+	}
+	return checkTicketOwner(stub, caller, ticketID)
+}
+
+// Helper: whether any registered participant is an admin
+func hasAdmin(stub shim.ChaincodeStubInterface) (bool, error) {
+	var readingIDs ReadingIDIndex
+	var participant Participant
+	bytes, err := stub.GetState("readingIDIndex")
+	if err != nil {
+		return false, errors.New("hasAdmin: Error getting readingIDIndex array")
+	}
+	err = json.Unmarshal(bytes, &readingIDs)
+	if err != nil {
+		return false, errors.New("hasAdmin: Error unmarshalling readingIDIndex array JSON")
+	}
+	for _, participantID := range readingIDs.UserIDs {
+		bytes, err = stub.GetState(participantKey(stub, participantID))
+		if err != nil {
+			return false, errors.New("hasAdmin: Error getting participant with ID: " + participantID)
+		}
+		participant = Participant{}
+		if json.Unmarshal(bytes, &participant) == nil && participant.IsAdmin {
+			return true, nil
+		}
+	}
+	return false, nil
+}
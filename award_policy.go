@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Kinds of award policies, Ticket_Value is the budget of each
+//
+//	Fixed:      every awarded user gets Amount, as long as the budget lasts
+//	Equal:      what is left of the budget is split equally among the users awarded together
+//	Weighted:   every awarded user gets their percentage of the budget from Weights
+const (
+	AwardFixed    = "Fixed"
+	AwardEqual    = "Equal"
+	AwardWeighted = "Weighted"
+)
+
+// Rounding of the Equal and Weighted shares
+//
+//	Down:      every share is rounded down, the rest stays in the budget
+//	Largest:   the rest is handed out one by one to the largest remainders, ties by UserID
+const (
+	RoundDown    = "Down"
+	RoundLargest = "Largest"
+)
+
+// AwardPolicy information, Ticket_Award of a ticket; tickets without one split equally
+//
+//	Kind:       one of the Award* kinds
+//	Amount:     credit per user of a Fixed policy
+//	Weights:    percentage per UserID of a Weighted policy, set by the ticket owner, adding up to 100
+//	Rounding:   RoundDown (default) or RoundLargest
+type AwardPolicy struct {
+	Kind     string         `json:"Kind"`
+	Amount   int            `json:"Amount,omitempty"`
+	Weights  map[string]int `json:"Weights,omitempty"`
+	Rounding string         `json:"Rounding,omitempty"`
+}
+
+// defaultAwardPolicy - policy of tickets without Ticket_Award
+var defaultAwardPolicy = AwardPolicy{Kind: AwardEqual, Rounding: RoundDown}
+
+func awardPolicy(ticket Ticket) AwardPolicy {
+	if ticket.Award == nil {
+		return defaultAwardPolicy
+	}
+	return *ticket.Award
+}
+
+// Helper: what is wrong with the award policy of ticket, nil if nothing
+func validateAwardPolicy(ticket Ticket) []string {
+	if ticket.Award == nil {
+		return nil
+	}
+	var problems []string
+	policy := *ticket.Award
+	switch policy.Kind {
+	case AwardFixed:
+		if policy.Amount <= 0 || policy.Amount > ticket.Value {
+			problems = append(problems, "Amount of a Fixed award must be between 1 and Ticket_Value")
+		}
+	case AwardEqual:
+	case AwardWeighted:
+		total := 0
+		for userID, weight := range policy.Weights {
+			if weight <= 0 {
+				problems = append(problems, "weight of "+userID+" must be positive")
+			}
+			total += weight
+		}
+		if total != 100 {
+			problems = append(problems, "Weights must add up to 100, not "+strconv.Itoa(total))
+		}
+	default:
+		problems = append(problems, "Kind must be one of Fixed, Equal, Weighted")
+	}
+	if policy.Kind != AwardFixed && policy.Amount != 0 {
+		problems = append(problems, "Amount only applies to a Fixed award")
+	}
+	if policy.Kind != AwardWeighted && len(policy.Weights) > 0 {
+		problems = append(problems, "Weights only apply to a Weighted award")
+	}
+	if policy.Rounding != "" && policy.Rounding != RoundDown && policy.Rounding != RoundLargest {
+		problems = append(problems, "Rounding must be Down or Largest")
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+// Helper: split numerators over a common denominator into whole credits, see RoundDown and RoundLargest
+func roundShares(userIDs []string, numerators map[string]int, denominator int, rounding string) map[string]int {
+	shares := make(map[string]int)
+	exact, rounded := 0, 0
+	for _, userID := range userIDs {
+		shares[userID] = numerators[userID] / denominator
+		exact += numerators[userID]
+		rounded += shares[userID]
+	}
+	if rounding != RoundLargest {
+		return shares
+	}
+	order := append([]string(nil), userIDs...)
+	sort.SliceStable(order, func(i, j int) bool {
+		return numerators[order[i]]%denominator > numerators[order[j]]%denominator
+	})
+	for i := 0; i < exact/denominator-rounded; i++ {
+		shares[order[i]]++
+	}
+	return shares
+}
+
+// Helper: credit of each of userIDs awarded together on ticket, refused if it would exceed Ticket_Value
+func awardShares(ticket Ticket, userIDs []string) (map[string]int, error) {
+	policy := awardPolicy(ticket)
+	sorted := append([]string(nil), userIDs...)
+	sort.Strings(sorted)
+	budget := ticket.Value - ticket.Awarded
+
+	numerators := make(map[string]int)
+	denominator := 1
+	switch policy.Kind {
+	case AwardFixed:
+		for _, userID := range sorted {
+			numerators[userID] = policy.Amount
+		}
+	case AwardEqual:
+		denominator = len(sorted)
+		for _, userID := range sorted {
+			numerators[userID] = budget
+		}
+	case AwardWeighted:
+		denominator = 100
+		for _, userID := range sorted {
+			weight, ok := policy.Weights[userID]
+			if !ok {
+				return nil, newError(ErrConflict, "Award: "+userID+" has no weight in the award policy of ticket "+ticket.TicketID).
+					on(TicketObjectType, ticket.TicketID)
+			}
+			numerators[userID] = ticket.Value * weight
+		}
+	}
+	shares := roundShares(sorted, numerators, denominator, policy.Rounding)
+
+	total := 0
+	for _, share := range shares {
+		total += share
+	}
+	if total > budget {
+		return nil, newError(ErrBudgetExceeded, "Award: "+strconv.Itoa(total)+" credit exceeds the remaining budget "+
+			strconv.Itoa(budget)+" of ticket "+ticket.TicketID).on(TicketObjectType, ticket.TicketID)
+	}
+	// ==== an award without credit would still mark the ticket as credited ====
+	for _, userID := range sorted {
+		if shares[userID] == 0 {
+			return nil, newError(ErrBudgetExceeded, "Award: The remaining budget "+strconv.Itoa(budget)+" of ticket "+
+				ticket.TicketID+" leaves no credit for "+userID).on(TicketObjectType, ticket.TicketID)
+		}
+	}
+	return shares, nil
+}
+
+// Helper: whether two award policies split a budget the same way
+func sameAwardPolicy(a AwardPolicy, b AwardPolicy) bool {
+	for _, policy := range []*AwardPolicy{&a, &b} {
+		if policy.Rounding == "" {
+			policy.Rounding = RoundDown
+		}
+		if len(policy.Weights) == 0 {
+			policy.Weights = nil
+		}
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// Helper: ticket credit given with CreditAdd, it is an award paid by hand and follows the same rules:
+// userID holds an Awarded order on ticketID and value fits into the budget the ticket has left
+func checkTicketCredit(stub shim.ChaincodeStubInterface, ticketID string, userID string, value int) (Ticket, error) {
+	var ticket Ticket
+	ticketAsBytes, err := stub.GetState(ticketKey(stub, ticketID))
+	if err != nil {
+		return ticket, errInternal("CreditAdd: Error getting ticket " + ticketID)
+	}
+	if ticketAsBytes == nil {
+		return ticket, errNotFound(TicketObjectType, ticketID)
+	}
+	err = json.Unmarshal(ticketAsBytes, &ticket)
+	if err != nil {
+		return ticket, errInternal("CreditAdd: Corrupt ticket " + ticketID)
+	}
+	order, err := retrieveOrder(stub, ticketID, userID)
+	if err != nil {
+		return ticket, err
+	}
+	if order == nil || order.Status != OrderAwarded {
+		return ticket, newError(ErrConflict, "CreditAdd: "+userID+" has no Awarded order on ticket "+ticketID).
+			on(OrderObjectType, orderID(ticketID, userID))
+	}
+	if budget := ticket.Value - ticket.Awarded; value > budget {
+		return ticket, newError(ErrBudgetExceeded, "CreditAdd: "+strconv.Itoa(value)+" credit exceeds the remaining budget "+
+			strconv.Itoa(budget)+" of ticket "+ticketID).on(TicketObjectType, ticketID)
+	}
+	return ticket, nil
+}
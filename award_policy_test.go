@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func (c *testChain) createAwardTicket(value int, policy string) string {
+	c.t.Helper()
+	fields := map[string]interface{}{"Ticket_Title": "Shared ticket", "Ticket_Type": 0, "Ticket_Value": value, "Ticket_UserID": owner}
+	if policy != "" {
+		fields["Ticket_Award"] = json.RawMessage(policy)
+	}
+	bytes, _ := json.Marshal(fields)
+	c.mustInvoke(owner, "TicketCreate", string(bytes))
+	return c.lastTxID()
+}
+
+// finish - orders of userIDs on ticketID up to Done
+func (c *testChain) finish(ticketID string, userIDs ...string) {
+	c.t.Helper()
+	for _, userID := range userIDs {
+		c.apply(ticketID, userID)
+	}
+	c.orderUpdate(owner, ticketID, "Confirm", userIDs...)
+	c.orderUpdate(owner, ticketID, "Done", userIDs...)
+}
+
+func TestRoundShares(t *testing.T) {
+	users := []string{"a", "b", "c"}
+	cases := []struct {
+		numerators  map[string]int
+		denominator int
+		rounding    string
+		want        []int
+	}{
+		{map[string]int{"a": 10, "b": 10, "c": 10}, 3, RoundDown, []int{3, 3, 3}},
+		{map[string]int{"a": 10, "b": 10, "c": 10}, 3, RoundLargest, []int{4, 3, 3}},
+		{map[string]int{"a": 11, "b": 11, "c": 11}, 3, RoundLargest, []int{4, 4, 3}},
+		{map[string]int{"a": 7 * 10, "b": 25 * 10, "c": 68 * 10}, 100, RoundDown, []int{0, 2, 6}},
+		{map[string]int{"a": 7 * 10, "b": 25 * 10, "c": 68 * 10}, 100, RoundLargest, []int{1, 2, 7}},
+	}
+	for _, tc := range cases {
+		shares := roundShares(users, tc.numerators, tc.denominator, tc.rounding)
+		for i, userID := range users {
+			if shares[userID] != tc.want[i] {
+				t.Errorf("%v / %d %s: got %v, expected %v", tc.numerators, tc.denominator, tc.rounding, shares, tc.want)
+				break
+			}
+		}
+	}
+}
+
+func TestAwardPolicies(t *testing.T) {
+	c := newExchain(t)
+	third := "i000005"
+	c.register(third, IBS, false)
+
+	// fixed amount per user, as long as the budget lasts
+	fixed := c.createAwardTicket(50, `{"Kind": "Fixed", "Amount": 20}`)
+	c.finish(fixed, applicant, other, third)
+	c.orderUpdate(admin, fixed, "Award", applicant, other)
+	if c.credit(applicant) != 20 || c.credit(other) != 20 || c.ticket(fixed).Awarded != 40 {
+		t.Errorf("unexpected credits %d %d", c.credit(applicant), c.credit(other))
+	}
+	e := c.failure(admin, "OrderUpdate", orderUpdate(fixed, "Award", third))
+	if e.Code != ErrBudgetExceeded || e.Entity != TicketObjectType || e.ID != fixed {
+		t.Errorf("unexpected error %+v", e)
+	}
+	if c.order(fixed, third).Status != OrderDone || c.credit(third) != 0 {
+		t.Error("a refused award must not change the order or the credit")
+	}
+
+	// equal split, the rest of the rounding goes to the first UserIDs
+	equal := c.createAwardTicket(10, `{"Kind": "Equal", "Rounding": "Largest"}`)
+	c.finish(equal, applicant, other, third)
+	c.orderUpdate(admin, equal, "Award", third, other, applicant)
+	if c.credit(applicant) != 20+4 || c.credit(other) != 20+3 || c.credit(third) != 3 || c.ticket(equal).Awarded != 10 {
+		t.Errorf("unexpected credits %d %d %d", c.credit(applicant), c.credit(other), c.credit(third))
+	}
+
+	// weighted by the contribution set by the owner
+	weighted := c.createAwardTicket(30, `{"Kind": "Weighted", "Weights": {"`+applicant+`": 50, "`+other+`": 30, "`+third+`": 20}}`)
+	c.finish(weighted, applicant, other, third)
+	c.orderUpdate(admin, weighted, "Award", applicant)
+	c.orderUpdate(admin, weighted, "Award", other, third)
+	if c.credit(applicant) != 24+15 || c.credit(other) != 23+9 || c.credit(third) != 3+6 || c.ticket(weighted).Awarded != 30 {
+		t.Errorf("unexpected credits %d %d %d", c.credit(applicant), c.credit(other), c.credit(third))
+	}
+	if c.lobTotal(SMB) != 39+32 || c.lobTotal(IBS) != 9 {
+		t.Errorf("unexpected LoB totals SMB %d IBS %d", c.lobTotal(SMB), c.lobTotal(IBS))
+	}
+
+	unweighted := c.createAwardTicket(30, `{"Kind": "Weighted", "Weights": {"`+applicant+`": 100}}`)
+	c.finish(unweighted, other)
+	if e = c.failure(admin, "OrderUpdate", orderUpdate(unweighted, "Award", other)); e.Code != ErrConflict {
+		t.Errorf("unexpected error %+v", e)
+	}
+
+	// the owner can not reset what was awarded
+	var ticket Ticket
+	c.mustUnmarshal(c.mustInvoke("", "TicketRead", weighted), &ticket)
+	ticket.Awarded = 0
+	c.mustInvoke(owner, "TicketUpdate", ticketUpdateJSON(ticket))
+	if c.ticket(weighted).Awarded != 30 {
+		t.Error("TicketUpdate changed Ticket_Awarded")
+	}
+	ticket.Value = 20
+	c.mustFail("Ticket_Value can not drop below the 30 credit already awarded", owner, "TicketUpdate", ticketUpdateJSON(ticket))
+
+	// nor move the weights once awards were paid by them
+	ticket.Value = 30
+	ticket.Award.Weights = map[string]int{applicant: 20, other: 40, third: 40}
+	c.mustFail("Ticket_Award can not change after the first award", owner, "TicketUpdate", ticketUpdateJSON(ticket))
+
+	// a later equal award needs a credit per user from what is left
+	split := c.createAwardTicket(3, "")
+	c.finish(split, applicant, other, third)
+	c.orderUpdate(admin, split, "Award", applicant)
+	e = c.failure(admin, "OrderUpdate", orderUpdate(split, "Award", other))
+	if e.Code != ErrBudgetExceeded || !strings.Contains(e.Message, "leaves no credit for "+other) {
+		t.Errorf("unexpected error %+v", e)
+	}
+	var credit Credit
+	c.mustUnmarshal(c.mustInvoke("", "CreditRead", other), &credit)
+	if c.order(split, other).Status != OrderDone || Is_Inarray(credit.TicketIDs, split) || c.credit(applicant) != 39+3 {
+		t.Errorf("unexpected credit %+v", credit)
+	}
+	c.mustFail("credit for "+other, admin, "OrderUpdate", orderUpdate(split, "Award", other, third))
+}
+
+func TestAwardPolicyValidation(t *testing.T) {
+	c := newExchain(t)
+	create := func(policy string) string {
+		return `{"Ticket_Title": "t", "Ticket_Type": 0, "Ticket_Value": 10, "Ticket_UserID": "` + owner + `", "Ticket_Award": ` + policy + `}`
+	}
+
+	c.mustFail("Amount of a Fixed award must be between 1 and Ticket_Value", owner, "TicketCreate", create(`{"Kind": "Fixed", "Amount": 11}`))
+	c.mustFail("Amount of a Fixed award must be between 1 and Ticket_Value", owner, "TicketCreate", create(`{"Kind": "Fixed"}`))
+	c.mustFail("Weights must add up to 100, not 90", owner, "TicketCreate", create(`{"Kind": "Weighted", "Weights": {"a": 60, "b": 30}}`))
+	c.mustFail("weight of b must be positive", owner, "TicketCreate", create(`{"Kind": "Weighted", "Weights": {"a": 100, "b": 0}}`))
+	c.mustFail("Weights only apply to a Weighted award", owner, "TicketCreate", create(`{"Kind": "Equal", "Weights": {"a": 100}}`))
+	c.mustFail("Kind must be one of Fixed, Equal, Weighted", owner, "TicketCreate", create(`{"Kind": "Lottery"}`))
+	c.mustFail("Rounding must be Down or Largest", owner, "TicketCreate", create(`{"Kind": "Equal", "Rounding": "Up"}`))
+	c.mustFail("field Ticket_Award must be a JSON object", owner, "TicketCreate", create(`"Equal"`))
+
+	c.mustInvoke(owner, "TicketCreate", create(`{"Kind": "Fixed", "Amount": 10, "Rounding": "Down"}`))
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// PromoteAction - Action of the OrderUpdate results of orders moved off the waitlist
+const PromoteAction = "Promote"
+
+// Helper: seats of Ticket_Capacity taken by orders, see orderStatuses
+func takenSeats(orders []Order) int {
+	taken := 0
+	for _, order := range orders {
+		if orderStatuses[order.Status].Seat {
+			taken++
+		}
+	}
+	return taken
+}
+
+// Helper: the waitlisted orders, first in line first
+func waitlist(orders []Order) []Order {
+	var waiting []Order
+	for _, order := range orders {
+		if order.Status == OrderWaitlisted {
+			waiting = append(waiting, order)
+		}
+	}
+	sort.SliceStable(waiting, func(i, j int) bool {
+		return waiting[i].Position < waiting[j].Position
+	})
+	return waiting
+}
+
+// Helper: seats of ticket still free among its orders; a ticket without capacity has a seat for every order,
+// so nobody is waitlisted and a waitlist left from an earlier capacity is promoted as a whole
+func freeSeats(ticket Ticket, orders []Order) int {
+	if ticket.Capacity == 0 {
+		return len(orders) + 1
+	}
+	return ticket.Capacity - takenSeats(orders)
+}
+
+// Helper: whether every seat of ticket is taken
+func ticketFull(stub shim.ChaincodeStubInterface, ticket Ticket, pending map[string]Order) (bool, error) {
+	orders, err := ticketOrders(stub, ticket.TicketID, pending)
+	if err != nil {
+		return false, err
+	}
+	return freeSeats(ticket, orders) <= 0, nil
+}
+
+// Helper: a new application, it joins the end of the waitlist if every seat of the ticket is confirmed
+func admitOrder(stub shim.ChaincodeStubInterface, ticket Ticket, order Order) (Order, error) {
+	order.Status = OrderApplied
+	orders, err := ticketOrders(stub, ticket.TicketID, nil)
+	if err != nil {
+		return order, err
+	}
+	if freeSeats(ticket, orders) > 0 {
+		return order, nil
+	}
+	// positions only grow, a withdrawn order does not let later ones overtake
+	order.Status = OrderWaitlisted
+	order.Position = 1
+	for _, other := range orders {
+		if other.Position >= order.Position {
+			order.Position = other.Position + 1
+		}
+	}
+	return order, nil
+}
+
+// Helper: move the waitlist back to Applied once ticket has a free seat, first in line first. The owner confirms
+// them as any other application; later applications are not waitlisted either, so nobody is left behind them.
+func promoteWaitlist(stub shim.ChaincodeStubInterface, ticket Ticket, pending map[string]Order) ([]OrderTransitionResult, error) {
+	results := []OrderTransitionResult{}
+	orders, err := ticketOrders(stub, ticket.TicketID, pending)
+	if err != nil {
+		return nil, err
+	}
+	if freeSeats(ticket, orders) <= 0 {
+		return results, nil
+	}
+	for _, order := range waitlist(orders) {
+		oldStatus := order.Status
+		order.Status = OrderApplied
+		order.Position = 0
+		_, err = OrderSaving(stub, order)
+		if err != nil {
+			return nil, err
+		}
+		pending[order.UserID] = order
+		logger.Info("promoteWaitlist:", ticket.TicketID, order.UserID)
+
+		err = emitEvent(stub, ExchainEvent{Name: EventOrderUpdated, TicketID: ticket.TicketID, UserID: order.UserID,
+			OldStatus: intPtr(oldStatus), NewStatus: intPtr(order.Status)})
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, OrderTransitionResult{UserID: order.UserID, Action: PromoteAction,
+			From: orderStatusName(oldStatus), To: orderStatusName(order.Status)})
+	}
+	return results, nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func (c *testChain) createTicketWithCapacity(userID string, capacity int) string {
+	c.t.Helper()
+	bytes, _ := json.Marshal(map[string]interface{}{"Ticket_Title": "Limited ticket", "Ticket_Type": 0, "Ticket_Value": 10,
+		"Ticket_UserID": userID, "Ticket_Capacity": capacity})
+	c.mustInvoke(userID, "TicketCreate", string(bytes))
+	return c.lastTxID()
+}
+
+func (c *testChain) apply(ticketID string, userID string) Order {
+	c.t.Helper()
+	var order Order
+	c.mustUnmarshal(c.mustInvoke(userID, "OrderCreate", `{"TicketID": "`+ticketID+`", "UserID": "`+userID+`"}`), &order)
+	return order
+}
+
+func TestTicketCapacity(t *testing.T) {
+	c := newExchain(t)
+	second, third, fourth, fifth, sixth, last := "i000005", "i000006", "i000007", "i000008", "i000009", "i000010"
+	for _, userID := range []string{second, third, fourth, fifth, sixth, last} {
+		c.register(userID, SMB, false)
+	}
+	ticketID := c.createTicketWithCapacity(owner, 2)
+	if c.ticket(ticketID).Capacity != 2 {
+		t.Fatal("Ticket_Capacity was not stored")
+	}
+
+	// applications take no seat, the owner chooses among all of them
+	for _, userID := range []string{applicant, other, second} {
+		if order := c.apply(ticketID, userID); order.Status != OrderApplied || order.Position != 0 {
+			t.Errorf("unexpected order %+v", order)
+		}
+	}
+	results := c.orderUpdate(owner, ticketID, "Confirm", applicant, other, second)
+	if results[0].Code != "" || results[1].Code != "" || results[2].Code != ErrCapacityReached {
+		t.Errorf("unexpected results %+v", results)
+	}
+
+	// once every seat is confirmed, further applications wait in line
+	if order := c.apply(ticketID, third); order.Status != OrderWaitlisted || order.Position != 1 {
+		t.Errorf("unexpected order %+v", order)
+	}
+	if order := c.apply(ticketID, fourth); order.Status != OrderWaitlisted || order.Position != 2 {
+		t.Errorf("unexpected order %+v", order)
+	}
+	if c.ticket(ticketID).Status != Ongoing {
+		t.Error("waitlisted orders must not change the ticket status")
+	}
+
+	// rejecting an application or withdrawing from the waitlist frees no seat, later positions still grow
+	if results = c.orderUpdate(owner, ticketID, "Reject", second); len(results) != 1 {
+		t.Errorf("unexpected results %+v", results)
+	}
+	c.orderUpdate(fourth, ticketID, "Withdraw", fourth)
+	if order := c.apply(ticketID, fifth); order.Status != OrderWaitlisted || order.Position != 3 {
+		t.Errorf("unexpected order %+v", order)
+	}
+
+	// a withdrawn seat returns the waitlist to Applied, in line and in the same transaction
+	c.events()
+	results = c.orderUpdate(applicant, ticketID, "Withdraw", applicant)
+	if len(results) != 3 || results[1].UserID != third || results[2].UserID != fifth || results[1].Action != PromoteAction ||
+		results[1].From != "Waitlisted" || results[1].To != "Applied" {
+		t.Errorf("unexpected results %+v", results)
+	}
+	if order := c.order(ticketID, third); order.Status != OrderApplied || order.Position != 0 {
+		t.Errorf("unexpected order %+v", order)
+	}
+	if got := c.events(); !equalStrings(got, []string{EventBatch}) {
+		t.Errorf("unexpected events %v", got)
+	}
+
+	// the owner confirms one of them, the other one stays an application
+	results = c.orderUpdate(owner, ticketID, "Confirm", third, fifth)
+	if results[0].Code != "" || results[1].Code != ErrCapacityReached || c.order(ticketID, fifth).Status != OrderApplied {
+		t.Errorf("unexpected results %+v", results)
+	}
+
+	// done and awarded orders keep their seat
+	c.orderUpdate(owner, ticketID, "Done", other)
+	c.orderUpdate(admin, ticketID, "Award", other)
+	if order := c.apply(ticketID, sixth); order.Status != OrderWaitlisted {
+		t.Errorf("unexpected order %+v", order)
+	}
+
+	// the capacity can not drop below the confirmed seats, a larger one promotes the waitlist
+	ticket := c.ticket(ticketID)
+	ticket.Capacity = 1
+	c.mustFail("Ticket_Capacity can not drop below the 2 seats already taken", owner, "TicketUpdate", ticketUpdateJSON(ticket))
+	ticket.Capacity = 3
+	c.mustInvoke(owner, "TicketUpdate", ticketUpdateJSON(ticket))
+	if c.order(ticketID, sixth).Status != OrderApplied {
+		t.Error("a free seat must promote the waitlist")
+	}
+
+	// a closed seat frees it as well, without capacity nobody waits
+	c.orderUpdate(owner, ticketID, "Confirm", fifth)
+	c.apply(ticketID, admin)
+	results = c.orderUpdate(owner, ticketID, "Close", third)
+	if len(results) != 2 || results[1].UserID != admin || results[1].Action != PromoteAction {
+		t.Errorf("unexpected results %+v", results)
+	}
+	c.orderUpdate(owner, ticketID, "Confirm", sixth)
+	if order := c.apply(ticketID, last); order.Status != OrderWaitlisted {
+		t.Errorf("unexpected order %+v", order)
+	}
+	ticket.Capacity = 0
+	c.mustInvoke(owner, "TicketUpdate", ticketUpdateJSON(ticket))
+	if c.order(ticketID, last).Status != OrderApplied {
+		t.Error("the waitlist must be promoted as a whole")
+	}
+}
+
+func TestTicketWithoutCapacity(t *testing.T) {
+	c := newExchain(t)
+	ticketID := c.createTicket(owner, 0, 10, "")
+	c.apply(ticketID, applicant)
+	c.apply(ticketID, other)
+	results := c.orderUpdate(owner, ticketID, "Confirm", applicant, other)
+	if results[0].Code != "" || results[1].Code != "" {
+		t.Errorf("unexpected results %+v", results)
+	}
+
+	c.mustFail("field Ticket_Capacity must be at least 0", owner, "TicketCreate",
+		`{"Ticket_Title": "t", "Ticket_Type": 0, "Ticket_Value": 1, "Ticket_UserID": "`+owner+`", "Ticket_Capacity": -1}`)
+}
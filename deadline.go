@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// TicketExpiryReport information, response of ExpireTickets
+//
+//	Timestamp:      transaction timestamp the deadlines were compared with
+//	Expired:        TicketIDs moved to Expired
+//	ClosedOrders:   "TicketID/UserID" of the open orders closed with them
+//	Refunded:       credit of their escrows handed back to the funders
+type TicketExpiryReport struct {
+	Timestamp    time.Time `json:"Timestamp"`
+	Expired      []string  `json:"Expired"`
+	ClosedOrders []string  `json:"ClosedOrders"`
+	Refunded     int       `json:"Refunded"`
+}
+
+// Helper: whether the deadline of ticket is over at now, tickets without deadline never expire
+func deadlinePassed(ticket Ticket, now time.Time) bool {
+	return !ticket.DeadLine.IsZero() && !now.Before(ticket.DeadLine)
+}
+
+// Helper: refuse an order on ticket at now
+func checkTicketOpen(function string, ticket Ticket, now time.Time) error {
+	if ticket.Status == Expired || deadlinePassed(ticket, now) {
+		return newError(ErrDeadlinePassed, function+": The deadline "+ticket.DeadLine.Format(time.RFC3339)+" of ticket "+ticket.TicketID+" has passed").
+			on(TicketObjectType, ticket.TicketID)
+	}
+	return nil
+}
+
+// Helper: tickets not yet expired whose deadline is over at now, awarded ones included
+func overdueTickets(stub shim.ChaincodeStubInterface, now time.Time) ([]Ticket, error) {
+	var overdue []Ticket
+	iterator, err := stub.GetStateByPartialCompositeKey(TicketObjectType, []string{})
+	if err != nil {
+		return nil, errInternal("overdueTickets: " + err.Error())
+	}
+	defer iterator.Close()
+
+	for iterator.HasNext() {
+		queryResponse, err := iterator.Next()
+		if err != nil {
+			return nil, errInternal("overdueTickets: " + err.Error())
+		}
+		var ticket Ticket
+		err = json.Unmarshal(queryResponse.Value, &ticket)
+		if err != nil {
+			return nil, errInternal("overdueTickets: Corrupt ticket " + queryResponse.Key)
+		}
+		if ticket.Status != Expired && deadlinePassed(ticket, now) {
+			overdue = append(overdue, ticket)
+		}
+	}
+	return overdue, nil
+}
+
+// Helper: close the open orders of ticket, they are the ones the Close transition accepts
+func closeOrders(stub shim.ChaincodeStubInterface, ticketID string) ([]string, error) {
+	var orders []Order
+	iterator, err := stub.GetStateByPartialCompositeKey(OrderObjectType, []string{ticketID})
+	if err != nil {
+		return nil, errInternal("closeOrders: " + err.Error())
+	}
+	for iterator.HasNext() {
+		queryResponse, err := iterator.Next()
+		if err != nil {
+			iterator.Close()
+			return nil, errInternal("closeOrders: " + err.Error())
+		}
+		var order Order
+		if json.Unmarshal(queryResponse.Value, &order) != nil {
+			continue
+		}
+		orders = append(orders, order)
+	}
+	iterator.Close()
+
+	var closed []string
+	for _, order := range orders {
+		if !orderClosable(order.Status) {
+			continue
+		}
+		oldStatus := order.Status
+		order.Status = OrderClosed
+		_, err = OrderSaving(stub, order)
+		if err != nil {
+			return nil, err
+		}
+		err = emitEvent(stub, ExchainEvent{Name: EventOrderUpdated, TicketID: ticketID, UserID: order.UserID,
+			OldStatus: intPtr(oldStatus), NewStatus: intPtr(order.Status)})
+		if err != nil {
+			return nil, err
+		}
+		closed = append(closed, orderID(ticketID, order.UserID))
+	}
+	return closed, nil
+}
+
+// Helper: remove every order of a deleted ticket; the open ones are closed first, so their users get the event
+func deleteOrders(stub shim.ChaincodeStubInterface, ticketID string) ([]string, error) {
+	closed, err := closeOrders(stub, ticketID)
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	iterator, err := stub.GetStateByPartialCompositeKey(OrderObjectType, []string{ticketID})
+	if err != nil {
+		return nil, errInternal("deleteOrders: " + err.Error())
+	}
+	for iterator.HasNext() {
+		queryResponse, err := iterator.Next()
+		if err != nil {
+			iterator.Close()
+			return nil, errInternal("deleteOrders: " + err.Error())
+		}
+		keys = append(keys, queryResponse.Key)
+	}
+	iterator.Close()
+
+	for _, key := range keys {
+		err = stub.DelState(key)
+		if err != nil {
+			return nil, errInternal("deleteOrders: " + err.Error())
+		}
+	}
+	return closed, nil
+}
+
+// Helper: move an open ticket to Expired and close its open orders, returns the closed orders as "TicketID/UserID"
+func expireTicket(stub shim.ChaincodeStubInterface, ticket Ticket) ([]string, error) {
+	closed, err := closeOrders(stub, ticket.TicketID)
+	if err != nil {
+		return nil, err
+	}
+	oldStatus := ticket.Status
+	ticket.Status = Expired
+	_, err = saveTicket(stub, ticket)
+	if err != nil {
+		return nil, err
+	}
+	err = emitEvent(stub, ExchainEvent{Name: EventTicketExpired, TicketID: ticket.TicketID, UserID: ticket.UserID,
+		OldStatus: intPtr(oldStatus), NewStatus: intPtr(ticket.Status)})
+	if err != nil {
+		return nil, err
+	}
+	return closed, nil
+}
+
+// Invoke Route: ExpireTickets
+//
+//	args: none
+//	moves every ticket whose deadline is over at the transaction timestamp to Expired and closes its open
+//	orders; awarded tickets keep their status. Both hand what is left of their escrow back to the funder.
+//	Returns a TicketExpiryReport
+func (sc *SmartContract) ExpireTickets(stub shim.ChaincodeStubInterface) peer.Response {
+	now, err := txTime(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	overdue, err := overdueTickets(stub, now)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	report := TicketExpiryReport{Timestamp: now, Expired: []string{}, ClosedOrders: []string{}}
+	var refunds []creditMovement
+	for _, ticket := range overdue {
+		if ticket.Status < Awarded {
+			closed, err := expireTicket(stub, ticket)
+			if err != nil {
+				return errorResponse(err)
+			}
+			report.Expired = append(report.Expired, ticket.TicketID)
+			report.ClosedOrders = append(report.ClosedOrders, closed...)
+		}
+
+		movements, err := refundEscrow(stub, ticket.TicketID)
+		if err != nil {
+			return errorResponse(err)
+		}
+		for _, movement := range movements {
+			report.Refunded += movement.Delta
+		}
+		refunds = append(refunds, movements...)
+	}
+	// ==== One call for all tickets, funders of several of them are read and written once ====
+	if len(refunds) > 0 {
+		_, err = applyCreditMovements(stub, refunds)
+		if err != nil {
+			return errorResponse(err)
+		}
+	}
+	logger.Info("ExpireTickets:", now, report.Expired)
+
+	reportAsBytes, err := json.Marshal(report)
+	if err != nil {
+		return errorResponse(errInternal("ExpireTickets: " + err.Error()))
+	}
+	return shim.Success(reportAsBytes)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestString2Time(t *testing.T) {
+	theTime, err := string2time("2030-01-01T09:30:00+02:00")
+	if err != nil || theTime.Location() != time.UTC || theTime.Format(time.RFC3339) != "2030-01-01T07:30:00Z" {
+		t.Errorf("unexpected time %v, %v", theTime, err)
+	}
+	for _, st := range []string{"2018-11-26 18:05:00", "2030-01-01", ""} {
+		if _, err := string2time(st); err == nil {
+			t.Errorf("%q is not RFC 3339", st)
+		}
+	}
+}
+
+func TestTicketDeadline(t *testing.T) {
+	c := newExchain(t)
+	c.clock = time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	// deadlines are stored in UTC and must lie ahead
+	ticketID := c.createTicket(owner, 0, 10, "2030-01-02T08:00:00+02:00")
+	if deadline := c.ticket(ticketID).DeadLine; deadline.Location() != time.UTC || !deadline.Equal(time.Date(2030, 1, 2, 6, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected deadline %v", deadline)
+	}
+	c.mustFail("Ticket_Deadline must be after the transaction time 2030-01-01T12:00:00Z", owner, "TicketCreate",
+		`{"Ticket_Title": "t", "Ticket_Type": 0, "Ticket_Value": 1, "Ticket_UserID": "`+owner+`", "Ticket_Deadline": "2030-01-01T12:00:00Z"}`)
+
+	c.mustInvoke(applicant, "OrderCreate", `{"TicketID": "`+ticketID+`", "UserID": "`+applicant+`"}`)
+
+	// the transaction timestamp decides, not the clock of the peer
+	c.clock = time.Date(2030, 1, 2, 6, 0, 0, 0, time.UTC)
+	e := c.failure(other, "OrderCreate", `{"TicketID": "`+ticketID+`", "UserID": "`+other+`"}`)
+	if e.Code != ErrDeadlinePassed || e.Status != 409 || e.Entity != TicketObjectType || e.ID != ticketID {
+		t.Errorf("unexpected error %+v", e)
+	}
+
+	e = c.failure(other, "OrderCreate", `{"TicketID": "no-such-ticket", "UserID": "`+other+`"}`)
+	if e.Code != ErrNotFound || e.Entity != TicketObjectType {
+		t.Errorf("unexpected error %+v", e)
+	}
+}
+
+func TestExpireTickets(t *testing.T) {
+	c := newExchain(t)
+	c.clock = time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	apply := func(ticketID string, userID string) {
+		c.mustInvoke(userID, "OrderCreate", `{"TicketID": "`+ticketID+`", "UserID": "`+userID+`"}`)
+	}
+
+	overdue := c.createTicket(owner, 0, 10, "2030-01-10T00:00:00Z")
+	apply(overdue, applicant)
+	apply(overdue, other)
+	c.orderUpdate(owner, overdue, "Confirm", applicant)
+	c.orderUpdate(owner, overdue, "Reject", other)
+
+	awarded := c.createTicket(owner, 0, 10, "2030-01-10T00:00:00Z")
+	apply(awarded, applicant)
+	c.orderUpdate(owner, awarded, "Confirm", applicant)
+	c.orderUpdate(owner, awarded, "Done", applicant)
+	c.orderUpdate(admin, awarded, "Award", applicant)
+
+	future := c.createTicket(owner, 0, 10, "2030-02-01T00:00:00Z")
+	apply(future, applicant)
+	open := c.createTicket(owner, 0, 10, "")
+
+	c.clock = time.Date(2030, 1, 10, 0, 0, 0, 0, time.UTC)
+	c.mustFail("Forbidden", owner, "ExpireTickets")
+	c.events()
+
+	var report TicketExpiryReport
+	c.mustUnmarshal(c.mustInvoke(admin, "ExpireTickets"), &report)
+	if !report.Timestamp.Equal(c.clock) || !equalStrings(report.Expired, []string{overdue}) ||
+		!equalStrings(report.ClosedOrders, []string{orderID(overdue, applicant)}) {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if got := c.events(); !equalStrings(got, []string{EventBatch}) {
+		t.Errorf("unexpected events %v", got)
+	}
+
+	if c.ticket(overdue).Status != Expired || c.order(overdue, applicant).Status != OrderClosed || c.order(overdue, other).Status != OrderRejected {
+		t.Error("the overdue ticket and its open orders must be closed")
+	}
+	if c.ticket(awarded).Status != Awarded || c.ticket(future).Status != Applied || c.ticket(open).Status != Applied {
+		t.Error("only the overdue ticket may expire")
+	}
+
+	// Expired is final
+	c.mustInvoke(owner, "AutoUpdateTicketStatus", overdue)
+	if c.ticket(overdue).Status != Expired {
+		t.Error("AutoUpdateTicketStatus revived an expired ticket")
+	}
+	if e := c.failure(admin, "OrderCreate", `{"TicketID": "`+overdue+`", "UserID": "`+admin+`"}`); e.Code != ErrDeadlinePassed {
+		t.Errorf("unexpected error %+v", e)
+	}
+
+	// the sweep is idempotent
+	c.mustUnmarshal(c.mustInvoke(admin, "ExpireTickets"), &report)
+	if len(report.Expired) != 0 || len(report.ClosedOrders) != 0 {
+		t.Errorf("unexpected second report %+v", report)
+	}
+
+	// Expired is final for TicketUpdate too, and an overdue deadline can not move
+	ticket := c.ticket(overdue)
+	ticket.Comment = "reopen"
+	if e := c.failure(owner, "TicketUpdate", ticketUpdateJSON(ticket)); e.Code != ErrDeadlinePassed || e.ID != overdue {
+		t.Errorf("unexpected error %+v", e)
+	}
+	late := c.createTicket(owner, 0, 10, "2030-01-11T00:00:00Z")
+	c.clock = time.Date(2030, 1, 12, 0, 0, 0, 0, time.UTC)
+	ticket = c.ticket(late)
+	ticket.DeadLine = time.Time{}
+	c.mustFail("has passed and can not move", owner, "TicketUpdate", ticketUpdateJSON(ticket))
+	ticket.DeadLine = time.Date(2030, 2, 1, 0, 0, 0, 0, time.UTC)
+	c.mustFail("has passed and can not move", owner, "TicketUpdate", ticketUpdateJSON(ticket))
+	ticket = c.ticket(future)
+	ticket.DeadLine = time.Date(2030, 1, 11, 0, 0, 0, 0, time.UTC)
+	c.mustFail("Ticket_Deadline must be after the transaction time", owner, "TicketUpdate", ticketUpdateJSON(ticket))
+	ticket.DeadLine = time.Date(2030, 3, 1, 0, 0, 0, 0, time.UTC)
+	c.mustInvoke(owner, "TicketUpdate", ticketUpdateJSON(ticket))
+	if updated := c.ticket(future); updated.Status != Applied || !updated.DeadLine.Equal(ticket.DeadLine) {
+		t.Errorf("unexpected ticket %+v", updated)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// Error codes, stable identifiers clients can switch on; the message text may change
+//
+//	BadRequest:          malformed arguments, see signature.go                         400
+//	UnknownFunction:     no such Invoke route                                          400
+//	Forbidden:           the caller may not do this, see access.go                     403
+//	NotFound:            the entity does not exist                                     404
+//	AlreadyExists:       the entity exists already                                     409
+//	Conflict:            the request contradicts the ledger, e.g. a repeated reversal  409
+//	InvalidTransition:   the order or ticket status does not allow it                  409
+//	InsufficientCredit:  a debit would make a balance negative                         409
+//	DeadlinePassed:      the ticket no longer takes orders                             409
+//	CapacityReached:     every seat of the ticket is taken                             409
+//	BudgetExceeded:      an award would pay out more than Ticket_Value                 409
+//	Internal:            ledger or marshalling failure                                 500
+//	Unsupported:         rich queries on LevelDB, history without history database     501
+const (
+	ErrBadRequest         = "BAD_REQUEST"
+	ErrUnknownFunction    = "UNKNOWN_FUNCTION"
+	ErrForbidden          = "FORBIDDEN"
+	ErrNotFound           = "NOT_FOUND"
+	ErrAlreadyExists      = "ALREADY_EXISTS"
+	ErrConflict           = "CONFLICT"
+	ErrInvalidTransition  = "INVALID_TRANSITION"
+	ErrInsufficientCredit = "INSUFFICIENT_CREDIT"
+	ErrDeadlinePassed     = "DEADLINE_PASSED"
+	ErrCapacityReached    = "CAPACITY_REACHED"
+	ErrBudgetExceeded     = "BUDGET_EXCEEDED"
+	ErrInternal           = "INTERNAL"
+	ErrUnsupported        = "UNSUPPORTED"
+)
+
+// errorStatuses maps every error code to the HTTP-like status of its response
+var errorStatuses = map[string]int32{
+	ErrBadRequest:         400,
+	ErrUnknownFunction:    400,
+	ErrForbidden:          403,
+	ErrNotFound:           404,
+	ErrAlreadyExists:      409,
+	ErrConflict:           409,
+	ErrInvalidTransition:  409,
+	ErrInsufficientCredit: 409,
+	ErrDeadlinePassed:     409,
+	ErrCapacityReached:    409,
+	ErrBudgetExceeded:     409,
+	ErrInternal:           500,
+	ErrUnsupported:        501,
+}
+
+// ChaincodeError information, JSON encoded as the Message of a failed response
+//
+//	Code:      one of the Err* codes
+//	Status:    errorStatuses[Code]
+//	Message:   human readable description
+//	Entity:    object type the error is about, e.g. Ticket, empty if none
+//	ID:        ID of that entity, "TicketID/UserID" for orders
+//	Details:   individual problems, e.g. every invalid field of a bad request
+type ChaincodeError struct {
+	Code    string   `json:"code"`
+	Status  int32    `json:"status"`
+	Message string   `json:"message"`
+	Entity  string   `json:"entity,omitempty"`
+	ID      string   `json:"id,omitempty"`
+	Details []string `json:"details,omitempty"`
+}
+
+func (e *ChaincodeError) Error() string {
+	return e.Message
+}
+
+func newError(code string, message string) *ChaincodeError {
+	return &ChaincodeError{Code: code, Status: errorStatuses[code], Message: message}
+}
+
+func (e *ChaincodeError) on(entity string, id string) *ChaincodeError {
+	e.Entity, e.ID = entity, id
+	return e
+}
+
+func (e *ChaincodeError) with(details ...string) *ChaincodeError {
+	e.Details = append(e.Details, details...)
+	return e
+}
+
+func errNotFound(entity string, id string) *ChaincodeError {
+	return newError(ErrNotFound, entity+" "+id+" does not exist").on(entity, id)
+}
+
+func errAlreadyExists(entity string, id string) *ChaincodeError {
+	return newError(ErrAlreadyExists, entity+" "+id+" already exists").on(entity, id)
+}
+
+func errInternal(message string) *ChaincodeError {
+	return newError(ErrInternal, message)
+}
+
+func orderID(ticketID string, userID string) string {
+	return ticketID + "/" + userID
+}
+
+// Helper: response of a failed route, untyped errors are reported as Internal
+func errorResponse(err error) peer.Response {
+	e, ok := err.(*ChaincodeError)
+	if !ok {
+		e = errInternal(err.Error())
+	}
+	// keep <, > and & readable for the gateway
+	var buffer bytes.Buffer
+	encoder := json.NewEncoder(&buffer)
+	encoder.SetEscapeHTML(false)
+	if encoder.Encode(e) != nil {
+		return peer.Response{Status: errorStatuses[ErrInternal], Message: e.Message}
+	}
+	logger.Error(e.Code, e.Message)
+	return peer.Response{Status: e.Status, Message: strings.TrimSpace(buffer.String())}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+func TestErrorCodes(t *testing.T) {
+	c := newExchain(t)
+	c.mustInvoke(owner, "TicketCreate", ticketJSON(owner, 50))
+	ticketID := c.lastTxID()
+	apply := `{"TicketID": "` + ticketID + `", "UserID": "` + applicant + `"}`
+	c.mustInvoke(applicant, "OrderCreate", apply)
+
+	cases := []struct {
+		name   string
+		err    ChaincodeError
+		code   string
+		status int32
+		entity string
+		id     string
+	}{
+		{"unknown function", c.failure("", "NoSuchFunction"), ErrUnknownFunction, 400, "Function", "NoSuchFunction"},
+		{"malformed JSON", c.failure(owner, "TicketCreate", "{"), ErrBadRequest, 400, "", ""},
+		{"not the owner", c.failure(other, "TicketDelete", ticketID), ErrForbidden, 403, "", ""},
+		{"no participant", c.failure("i999999", "OrderUpdate", orderUpdate(ticketID, "Confirm", applicant)), ErrForbidden, 403, "", ""},
+		{"missing ticket", c.failure("", "TicketRead", "no-such-ticket"), ErrNotFound, 404, TicketObjectType, "no-such-ticket"},
+		{"missing ticket of an owner route", c.failure(owner, "TicketDelete", "no-such-ticket"), ErrNotFound, 404, TicketObjectType, "no-such-ticket"},
+		{"missing participant", c.failure("", "readParticipant", "i999999"), ErrNotFound, 404, ParticipantObjectType, "i999999"},
+		{"missing credit", c.failure("", "CreditRead", "i999999"), ErrNotFound, 404, CreditObjectType, "i999999"},
+		{"missing LoB", c.failure("", "LoBRead", "8"), ErrNotFound, 404, LoBObjectType, "8"},
+		{"missing order", c.failure("", "OrderRead", ticketID, other), ErrNotFound, 404, OrderObjectType, ticketID + "/" + other},
+		{"participant exists", c.failure(owner, "addParticipant", participantJSON(owner, HANA, false)), ErrAlreadyExists, 409, ParticipantObjectType, owner},
+		{"order exists", c.failure(applicant, "OrderCreate", apply), ErrAlreadyExists, 409, OrderObjectType, ticketID + "/" + applicant},
+		{"overdraft", c.failure(applicant, "CreditTransfer", `{"From": "`+applicant+`", "To": "`+owner+`", "Value": 1}`), ErrInsufficientCredit, 409, CreditObjectType, applicant},
+	}
+	for _, tc := range cases {
+		if tc.err.Code != tc.code || tc.err.Status != tc.status || tc.err.Entity != tc.entity || tc.err.ID != tc.id {
+			t.Errorf("%s: unexpected error %+v", tc.name, tc.err)
+		}
+	}
+
+	// every invalid field is a detail of its own
+	e := c.failure(owner, "TicketCreate", `{"Ticket_Title": "", "Ticket_Type": 0, "Ticket_Value": -1, "Ticket_UserID": "`+owner+`"}`)
+	if len(e.Details) != 2 || e.Details[0] != "field Ticket_Title must not be empty" || e.Details[1] != "field Ticket_Value must be at least 0" {
+		t.Errorf("unexpected details %q", e.Details)
+	}
+
+	// per order outcomes of OrderUpdate carry a code too
+	results := c.orderUpdate(owner, ticketID, "Done", applicant, "i999999")
+	if results[0].Code != ErrInvalidTransition || results[1].Code != ErrNotFound {
+		t.Errorf("unexpected results %+v", results)
+	}
+	results = c.orderUpdate(applicant, ticketID, "Confirm", applicant)
+	if results[0].Code != ErrForbidden {
+		t.Errorf("unexpected results %+v", results)
+	}
+}
+
+func TestUntypedErrorsAreInternal(t *testing.T) {
+	e := chaincodeError(t, errorResponse(errors.New("saveTicket: <disk full>")))
+	if e.Code != ErrInternal || e.Status != 500 || e.Message != "saveTicket: <disk full>" {
+		t.Errorf("unexpected error %+v", e)
+	}
+
+	e = chaincodeError(t, errorResponse(errInternal("TicketCreate: disk full")))
+	if e.Code != ErrInternal || e.Message != "TicketCreate: disk full" {
+		t.Errorf("unexpected error %+v", e)
+	}
+
+	// helpers report missing records and invalid input with their own codes
+	c := newExchain(t)
+	response := c.run("", func(stub *testStub) peer.Response {
+		_, err := updateLoBCredit(stub, 99, lobDelta{Credit: 1})
+		return errorResponse(err)
+	})
+	if e = chaincodeError(t, response); e.Code != ErrNotFound || e.Entity != LoBObjectType || e.ID != "99" {
+		t.Errorf("unexpected error %+v", e)
+	}
+	response = c.run("", func(stub *testStub) peer.Response { return errorResponse(savePassword(stub, applicant, "", nil)) })
+	if e = chaincodeError(t, response); e.Code != ErrBadRequest || e.Status != 400 {
+		t.Errorf("unexpected error %+v", e)
+	}
+
+	// the status of the response is the one of the code
+	response = errorResponse(newError(ErrConflict, "conflict"))
+	if response.Status != 409 {
+		t.Errorf("unexpected status %d", response.Status)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// Funding sources of Ticket_Funding, awards of tickets without one are new credit
+//
+//	Credit:   the credit of the ticket owner
+//	LoB:      the LoB_Budget of the owner's LoB
+const (
+	FundingCredit = "Credit"
+	FundingLoB    = "LoB"
+)
+
+// Escrow information, stored under the composite key ("Escrow", TicketID) of a funded ticket
+//
+//	Source:      FundingCredit or FundingLoB
+//	UserID:      ticket owner, debited for a Credit escrow
+//	LoBID:       LoB of the owner when the ticket was created, debited for a LoB escrow
+//	Locked:      credit still held for awards
+//	Released:    credit paid out to awarded users
+//	Refunded:    credit handed back to the funder once the ticket was settled, expired or deleted
+//
+// Locked + Released + Refunded is always the Ticket_Value locked at TicketCreate
+type Escrow struct {
+	TicketID string `json:"TicketID"`
+	Source   string `json:"Source"`
+	UserID   string `json:"UserID"`
+	LoBID    int    `json:"LoBID"`
+	Locked   int    `json:"Locked"`
+	Released int    `json:"Released"`
+	Refunded int    `json:"Refunded"`
+}
+
+// Helper: what is wrong with the funding of a new ticket, nil if nothing
+func validateFunding(ticket Ticket) []string {
+	switch ticket.Funding {
+	case "":
+		return nil
+	case FundingCredit, FundingLoB:
+	default:
+		return []string{"Ticket_Funding must be Credit or LoB"}
+	}
+	if ticket.Value <= 0 {
+		return []string{"a funded ticket needs a positive Ticket_Value"}
+	}
+	return nil
+}
+
+// Helper: the escrow of ticketID, nil if the ticket is not funded
+func retrieveEscrow(stub shim.ChaincodeStubInterface, ticketID string) (*Escrow, error) {
+	bytes, err := stub.GetState(escrowKey(stub, ticketID))
+	if err != nil {
+		return nil, errInternal("retrieveEscrow: Error getting escrow of ticket " + ticketID)
+	}
+	if bytes == nil {
+		return nil, nil
+	}
+	var escrow Escrow
+	err = json.Unmarshal(bytes, &escrow)
+	if err != nil {
+		return nil, errInternal("retrieveEscrow: Corrupt escrow of ticket " + ticketID)
+	}
+	return &escrow, nil
+}
+
+func saveEscrow(stub shim.ChaincodeStubInterface, escrow Escrow) error {
+	bytes, err := json.Marshal(escrow)
+	if err != nil {
+		return errInternal("saveEscrow: " + err.Error())
+	}
+	err = stub.PutState(escrowKey(stub, escrow.TicketID), bytes)
+	if err != nil {
+		return errInternal("saveEscrow: " + err.Error())
+	}
+	return nil
+}
+
+// Helper: the movement of delta credit from or to the funder of escrow
+func fundingMovement(escrow Escrow, delta int, reason string) creditMovement {
+	if escrow.Source == FundingLoB {
+		return creditMovement{Budget: true, LoBID: escrow.LoBID, Delta: delta}
+	}
+	return creditMovement{UserID: escrow.UserID, Delta: delta, Reason: reason, Ref: escrow.TicketID}
+}
+
+// Helper: lock the Ticket_Value of a new funded ticket, returns the debit of the funder for applyCreditMovements
+func lockEscrow(stub shim.ChaincodeStubInterface, ticket Ticket) (creditMovement, error) {
+	escrow := Escrow{TicketID: ticket.TicketID, Source: ticket.Funding, UserID: ticket.UserID, Locked: ticket.Value}
+	if ticket.Funding == FundingLoB {
+		lobID, ok, err := participantLoBID(stub, ticket.UserID)
+		if err != nil {
+			return creditMovement{}, errInternal(err.Error())
+		}
+		if !ok {
+			return creditMovement{}, errNotFound(ParticipantObjectType, ticket.UserID)
+		}
+		escrow.LoBID = lobID
+	}
+	err := saveEscrow(stub, escrow)
+	if err != nil {
+		return creditMovement{}, err
+	}
+	err = emitEvent(stub, ExchainEvent{Name: EventEscrowLocked, TicketID: ticket.TicketID, UserID: ticket.UserID, Delta: ticket.Value})
+	if err != nil {
+		return creditMovement{}, err
+	}
+	return fundingMovement(escrow, -ticket.Value, JournalEscrow), nil
+}
+
+// Helper: whether the orders of ticketID are settled: at least one was awarded and none can still be awarded
+func ticketSettled(stub shim.ChaincodeStubInterface, ticketID string, pending map[string]Order) (bool, error) {
+	orders, err := ticketOrders(stub, ticketID, pending)
+	if err != nil {
+		return false, err
+	}
+	awarded := false
+	for _, order := range orders {
+		switch order.Status {
+		case OrderAwarded:
+			awarded = true
+		case OrderClosed, OrderRejected, OrderWithdrawn:
+		default:
+			return false, nil
+		}
+	}
+	return awarded, nil
+}
+
+// Helper: pay the credit of an award out of the escrow of ticketID; once the ticket is settled the rest goes back
+// to the funder, returned as movements for applyCreditMovements. Tickets without escrow have nothing to release
+func releaseEscrow(stub shim.ChaincodeStubInterface, ticketID string, paid int, settled bool) ([]creditMovement, error) {
+	if paid == 0 && !settled {
+		return nil, nil
+	}
+	escrow, err := retrieveEscrow(stub, ticketID)
+	if err != nil || escrow == nil {
+		return nil, err
+	}
+	if paid > escrow.Locked {
+		return nil, newError(ErrBudgetExceeded, fmt.Sprintf("Award: %d credit exceeds the %d credit locked for ticket %s",
+			paid, escrow.Locked, ticketID)).on(TicketObjectType, ticketID)
+	}
+	escrow.Locked -= paid
+	escrow.Released += paid
+	var refunds []creditMovement
+	if settled {
+		refunds, err = refundLocked(stub, escrow)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return refunds, saveEscrow(stub, *escrow)
+}
+
+// Helper: hand the credit still locked for ticketID back to its funder, returns the movements for applyCreditMovements
+func refundEscrow(stub shim.ChaincodeStubInterface, ticketID string) ([]creditMovement, error) {
+	escrow, err := retrieveEscrow(stub, ticketID)
+	if err != nil || escrow == nil || escrow.Locked == 0 {
+		return nil, err
+	}
+	refunds, err := refundLocked(stub, escrow)
+	if err != nil {
+		return nil, err
+	}
+	return refunds, saveEscrow(stub, *escrow)
+}
+
+// Helper: move what escrow still locks to Refunded, the caller saves the escrow
+func refundLocked(stub shim.ChaincodeStubInterface, escrow *Escrow) ([]creditMovement, error) {
+	refund := escrow.Locked
+	if refund == 0 {
+		return nil, nil
+	}
+	escrow.Refunded += refund
+	escrow.Locked = 0
+	logger.Info("refundEscrow:", escrow.TicketID, escrow.Source, refund)
+
+	err := emitEvent(stub, ExchainEvent{Name: EventEscrowRefunded, TicketID: escrow.TicketID, UserID: escrow.UserID, Delta: refund})
+	if err != nil {
+		return nil, err
+	}
+	return []creditMovement{fundingMovement(*escrow, refund, JournalRefund)}, nil
+}
+
+// Invoke Route: EscrowRead
+//
+//	args[0]: TicketID of a funded ticket
+func (sc *SmartContract) EscrowRead(stub shim.ChaincodeStubInterface, ticketID string) peer.Response {
+	escrow, err := retrieveEscrow(stub, ticketID)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if escrow == nil {
+		return errorResponse(errNotFound(EscrowObjectType, ticketID))
+	}
+	escrowAsBytes, err := json.Marshal(escrow)
+	if err != nil {
+		return errorResponse(errInternal("EscrowRead: " + err.Error()))
+	}
+	return shim.Success(escrowAsBytes)
+}
+
+// Invoke Route: LoBFund
+//
+//	args[0]: {"LoBID": 2, "Value": 100}
+//	adds Value to the LoB_Budget its participants can lock into the escrow of their tickets
+func (sc *SmartContract) LoBFund(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	var request struct {
+		LoBID int `json:"LoBID"`
+		Value int `json:"Value"`
+	}
+	err := json.Unmarshal([]byte(args[0]), &request)
+	if err != nil {
+		return errorResponse(badRequest("LoBFund", err.Error()))
+	}
+
+	lob, err := activeLoB(stub, "LoBFund", request.LoBID)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	_, err = applyCreditMovements(stub, []creditMovement{{Budget: true, LoBID: request.LoBID, Delta: request.Value}})
+	if err != nil {
+		return errorResponse(err)
+	}
+	logger.Info("LoBFund:", request.LoBID, request.Value)
+
+	// ==== GetState does not see the write above ====
+	lob.Budget += request.Value
+	lobAsBytes, err := json.Marshal(lob)
+	if err != nil {
+		return errorResponse(errInternal("LoBFund: " + err.Error()))
+	}
+	return shim.Success(lobAsBytes)
+}
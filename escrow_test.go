@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fundedTicketJSON - ticket of owner paying a Fixed amount per awarded user out of funding
+func fundedTicketJSON(funding string, value int, amount int, deadline string) string {
+	fields := map[string]interface{}{"Ticket_Title": "Funded ticket", "Ticket_Type": 0, "Ticket_Value": value, "Ticket_UserID": owner,
+		"Ticket_Funding": funding, "Ticket_Award": map[string]interface{}{"Kind": AwardFixed, "Amount": amount}}
+	if deadline != "" {
+		fields["Ticket_Deadline"] = deadline
+	}
+	bytes, _ := json.Marshal(fields)
+	return string(bytes)
+}
+
+func (c *testChain) escrow(ticketID string) Escrow {
+	c.t.Helper()
+	var escrow Escrow
+	c.mustUnmarshal(c.mustInvoke("", "EscrowRead", ticketID), &escrow)
+	return escrow
+}
+
+func (c *testChain) lobBudget(LoBID int) int {
+	c.t.Helper()
+	var lobs []LoB
+	c.mustUnmarshal(c.mustInvoke("", "LoBReadAll"), &lobs)
+	return lobs[LoBID].Budget
+}
+
+func TestEscrowFromCredit(t *testing.T) {
+	c := newExchain(t)
+	c.mustInvoke(admin, "CreditAdd", `{"userID": "`+owner+`", "value": 100, "ticketID": "creditADD"}`)
+
+	// the value leaves the owner's credit when the ticket is created
+	c.mustInvoke(owner, "TicketCreate", fundedTicketJSON(FundingCredit, 30, 10, ""))
+	ticketID := c.lastTxID()
+	if c.credit(owner) != 70 || c.lobTotal(HANA) != 70 || c.ticket(ticketID).Funding != FundingCredit {
+		t.Errorf("unexpected credit %d, LoB total %d", c.credit(owner), c.lobTotal(HANA))
+	}
+	if escrow := c.escrow(ticketID); escrow != (Escrow{TicketID: ticketID, Source: FundingCredit, UserID: owner, Locked: 30}) {
+		t.Errorf("unexpected escrow %+v", escrow)
+	}
+
+	// nothing is created when the owner can not cover the value
+	e := c.failure(owner, "TicketCreate", fundedTicketJSON(FundingCredit, 71, 10, ""))
+	if e.Code != ErrInsufficientCredit || e.Entity != CreditObjectType || e.ID != owner {
+		t.Errorf("unexpected error %+v", e)
+	}
+	if c.credit(owner) != 70 {
+		t.Error("a refused ticket must not debit the owner")
+	}
+
+	// awards are paid out of the escrow
+	c.finish(ticketID, applicant, other)
+	c.orderUpdate(admin, ticketID, "Award", applicant)
+	if c.credit(applicant) != 10 || c.credit(owner) != 70 {
+		t.Errorf("unexpected credits %d %d", c.credit(applicant), c.credit(owner))
+	}
+	if escrow := c.escrow(ticketID); escrow.Locked != 20 || escrow.Released != 10 {
+		t.Errorf("unexpected escrow %+v", escrow)
+	}
+
+	var ticket Ticket
+	c.mustUnmarshal(c.mustInvoke("", "TicketRead", ticketID), &ticket)
+	ticket.Value = 40
+	c.mustFail("Ticket_Value of a funded ticket can not change", owner, "TicketUpdate", ticketUpdateJSON(ticket))
+	ticket.Value, ticket.Funding, ticket.Comment = 30, "", "still funded"
+	c.mustInvoke(owner, "TicketUpdate", ticketUpdateJSON(ticket))
+	if c.ticket(ticketID).Funding != FundingCredit {
+		t.Error("TicketUpdate changed Ticket_Funding")
+	}
+
+	// deleting the ticket hands the rest back
+	c.events()
+	c.mustInvoke(owner, "TicketDelete", ticketID)
+	deleteTxID := c.lastTxID()
+	if c.credit(owner) != 90 || c.lobTotal(HANA) != 90 {
+		t.Errorf("unexpected credit %d, LoB total %d", c.credit(owner), c.lobTotal(HANA))
+	}
+	if escrow := c.escrow(ticketID); escrow.Locked != 0 || escrow.Released != 10 || escrow.Refunded != 20 {
+		t.Errorf("unexpected escrow %+v", escrow)
+	}
+	if got := c.events(); !equalStrings(got, []string{EventBatch}) {
+		t.Errorf("unexpected events %v", got)
+	}
+
+	// the orders go with the ticket, the open one of other is closed on the way
+	for _, userID := range []string{applicant, other} {
+		if e := c.failure("", "OrderRead", ticketID, userID); e.Code != ErrNotFound {
+			t.Errorf("unexpected error %+v", e)
+		}
+	}
+	if report := c.audit(); len(report.Violations) != 0 {
+		t.Errorf("unexpected violations %+v", report.Violations)
+	}
+
+	var journal struct {
+		Entries []JournalEntry
+	}
+	c.mustUnmarshal(c.mustInvoke("", "CreditJournal", owner), &journal)
+	var reasons []string
+	for _, entry := range journal.Entries {
+		reasons = append(reasons, entry.Reason+" "+strconv.Itoa(entry.Delta))
+	}
+	if !equalStrings(reasons, []string{"add 100", "escrow -30", "refund 20"}) {
+		t.Errorf("unexpected journal %v", reasons)
+	}
+
+	// a reversed escrow would refund the owner twice
+	for _, txID := range []string{ticketID, deleteTxID} {
+		e := c.failure(admin, "CreditReverse", `{"UserID": "`+owner+`", "TxID": "`+txID+`"}`)
+		if e.Code != ErrConflict || !strings.Contains(e.Message, "can not be reversed") {
+			t.Errorf("unexpected error %+v", e)
+		}
+	}
+	if c.credit(owner) != 90 {
+		t.Errorf("unexpected credit %d", c.credit(owner))
+	}
+}
+
+func TestEscrowFromLoBBudget(t *testing.T) {
+	c := newExchain(t)
+	c.clock = time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	c.mustFail("Forbidden", owner, "LoBFund", `{"LoBID": 1, "Value": 50}`)
+	c.mustFail("field Value must be at least 1", admin, "LoBFund", `{"LoBID": 1, "Value": 0}`)
+	var lob LoB
+	c.mustUnmarshal(c.mustInvoke(admin, "LoBFund", `{"LoBID": 1, "Value": 50}`), &lob)
+	if lob.LoBID != HANA || lob.Budget != 50 || c.lobBudget(HANA) != 50 {
+		t.Errorf("unexpected LoB %+v", lob)
+	}
+
+	// the owner's LoB pays, their own credit is left alone
+	c.mustInvoke(owner, "TicketCreate", fundedTicketJSON(FundingLoB, 40, 15, "2030-01-10T00:00:00Z"))
+	ticketID := c.lastTxID()
+	if c.lobBudget(HANA) != 10 || c.credit(owner) != 0 || c.lobTotal(HANA) != 0 {
+		t.Errorf("unexpected budget %d", c.lobBudget(HANA))
+	}
+	e := c.failure(owner, "TicketCreate", fundedTicketJSON(FundingLoB, 20, 10, ""))
+	if e.Code != ErrInsufficientCredit || e.Entity != LoBObjectType || e.ID != "1" {
+		t.Errorf("unexpected error %+v", e)
+	}
+
+	c.finish(ticketID, applicant, other)
+	c.orderUpdate(admin, ticketID, "Award", applicant)
+	if c.credit(applicant) != 15 || c.lobTotal(SMB) != 15 || c.lobBudget(HANA) != 10 {
+		t.Errorf("unexpected credit %d, budget %d", c.credit(applicant), c.lobBudget(HANA))
+	}
+
+	// once the deadline is over the rest goes back to the LoB, even though the ticket is awarded and other's order is open
+	c.clock = time.Date(2030, 1, 10, 0, 0, 0, 0, time.UTC)
+	var report TicketExpiryReport
+	c.mustUnmarshal(c.mustInvoke(admin, "ExpireTickets"), &report)
+	if len(report.Expired) != 0 || report.Refunded != 25 || c.ticket(ticketID).Status != Awarded {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if escrow := c.escrow(ticketID); escrow.Locked != 0 || escrow.Released != 15 || escrow.Refunded != 25 || escrow.LoBID != HANA {
+		t.Errorf("unexpected escrow %+v", escrow)
+	}
+	if c.lobBudget(HANA) != 35 || c.credit(owner) != 0 {
+		t.Errorf("unexpected budget %d", c.lobBudget(HANA))
+	}
+
+	// nothing is left to refund twice
+	c.mustInvoke(owner, "TicketDelete", ticketID)
+	if c.lobBudget(HANA) != 35 {
+		t.Errorf("unexpected budget %d", c.lobBudget(HANA))
+	}
+}
+
+func TestReverseFundedAward(t *testing.T) {
+	c := newExchain(t)
+	c.clock = time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)
+	c.mustInvoke(admin, "CreditAdd", `{"userID": "`+owner+`", "value": 100, "ticketID": "creditADD"}`)
+	c.mustInvoke(owner, "TicketCreate", fundedTicketJSON(FundingCredit, 30, 10, "2030-01-10T00:00:00Z"))
+	ticketID := c.lastTxID()
+	c.finish(ticketID, applicant, other)
+	c.orderUpdate(admin, ticketID, "Award", applicant)
+	awardTxID := c.lastTxID()
+
+	// the reversed award goes back into the escrow and the order can be awarded again
+	c.mustInvoke(admin, "CreditReverse", `{"UserID": "`+applicant+`", "TxID": "`+awardTxID+`"}`)
+	if ticket := c.ticket(ticketID); c.credit(applicant) != 0 || ticket.Awarded != 0 || ticket.Status != Done {
+		t.Errorf("unexpected ticket %+v", ticket)
+	}
+	if escrow := c.escrow(ticketID); escrow.Locked != 30 || escrow.Released != 0 || c.order(ticketID, applicant).Status != OrderDone {
+		t.Errorf("unexpected escrow %+v", escrow)
+	}
+	if results := c.orderUpdate(admin, ticketID, "Award", applicant, other); results[0].Code != "" || results[1].Code != "" {
+		t.Fatalf("unexpected results %+v", results)
+	}
+	awardTxID = c.lastTxID()
+
+	// with every order awarded the ticket is settled, the rest goes back to the funder right away
+	if escrow := c.escrow(ticketID); c.credit(applicant) != 10 || escrow.Locked != 0 || escrow.Released != 20 || escrow.Refunded != 10 {
+		t.Errorf("unexpected escrow %+v", escrow)
+	}
+	if ticket := c.ticket(ticketID); ticket.Awarded != 20 || ticket.Status != Awarded || c.credit(owner) != 80 {
+		t.Errorf("unexpected ticket %+v, credit %d", ticket, c.credit(owner))
+	}
+
+	// after the deadline the ticket awards nothing more, the funder gets the credit back
+	c.clock = time.Date(2030, 1, 10, 0, 0, 0, 0, time.UTC)
+	c.mustInvoke(admin, "CreditReverse", `{"UserID": "`+other+`", "TxID": "`+awardTxID+`"}`)
+	if escrow := c.escrow(ticketID); escrow.Released != 10 || escrow.Refunded != 20 || c.credit(owner) != 90 {
+		t.Errorf("unexpected escrow %+v, credit %d", escrow, c.credit(owner))
+	}
+	if c.order(ticketID, other).Status != OrderClosed || c.ticket(ticketID).Awarded != 10 {
+		t.Errorf("unexpected order %+v", c.order(ticketID, other))
+	}
+	if report := c.audit(); len(report.Violations) != 0 {
+		t.Errorf("unexpected violations %+v", report.Violations)
+	}
+}
+
+func TestUnfundedTicket(t *testing.T) {
+	c := newExchain(t)
+	ticketID := c.createTicket(owner, 0, 10, "")
+	if e := c.failure("", "EscrowRead", ticketID); e.Code != ErrNotFound || e.Entity != EscrowObjectType {
+		t.Errorf("unexpected error %+v", e)
+	}
+	c.finish(ticketID, applicant)
+	c.orderUpdate(admin, ticketID, "Award", applicant)
+	if c.credit(applicant) != 10 {
+		t.Errorf("unexpected credit %d", c.credit(applicant))
+	}
+
+	c.mustFail("Ticket_Funding must be Credit or LoB", owner, "TicketCreate", fundedTicketJSON("Bank", 10, 5, ""))
+	c.mustFail("a funded ticket needs a positive Ticket_Value", owner, "TicketCreate",
+		`{"Ticket_Title": "t", "Ticket_Type": 0, "Ticket_Value": 0, "Ticket_UserID": "`+owner+`", "Ticket_Funding": "Credit"}`)
+}
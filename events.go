@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// EventVersion - suffix of every event name, bumped on incompatible payload changes
+const EventVersion = "v1"
+
+// Chaincode event names, payloads are ExchainEvent
+const (
+	EventTicketCreated      = "exchain.ticket.created." + EventVersion
+	EventTicketUpdated      = "exchain.ticket.updated." + EventVersion
+	EventTicketExpired      = "exchain.ticket.expired." + EventVersion
+	EventOrderCreated       = "exchain.order.created." + EventVersion
+	EventOrderUpdated       = "exchain.order.updated." + EventVersion
+	EventCreditAwarded      = "exchain.credit.awarded." + EventVersion
+	EventCreditAdded        = "exchain.credit.added." + EventVersion
+	EventCreditTransferred  = "exchain.credit.transferred." + EventVersion
+	EventCreditReversed     = "exchain.credit.reversed." + EventVersion
+	EventEscrowLocked       = "exchain.escrow.locked." + EventVersion
+	EventEscrowRefunded     = "exchain.escrow.refunded." + EventVersion
+	EventParticipantMoved   = "exchain.participant.moved." + EventVersion
+	EventParticipantDeleted = "exchain.participant.deleted." + EventVersion
+
+	// Fabric keeps one event per transaction, several events are sent as one batch
+	EventBatch = "exchain.batch." + EventVersion
+)
+
+// ExchainEvent information
+//
+//	Name:                  one of the Event* names
+//	Version:               EventVersion
+//	TxID:                  transaction that caused the change
+//	TicketID, UserID:      entities the change applies to
+//	OldStatus, NewStatus:  ticket or order status before and after, absent for credit events
+//	Delta:                 credit change, absent for ticket and order events
+type ExchainEvent struct {
+	Name      string `json:"Name"`
+	Version   string `json:"Version"`
+	TxID      string `json:"TxID"`
+	TicketID  string `json:"TicketID,omitempty"`
+	UserID    string `json:"UserID,omitempty"`
+	OldStatus *int   `json:"OldStatus,omitempty"`
+	NewStatus *int   `json:"NewStatus,omitempty"`
+	Delta     int    `json:"Delta,omitempty"`
+}
+
+// EventBatchPayload information, payload of EventBatch
+type EventBatchPayload struct {
+	Version string         `json:"Version"`
+	TxID    string         `json:"TxID"`
+	Events  []ExchainEvent `json:"Events"`
+}
+
+// eventStub - collects the events of one Invoke so they can be set once, after the route succeeded
+type eventStub struct {
+	shim.ChaincodeStubInterface
+	events []ExchainEvent
+}
+
+func newEventStub(stub shim.ChaincodeStubInterface) *eventStub {
+	return &eventStub{ChaincodeStubInterface: stub}
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+// Helper: record a chaincode event for the current transaction
+func emitEvent(stub shim.ChaincodeStubInterface, event ExchainEvent) error {
+	event.Version = EventVersion
+	event.TxID = stub.GetTxID()
+	if es, ok := stub.(*eventStub); ok {
+		es.events = append(es.events, event)
+		return nil
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return errors.New("emitEvent: " + err.Error())
+	}
+	return stub.SetEvent(event.Name, payload)
+}
+
+// Helper: set the collected events on the transaction, a single event as itself, several as EventBatch
+func (es *eventStub) flush() error {
+	var name string
+	var payload []byte
+	var err error
+
+	switch len(es.events) {
+	case 0:
+		return nil
+	case 1:
+		name = es.events[0].Name
+		payload, err = json.Marshal(es.events[0])
+	default:
+		name = EventBatch
+		payload, err = json.Marshal(EventBatchPayload{Version: EventVersion, TxID: es.GetTxID(), Events: es.events})
+	}
+	if err != nil {
+		return errors.New("flush: Error marshalling events: " + err.Error())
+	}
+	return es.ChaincodeStubInterface.SetEvent(name, payload)
+}
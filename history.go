@@ -0,0 +1,187 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// DefaultHistoryPageSize - page size of GetHistory when none is given
+const DefaultHistoryPageSize = 20
+
+// MaxHistoryPageSize - largest page GetHistory returns
+const MaxHistoryPageSize = 100
+
+// HistoryRange information, third argument of GetHistory; both ends are optional and inclusive
+type HistoryRange struct {
+	From *time.Time `json:"From"`
+	To   *time.Time `json:"To"`
+}
+
+// HistoryEntry information, one version of a key
+//
+//	TxID, Timestamp:   transaction that wrote the version
+//	IsDelete:          the transaction deleted the key, Value is null
+//	Value:             the record as stored, participants without password
+type HistoryEntry struct {
+	TxID      string          `json:"TxID"`
+	Timestamp time.Time       `json:"Timestamp"`
+	IsDelete  bool            `json:"IsDelete"`
+	Value     json.RawMessage `json:"Value"`
+}
+
+// HistoryPage information, response of GetHistory
+//
+//	Entity, ID:   the entity as requested
+//	Items:        versions in ledger order
+//	Count:        len(Items)
+//	Bookmark:     pass it to get the next page, empty on the last page
+type HistoryPage struct {
+	Entity   string         `json:"Entity"`
+	ID       string         `json:"ID"`
+	Items    []HistoryEntry `json:"Items"`
+	Count    int            `json:"Count"`
+	Bookmark string         `json:"Bookmark"`
+}
+
+func (r HistoryRange) contains(timestamp time.Time) bool {
+	if r.From != nil && timestamp.Before(*r.From) {
+		return false
+	}
+	if r.To != nil && timestamp.After(*r.To) {
+		return false
+	}
+	return true
+}
+
+// Helper: state key of an entity, orders are named "TicketID/UserID"
+func historyKey(stub shim.ChaincodeStubInterface, entity string, id string) (string, error) {
+	switch entity {
+	case ParticipantObjectType:
+		return participantKey(stub, id), nil
+	case TicketObjectType:
+		return ticketKey(stub, id), nil
+	case CreditObjectType:
+		return creditKey(stub, id), nil
+	case LoBObjectType:
+		LoBID, err := strconv.Atoi(id)
+		if err != nil {
+			return "", badRequest("GetHistory", "the ID of a LoB must be an integer")
+		}
+		return lobKey(stub, LoBID), nil
+	case OrderObjectType:
+		parts := strings.Split(id, "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return "", badRequest("GetHistory", "the ID of an order must be TicketID/UserID")
+		}
+		return orderKey(stub, parts[0], parts[1]), nil
+	}
+	return "", badRequest("GetHistory", "Entity must be one of Participant, Ticket, Credit, LoB, Order")
+}
+
+// Helper: a version as returned to clients, passwords never leave the chaincode
+func historyValue(entity string, value []byte) (json.RawMessage, error) {
+	if entity != ParticipantObjectType {
+		return json.RawMessage(value), nil
+	}
+	var participant Participant
+	err := json.Unmarshal(value, &participant)
+	if err != nil {
+		return nil, err
+	}
+	participant.Password = ""
+	return json.Marshal(participant)
+}
+
+// Query Route: GetHistory
+//
+//	args: Entity, ID [, range [, pageSize [, bookmark]]], range is a HistoryRange JSON and may be empty
+//	returns a HistoryPage; the bookmark is the TxID of the last entry of the page, set while the
+//	history goes on, so the page after it may come back empty
+func (sc *SmartContract) GetHistory(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	var timeRange HistoryRange
+	entity, id := args[0], args[1]
+	pageSize := DefaultHistoryPageSize
+	bookmark := ""
+	if len(args) > 2 && args[2] != "" {
+		err := json.Unmarshal([]byte(args[2]), &timeRange)
+		if err != nil {
+			return errorResponse(badRequest("GetHistory", err.Error()))
+		}
+		if timeRange.From != nil && timeRange.To != nil && timeRange.From.After(*timeRange.To) {
+			return errorResponse(badRequest("GetHistory", "From must not be after To"))
+		}
+	}
+	if len(args) > 3 && args[3] != "" {
+		size, err := strconv.Atoi(args[3])
+		if err != nil || size <= 0 || size > MaxHistoryPageSize {
+			return errorResponse(badRequest("GetHistory", "pageSize must be between 1 and "+strconv.Itoa(MaxHistoryPageSize)))
+		}
+		pageSize = size
+	}
+	if len(args) > 4 {
+		bookmark = args[4]
+	}
+
+	key, err := historyKey(stub, entity, id)
+	if err != nil {
+		return errorResponse(err)
+	}
+	iterator, err := stub.GetHistoryForKey(key)
+	if err != nil {
+		if strings.Contains(err.Error(), "not implemented") || strings.Contains(err.Error(), "not enabled") {
+			return errorResponse(newError(ErrUnsupported, "GetHistory: The peer keeps no history database"))
+		}
+		return errorResponse(errInternal("GetHistory: " + err.Error()))
+	}
+	defer iterator.Close()
+
+	// ==== Versions come in commit order, reading stops once the page is full or past To ====
+	page := HistoryPage{Entity: entity, ID: id, Items: []HistoryEntry{}}
+	skipping := bookmark != ""
+	for iterator.HasNext() {
+		if len(page.Items) == pageSize {
+			page.Bookmark = page.Items[len(page.Items)-1].TxID
+			break
+		}
+		modification, err := iterator.Next()
+		if err != nil {
+			return errorResponse(errInternal("GetHistory: " + err.Error()))
+		}
+		if skipping {
+			skipping = modification.TxId != bookmark
+			continue
+		}
+		entry := HistoryEntry{TxID: modification.TxId, IsDelete: modification.IsDelete}
+		if modification.Timestamp != nil {
+			entry.Timestamp = time.Unix(modification.Timestamp.Seconds, int64(modification.Timestamp.Nanos)).UTC()
+		}
+		if timeRange.To != nil && entry.Timestamp.After(*timeRange.To) {
+			break
+		}
+		if !timeRange.contains(entry.Timestamp) {
+			continue
+		}
+		if !entry.IsDelete {
+			entry.Value, err = historyValue(entity, modification.Value)
+			if err != nil {
+				return errorResponse(errInternal("GetHistory: Corrupt " + entity + " " + id + " in transaction " + entry.TxID))
+			}
+		}
+		page.Items = append(page.Items, entry)
+	}
+	if skipping {
+		return errorResponse(badRequest("GetHistory", "bookmark "+bookmark+" is no transaction of "+entity+" "+id))
+	}
+	page.Count = len(page.Items)
+
+	pageAsBytes, err := json.Marshal(page)
+	if err != nil {
+		return errorResponse(errInternal("GetHistory: " + err.Error()))
+	}
+	return shim.Success(pageAsBytes)
+}
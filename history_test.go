@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+)
+
+// versions - one version per day from 2030-01-01, the last one deletes the key
+func versions(values ...string) []*queryresult.KeyModification {
+	var modifications []*queryresult.KeyModification
+	for i, value := range values {
+		day := time.Date(2030, 1, 1+i, 0, 0, 0, 0, time.UTC)
+		modifications = append(modifications, &queryresult.KeyModification{TxId: "h" + strconv.Itoa(i+1), Value: []byte(value),
+			Timestamp: &timestamp.Timestamp{Seconds: day.Unix()}})
+	}
+	last := modifications[len(modifications)-1]
+	last.IsDelete, last.Value = true, nil
+	return modifications
+}
+
+func (c *testChain) history(args ...string) HistoryPage {
+	c.t.Helper()
+	var page HistoryPage
+	c.mustUnmarshal(c.mustInvoke("", "GetHistory", args...), &page)
+	if page.Count != len(page.Items) {
+		c.t.Fatalf("Count %d of %d items", page.Count, len(page.Items))
+	}
+	return page
+}
+
+func historyTxIDs(page HistoryPage) []string {
+	var ids []string
+	for _, entry := range page.Items {
+		ids = append(ids, entry.TxID)
+	}
+	return ids
+}
+
+func TestGetHistoryNeedsHistoryDatabase(t *testing.T) {
+	c := newExchain(t)
+	e := c.failure("", "GetHistory", TicketObjectType, "tx1")
+	if e.Code != ErrUnsupported || e.Status != 501 {
+		t.Errorf("unexpected error %+v", e)
+	}
+
+	c.stub.history = func(key string) ([]*queryresult.KeyModification, error) {
+		return nil, errors.New("ledger closed")
+	}
+	if e = c.failure("", "GetHistory", TicketObjectType, "tx1"); e.Code != ErrInternal {
+		t.Errorf("unexpected error %+v", e)
+	}
+}
+
+func TestGetHistory(t *testing.T) {
+	c := newExchain(t)
+	var keys []string
+	stored := versions(`{}`, "")
+	c.stub.history = func(key string) ([]*queryresult.KeyModification, error) {
+		keys = append(keys, key)
+		return stored, nil
+	}
+
+	// every entity is read from its own key
+	for _, tc := range []struct {
+		entity string
+		id     string
+		key    string
+	}{
+		{ParticipantObjectType, owner, participantKey(c.stub, owner)},
+		{TicketObjectType, "t1", ticketKey(c.stub, "t1")},
+		{CreditObjectType, owner, creditKey(c.stub, owner)},
+		{LoBObjectType, "2", lobKey(c.stub, SMB)},
+		{OrderObjectType, "t1/" + applicant, orderKey(c.stub, "t1", applicant)},
+	} {
+		page := c.history(tc.entity, tc.id)
+		if keys[len(keys)-1] != tc.key || page.Entity != tc.entity || page.ID != tc.id || page.Bookmark != "" {
+			t.Errorf("%s %s: read key %q, page %+v", tc.entity, tc.id, keys[len(keys)-1], page)
+		}
+	}
+
+	// full version list, deletes have no value
+	stored = versions(`{"Ticket_TicketID":"t1","Ticket_Status":1}`, `{"Ticket_TicketID":"t1","Ticket_Status":2}`,
+		`{"Ticket_TicketID":"t1","Ticket_Status":3}`, "")
+	page := c.history(TicketObjectType, "t1")
+	if !equalStrings(historyTxIDs(page), []string{"h1", "h2", "h3", "h4"}) {
+		t.Fatalf("unexpected history %+v", page)
+	}
+	first, last := page.Items[0], page.Items[3]
+	if string(first.Value) != string(stored[0].Value) || first.IsDelete || !first.Timestamp.Equal(time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected first version %+v", first)
+	}
+	if !last.IsDelete || string(last.Value) != "null" {
+		t.Errorf("unexpected last version %+v", last)
+	}
+
+	// passwords of old participant records are not returned
+	stored = versions(`{"Participant_UserID":"`+owner+`","Participant_Password":"plain"}`, "")
+	page = c.history(ParticipantObjectType, owner)
+	if string(page.Items[0].Value) != `{"Participant_UserID":"`+owner+`","Participant_UserName":"","Participant_IsAdmin":false,"Participant_LoBID":0,"Participant_MSPID":""}` {
+		t.Errorf("unexpected participant version %s", page.Items[0].Value)
+	}
+
+	// inclusive time range
+	stored = versions(`{"v":1}`, `{"v":2}`, `{"v":3}`, `{"v":4}`, "")
+	page = c.history(TicketObjectType, "t1", `{"From": "2030-01-02T00:00:00Z", "To": "2030-01-04T00:00:00Z"}`)
+	if !equalStrings(historyTxIDs(page), []string{"h2", "h3", "h4"}) {
+		t.Errorf("unexpected range %v", historyTxIDs(page))
+	}
+	page = c.history(TicketObjectType, "t1", `{"From": "2030-01-04T12:00:00Z"}`)
+	if !equalStrings(historyTxIDs(page), []string{"h5"}) {
+		t.Errorf("unexpected range %v", historyTxIDs(page))
+	}
+
+	// pages of two: h1 h2, h3 h4, h5
+	var listed []string
+	bookmark := ""
+	for pages := 1; pages <= 3; pages++ {
+		page = c.history(TicketObjectType, "t1", "", "2", bookmark)
+		listed = append(listed, historyTxIDs(page)...)
+		bookmark = page.Bookmark
+	}
+	if !equalStrings(listed, []string{"h1", "h2", "h3", "h4", "h5"}) || bookmark != "" {
+		t.Errorf("paged %v, last bookmark %q", listed, bookmark)
+	}
+	// a full page does not look ahead, the next one ends at To without reading on
+	page = c.history(TicketObjectType, "t1", `{"To": "2030-01-04T00:00:00Z"}`, "2", "h2")
+	if !equalStrings(historyTxIDs(page), []string{"h3", "h4"}) || page.Bookmark != "h4" {
+		t.Errorf("unexpected page %+v", page)
+	}
+	page = c.history(TicketObjectType, "t1", `{"To": "2030-01-04T00:00:00Z"}`, "2", "h4")
+	if page.Count != 0 || page.Bookmark != "" {
+		t.Errorf("unexpected page %+v", page)
+	}
+	e := c.failure("", "GetHistory", TicketObjectType, "t1", "", "2", "h9")
+	if e.Code != ErrBadRequest || !strings.Contains(e.Message, "bookmark h9 is no transaction of Ticket t1") {
+		t.Errorf("unexpected error %+v", e)
+	}
+
+	reads := len(keys)
+	c.mustFail("Entity must be one of Participant, Ticket, Credit, LoB, Order", "", "GetHistory", "Reading", "t1")
+	c.mustFail("the ID of a LoB must be an integer", "", "GetHistory", LoBObjectType, "HANA")
+	c.mustFail("the ID of an order must be TicketID/UserID", "", "GetHistory", OrderObjectType, "t1")
+	c.mustFail("From must not be after To", "", "GetHistory", TicketObjectType, "t1",
+		`{"From": "2030-01-02T00:00:00Z", "To": "2030-01-01T00:00:00Z"}`)
+	c.mustFail("field From must be an RFC 3339 date-time", "", "GetHistory", TicketObjectType, "t1", `{"From": "yesterday"}`)
+	c.mustFail("pageSize must be between 1 and 100", "", "GetHistory", TicketObjectType, "t1", "", "0")
+	if len(keys) != reads {
+		t.Error("invalid requests must not read the history")
+	}
+}
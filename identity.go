@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/core/chaincode/shim/ext/cid"
+)
+
+// UserIDAttribute - enrollment attribute (issued by the Fabric CA) carrying the Participant UserID
+const UserIDAttribute = "exchain.userID"
+
+// Caller information
+//
+//	MSPID:         MSP of the submitting client, e.g. Org1MSP
+//	UserID:        value of the exchain.userID enrollment attribute
+//	Participant:   ledger record the UserID points to
+type Caller struct {
+	MSPID       string
+	UserID      string
+	Participant Participant
+}
+
+// Helper: read MSP ID and enrollment UserID from the submitter's certificate
+func getClientIdentity(stub shim.ChaincodeStubInterface) (mspID string, userID string, err error) {
+	mspID, err = cid.GetMSPID(stub)
+	if err != nil {
+		return "", "", errors.New("getClientIdentity: Error reading client MSP ID: " + err.Error())
+	}
+	userID, found, err := cid.GetAttributeValue(stub, UserIDAttribute)
+	if err != nil {
+		return "", "", errors.New("getClientIdentity: Error reading attribute " + UserIDAttribute + ": " + err.Error())
+	}
+	if !found || userID == "" {
+		return "", "", errors.New("getClientIdentity: Client certificate has no " + UserIDAttribute + " attribute")
+	}
+	return mspID, userID, nil
+}
+
+// Helper: whether a registered participant is pinned to mspID; the chaincode knows the MSPs of the channel
+// only through the participants that registered from them
+func knownMSP(stub shim.ChaincodeStubInterface, mspID string) (bool, error) {
+	var readingIDs ReadingIDIndex
+	bytes, err := stub.GetState("readingIDIndex")
+	if err != nil {
+		return false, errors.New("knownMSP: Error getting readingIDIndex array")
+	}
+	err = json.Unmarshal(bytes, &readingIDs)
+	if err != nil {
+		return false, errors.New("knownMSP: Error unmarshalling readingIDIndex array JSON")
+	}
+	for _, participantID := range readingIDs.UserIDs {
+		bytes, err = stub.GetState(participantKey(stub, participantID))
+		if err != nil {
+			return false, errors.New("knownMSP: Error getting participant with ID: " + participantID)
+		}
+		var participant Participant
+		if json.Unmarshal(bytes, &participant) == nil && participant.MSPID == mspID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Helper: resolve the submitter's client identity to a registered Participant
+func getCaller(stub shim.ChaincodeStubInterface) (Caller, error) {
+	var caller Caller
+	mspID, userID, err := getClientIdentity(stub)
+	if err != nil {
+		return caller, err
+	}
+
+	bytes, err := stub.GetState(participantKey(stub, userID))
+	if err != nil {
+		return caller, errors.New("getCaller: Error getting participant with ID: " + userID)
+	}
+	if bytes == nil {
+		return caller, errors.New("getCaller: No participant registered for client identity " + userID)
+	}
+	err = json.Unmarshal(bytes, &caller.Participant)
+	if err != nil {
+		return caller, errors.New("getCaller: Corrupt participant record " + userID)
+	}
+
+	if caller.Participant.Deactivated {
+		return caller, errors.New("getCaller: Participant " + userID + " is deactivated")
+	}
+
+	// a participant is pinned to the MSP it registered from
+	if caller.Participant.MSPID != "" && caller.Participant.MSPID != mspID {
+		return caller, errors.New("getCaller: Participant " + userID + " is not enrolled with MSP " + mspID)
+	}
+
+	caller.MSPID = mspID
+	caller.UserID = userID
+	return caller, nil
+}
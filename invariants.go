@@ -0,0 +1,353 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// Invariants checked by AuditInvariants
+//
+//	LoBTotalCredit:      LoB_TotalCredit = LoB_RetainedCredit + credit of its participants above their Participant_CreditBase
+//	LoBRolledUpCredit:   LoB_RolledUpCredit = LoB_TotalCredit of the LoB and of all its sub-LoBs
+//	LoBMembers:          LoB_UserIDs = active participants with the LoB as Participant_LoBID, each once
+//	ReadingIDIndex:      readingIDIndex = every stored participant, each once
+//	OrderTicket:         an order belongs to a stored ticket
+//	AwardOrder:          a stored ticket in Credit_TicketIDs has an Awarded order of the same user; TicketDelete
+//	                     removes the orders with the ticket, the credit stays
+const (
+	InvariantLoBTotalCredit    = "LoBTotalCredit"
+	InvariantLoBRolledUpCredit = "LoBRolledUpCredit"
+	InvariantLoBMembers        = "LoBMembers"
+	InvariantReadingIDIndex    = "ReadingIDIndex"
+	InvariantOrderTicket       = "OrderTicket"
+	InvariantAwardOrder        = "AwardOrder"
+)
+
+// InvariantViolation information, one inconsistency found by AuditInvariants
+//
+//	Entity, ID:         record that breaks the invariant, as for GetHistory
+//	Expected, Actual:   value derived from the other records and the stored one, where there is one
+type InvariantViolation struct {
+	Invariant string      `json:"Invariant"`
+	Entity    string      `json:"Entity"`
+	ID        string      `json:"ID"`
+	Expected  interface{} `json:"Expected,omitempty"`
+	Actual    interface{} `json:"Actual,omitempty"`
+	Message   string      `json:"Message"`
+}
+
+// AuditReport information, response of AuditInvariants
+//
+//	Participants, LoBs, Tickets, Orders, Credits:   records checked
+type AuditReport struct {
+	Participants int                  `json:"Participants"`
+	LoBs         int                  `json:"LoBs"`
+	Tickets      int                  `json:"Tickets"`
+	Orders       int                  `json:"Orders"`
+	Credits      int                  `json:"Credits"`
+	Violations   []InvariantViolation `json:"Violations"`
+}
+
+// ReconcileReport information, response of Reconcile
+//
+//	Fixed:        violations repaired, with the value that was stored as Actual
+//	Unresolved:   violations left to an admin, awards are only taken back with CreditReverse
+type ReconcileReport struct {
+	Fixed      []InvariantViolation `json:"Fixed"`
+	Unresolved []InvariantViolation `json:"Unresolved"`
+}
+
+// ledgerAudit information, the records read by auditInvariants and the repairs Reconcile writes
+type ledgerAudit struct {
+	report       AuditReport
+	lobs         []LoB
+	index        []string
+	orphanOrders []string
+}
+
+// Helper: decode every record stored under objectType
+func scanObjects(stub shim.ChaincodeStubInterface, function string, objectType string, decode func(key string, value []byte) error) error {
+	iterator, err := stub.GetStateByPartialCompositeKey(objectType, []string{})
+	if err != nil {
+		return errInternal(function + ": " + err.Error())
+	}
+	defer iterator.Close()
+
+	for iterator.HasNext() {
+		queryResponse, err := iterator.Next()
+		if err != nil {
+			return errInternal(function + ": " + err.Error())
+		}
+		err = decode(queryResponse.Key, queryResponse.Value)
+		if err != nil {
+			return errInternal(function + ": Corrupt " + objectType + " " + queryResponse.Key)
+		}
+	}
+	return nil
+}
+
+// Helper: stored followed by want, without the entries of stored that are not wanted and without duplicates.
+// The order of stored is kept, the missing entries are appended in the order of want.
+func reconcileIDs(stored []string, want []string) []string {
+	wanted := make(map[string]bool)
+	for _, id := range want {
+		wanted[id] = true
+	}
+	fixed := []string{}
+	seen := make(map[string]bool)
+	for _, id := range append(append([]string{}, stored...), want...) {
+		if wanted[id] && !seen[id] {
+			seen[id] = true
+			fixed = append(fixed, id)
+		}
+	}
+	return fixed
+}
+
+// Helper: read participants, credits, LoBs, tickets, orders and readingIDIndex and check them against each other.
+// It only reads, ledgerAudit holds the records as they should be.
+func auditInvariants(stub shim.ChaincodeStubInterface) (ledgerAudit, error) {
+	var audit ledgerAudit
+	report := &audit.report
+	report.Violations = []InvariantViolation{}
+
+	// ==== Records, in key order ====
+	var participants []Participant
+	err := scanObjects(stub, "auditInvariants", ParticipantObjectType, func(key string, value []byte) error {
+		var participant Participant
+		err := json.Unmarshal(value, &participant)
+		participants = append(participants, participant)
+		return err
+	})
+	if err != nil {
+		return audit, err
+	}
+	var creditUserIDs []string
+	credits := make(map[string]Credit)
+	err = scanObjects(stub, "auditInvariants", CreditObjectType, func(key string, value []byte) error {
+		var credit Credit
+		err := json.Unmarshal(value, &credit)
+		creditUserIDs = append(creditUserIDs, credit.UserID)
+		credits[credit.UserID] = credit
+		return err
+	})
+	if err != nil {
+		return audit, err
+	}
+	tickets := make(map[string]bool)
+	err = scanObjects(stub, "auditInvariants", TicketObjectType, func(key string, value []byte) error {
+		var ticket Ticket
+		err := json.Unmarshal(value, &ticket)
+		tickets[ticket.TicketID] = true
+		return err
+	})
+	if err != nil {
+		return audit, err
+	}
+	var orders []Order
+	err = scanObjects(stub, "auditInvariants", OrderObjectType, func(key string, value []byte) error {
+		var order Order
+		err := json.Unmarshal(value, &order)
+		orders = append(orders, order)
+		return err
+	})
+	if err != nil {
+		return audit, err
+	}
+	audit.lobs, err = listLoBs(stub)
+	if err != nil {
+		return audit, err
+	}
+	var readingIDs ReadingIDIndex
+	bytes, err := stub.GetState("readingIDIndex")
+	if err != nil {
+		return audit, errInternal("auditInvariants: Error getting readingIDIndex array")
+	}
+	if len(bytes) > 0 && json.Unmarshal(bytes, &readingIDs) != nil {
+		return audit, errInternal("auditInvariants: Error unmarshalling readingIDIndex array JSON")
+	}
+	report.Participants, report.LoBs, report.Tickets, report.Orders, report.Credits =
+		len(participants), len(audit.lobs), len(tickets), len(orders), len(credits)
+
+	// ==== LoB totals and members from the participants ====
+	counted := make(map[int]int)
+	members := make(map[int][]string)
+	var userIDs []string
+	for _, participant := range participants {
+		userIDs = append(userIDs, participant.UserID)
+		if credit, ok := credits[participant.UserID]; ok {
+			counted[participant.LoBID] += credit.Value - participant.CreditBase
+		}
+		if !participant.Deactivated {
+			members[participant.LoBID] = append(members[participant.LoBID], participant.UserID)
+		}
+	}
+	for i, lob := range audit.lobs {
+		LoBID := strconv.Itoa(lob.LoBID)
+		expected := lob.RetainedCredit + counted[lob.LoBID]
+		if lob.TotalCredit != expected {
+			report.Violations = append(report.Violations, InvariantViolation{Invariant: InvariantLoBTotalCredit, Entity: LoBObjectType, ID: LoBID,
+				Expected: expected, Actual: lob.TotalCredit, Message: "LoB_TotalCredit differs from the credit of its participants"})
+			audit.lobs[i].TotalCredit = expected
+		}
+		fixed := reconcileIDs(lob.UserIDs, members[lob.LoBID])
+		if !equalIDs(fixed, lob.UserIDs) {
+			report.Violations = append(report.Violations, InvariantViolation{Invariant: InvariantLoBMembers, Entity: LoBObjectType, ID: LoBID,
+				Expected: fixed, Actual: lob.UserIDs, Message: "LoB_UserIDs differ from the participants of the LoB"})
+			audit.lobs[i].UserIDs = fixed
+		}
+	}
+	rolledUp := make(map[int]int)
+	walkLoBTree(audit.lobs, func(lob LoB, ancestor LoB) {
+		rolledUp[ancestor.LoBID] += lob.TotalCredit
+	})
+	for i, lob := range audit.lobs {
+		if lob.RolledUpCredit != rolledUp[lob.LoBID] {
+			report.Violations = append(report.Violations, InvariantViolation{Invariant: InvariantLoBRolledUpCredit, Entity: LoBObjectType,
+				ID: strconv.Itoa(lob.LoBID), Expected: rolledUp[lob.LoBID], Actual: lob.RolledUpCredit,
+				Message: "LoB_RolledUpCredit differs from the credit of the LoB and its sub-LoBs"})
+			audit.lobs[i].RolledUpCredit = rolledUp[lob.LoBID]
+		}
+	}
+
+	// ==== readingIDIndex ====
+	audit.index = reconcileIDs(readingIDs.UserIDs, userIDs)
+	listed := make(map[string]int)
+	for _, userID := range readingIDs.UserIDs {
+		listed[userID]++
+	}
+	stored := make(map[string]bool)
+	for _, userID := range userIDs {
+		stored[userID] = true
+		if listed[userID] == 0 {
+			report.Violations = append(report.Violations, InvariantViolation{Invariant: InvariantReadingIDIndex, Entity: ParticipantObjectType,
+				ID: userID, Message: "Participant " + userID + " is missing in readingIDIndex"})
+		}
+	}
+	for _, userID := range readingIDs.UserIDs {
+		if listed[userID] == 0 {
+			continue
+		}
+		if !stored[userID] {
+			report.Violations = append(report.Violations, InvariantViolation{Invariant: InvariantReadingIDIndex, Entity: ParticipantObjectType,
+				ID: userID, Message: "readingIDIndex lists " + userID + " without participant"})
+		} else if listed[userID] > 1 {
+			report.Violations = append(report.Violations, InvariantViolation{Invariant: InvariantReadingIDIndex, Entity: ParticipantObjectType,
+				ID: userID, Expected: 1, Actual: listed[userID], Message: "readingIDIndex lists " + userID + " more than once"})
+		}
+		listed[userID] = 0
+	}
+
+	// ==== Orders and awards ====
+	awarded := make(map[string]bool)
+	for _, order := range orders {
+		if !tickets[order.TicketID] {
+			report.Violations = append(report.Violations, InvariantViolation{Invariant: InvariantOrderTicket, Entity: OrderObjectType,
+				ID: orderID(order.TicketID, order.UserID), Message: "Ticket " + order.TicketID + " of the order does not exist"})
+			audit.orphanOrders = append(audit.orphanOrders, orderKey(stub, order.TicketID, order.UserID))
+		}
+		if order.Status == OrderAwarded {
+			awarded[orderID(order.TicketID, order.UserID)] = true
+		}
+	}
+	sort.Strings(creditUserIDs)
+	for _, userID := range creditUserIDs {
+		for _, ticketID := range credits[userID].TicketIDs {
+			if tickets[ticketID] && !awarded[orderID(ticketID, userID)] {
+				report.Violations = append(report.Violations, InvariantViolation{Invariant: InvariantAwardOrder, Entity: CreditObjectType, ID: userID,
+					Expected: OrderAwarded, Message: "Ticket " + ticketID + " was credited to " + userID + " without Awarded order"})
+			}
+		}
+	}
+	return audit, nil
+}
+
+// Helper: same IDs in the same order
+func equalIDs(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Query Route: AuditInvariants - check the ledger invariants without changing anything, see InvariantViolation
+func (rdg *SmartContract) AuditInvariants(stub shim.ChaincodeStubInterface) peer.Response {
+	audit, err := auditInvariants(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	reportAsBytes, err := json.Marshal(audit.report)
+	if err != nil {
+		return errorResponse(errInternal("AuditInvariants: " + err.Error()))
+	}
+	return shim.Success(reportAsBytes)
+}
+
+// Invoke Route: Reconcile - repair what AuditInvariants finds, in one transaction
+//
+//	LoB totals and LoB_UserIDs are recomputed from the participants, readingIDIndex from the stored participants,
+//	orders of missing tickets are deleted. Awards without Awarded order are reported, not reversed.
+func (rdg *SmartContract) Reconcile(stub shim.ChaincodeStubInterface) peer.Response {
+	audit, err := auditInvariants(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	report := ReconcileReport{Fixed: []InvariantViolation{}, Unresolved: []InvariantViolation{}}
+	changedLoBs := make(map[string]bool)
+	changedIndex := false
+	for _, violation := range audit.report.Violations {
+		switch violation.Invariant {
+		case InvariantLoBTotalCredit, InvariantLoBRolledUpCredit, InvariantLoBMembers:
+			changedLoBs[violation.ID] = true
+		case InvariantReadingIDIndex:
+			changedIndex = true
+		case InvariantAwardOrder:
+			report.Unresolved = append(report.Unresolved, violation)
+			continue
+		}
+		report.Fixed = append(report.Fixed, violation)
+	}
+
+	// ==== Every LoB once, with all its fields repaired ====
+	for _, lob := range audit.lobs {
+		if !changedLoBs[strconv.Itoa(lob.LoBID)] {
+			continue
+		}
+		err = saveLoB(stub, lob)
+		if err != nil {
+			return errorResponse(err)
+		}
+	}
+	if changedIndex {
+		bytes, err := json.Marshal(ReadingIDIndex{UserIDs: audit.index})
+		if err != nil {
+			return errorResponse(errInternal("Reconcile: " + err.Error()))
+		}
+		err = stub.PutState("readingIDIndex", bytes)
+		if err != nil {
+			return errorResponse(errInternal("Reconcile: " + err.Error()))
+		}
+	}
+	for _, key := range audit.orphanOrders {
+		err = stub.DelState(key)
+		if err != nil {
+			return errorResponse(errInternal("Reconcile: " + err.Error()))
+		}
+	}
+	logger.Info("Reconcile:", len(report.Fixed), "fixed", len(report.Unresolved), "unresolved")
+
+	reportAsBytes, err := json.Marshal(report)
+	if err != nil {
+		return errorResponse(errInternal("Reconcile: " + err.Error()))
+	}
+	return shim.Success(reportAsBytes)
+}
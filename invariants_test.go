@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+func (c *testChain) audit() AuditReport {
+	c.t.Helper()
+	var report AuditReport
+	c.mustUnmarshal(c.mustInvoke("", "AuditInvariants"), &report)
+	return report
+}
+
+// violations - "Invariant ID" of each violation
+func violations(list []InvariantViolation) []string {
+	var found []string
+	for _, violation := range list {
+		found = append(found, violation.Invariant+" "+violation.ID)
+	}
+	return found
+}
+
+func TestAuditInvariantsConsistentLedger(t *testing.T) {
+	c := newExchain(t)
+	cloud, _ := c.lobTree()
+	c.register("i000005", cloud, false)
+	c.mustInvoke(admin, "CreditAdd", `{"userID": "`+other+`", "value": 8, "ticketID": "creditADD"}`)
+	ticketID := c.createTicket(owner, 0, 10, "")
+	c.finish(ticketID, "i000005", applicant)
+	c.orderUpdate(admin, ticketID, "Award", "i000005", applicant)
+	c.mustInvoke(admin, "ParticipantChangeLoB", changeLoB(other, IBS, CreditPolicyKeep))
+	c.mustInvoke(admin, "LoBMove", `{"LoB_LoBID": 8, "LoB_ParentID": 2}`)
+	c.deleteParticipant(applicant, DeleteSoft)
+	c.deleteParticipant("i000005", DeleteHard)
+
+	report := c.audit()
+	if len(report.Violations) != 0 {
+		t.Errorf("unexpected violations %+v", report.Violations)
+	}
+	if report.Participants != 4 || report.Tickets != 1 || report.Orders != 2 || report.LoBs != len(defaultLoBs)+2 {
+		t.Errorf("unexpected report %+v", report)
+	}
+}
+
+func TestAuditAndReconcile(t *testing.T) {
+	c := newExchain(t)
+	c.mustInvoke(admin, "CreditAdd", `{"userID": "`+applicant+`", "value": 12, "ticketID": "creditADD"}`)
+	ticketID := c.createTicket(owner, 0, 10, "")
+
+	// a ticket credited without any order
+	response := c.run("", func(stub *testStub) peer.Response {
+		_, err := applyCreditMovements(stub, []creditMovement{{UserID: other, Delta: 4, Reason: JournalAward, TicketID: ticketID}})
+		if err != nil {
+			return errorResponse(err)
+		}
+		return shim.Success(nil)
+	})
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	// drift as left behind by writes with ignored errors
+	response = c.run("", func(stub *testStub) peer.Response {
+		lob, err := retrieveLoB(stub, SMB)
+		if err != nil {
+			return errorResponse(err)
+		}
+		lob.TotalCredit += 7
+		lob.RolledUpCredit += 3
+		lob.UserIDs = []string{other}
+		if err = saveLoB(stub, lob); err != nil {
+			return errorResponse(err)
+		}
+		bytes, _ := json.Marshal(ReadingIDIndex{UserIDs: []string{admin, owner, owner, "i999999", other}})
+		stub.PutState("readingIDIndex", bytes)
+		_, err = OrderSaving(stub, Order{TicketID: "gone", UserID: applicant, Status: OrderApplied})
+		if err != nil {
+			return errorResponse(err)
+		}
+		return shim.Success(nil)
+	})
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	report := c.audit()
+	want := []string{
+		"LoBTotalCredit 2", "LoBMembers 2", "LoBRolledUpCredit 2",
+		"ReadingIDIndex " + applicant, "ReadingIDIndex " + owner, "ReadingIDIndex i999999",
+		"OrderTicket gone/" + applicant, "AwardOrder " + other,
+	}
+	if got := violations(report.Violations); !equalStrings(got, want) {
+		t.Fatalf("unexpected violations %v", got)
+	}
+	if total := report.Violations[0]; total.Expected != float64(16) || total.Actual != float64(23) {
+		t.Errorf("unexpected violation %+v", total)
+	}
+
+	// the audit changed nothing
+	if c.lobTotal(SMB) != 23 {
+		t.Errorf("unexpected LoB total %d", c.lobTotal(SMB))
+	}
+
+	c.mustFail("Forbidden", owner, "Reconcile")
+	var reconciled ReconcileReport
+	c.mustUnmarshal(c.mustInvoke(admin, "Reconcile"), &reconciled)
+	if got := violations(reconciled.Fixed); !equalStrings(got, want[:7]) {
+		t.Errorf("unexpected fixed %v", got)
+	}
+	if got := violations(reconciled.Unresolved); !equalStrings(got, want[7:]) {
+		t.Errorf("unexpected unresolved %v", got)
+	}
+
+	var lob LoB
+	c.mustUnmarshal(c.stub.State[lobKey(c.stub, SMB)], &lob)
+	if lob.TotalCredit != 16 || lob.RolledUpCredit != 16 || !equalStrings(lob.UserIDs, []string{other, applicant}) {
+		t.Errorf("unexpected LoB %+v", lob)
+	}
+	var index ReadingIDIndex
+	c.mustUnmarshal(c.stub.State["readingIDIndex"], &index)
+	if !equalStrings(index.UserIDs, []string{admin, owner, other, applicant}) {
+		t.Errorf("unexpected index %v", index.UserIDs)
+	}
+	if e := c.failure("", "OrderRead", "gone", applicant); e.Code != ErrNotFound {
+		t.Errorf("unexpected error %+v", e)
+	}
+
+	// only the award is left, a second run fixes nothing
+	if got := violations(c.audit().Violations); !equalStrings(got, want[7:]) {
+		t.Errorf("unexpected violations %v", got)
+	}
+	c.mustUnmarshal(c.mustInvoke(admin, "Reconcile"), &reconciled)
+	if len(reconciled.Fixed) != 0 || len(reconciled.Unresolved) != 1 {
+		t.Errorf("unexpected report %+v", reconciled)
+	}
+	if c.credit(other) != 4 || c.lobTotal(SMB) != 16 {
+		t.Errorf("unexpected credit %d", c.credit(other))
+	}
+}
@@ -0,0 +1,582 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// Reasons of a credit movement
+const (
+	JournalOpening  = "opening"
+	JournalAward    = "award"
+	JournalAdd      = "add"
+	JournalTransfer = "transfer"
+	JournalReversal = "reversal"
+	JournalEscrow   = "escrow"
+	JournalRefund   = "refund"
+	JournalDelete   = "delete"
+)
+
+// DefaultJournalPageSize - page size of CreditJournal when none is given
+const DefaultJournalPageSize = 20
+
+// JournalEntry information, stored under the composite key ("CreditJournal", UserID, Timestamp, TxID, Seq)
+//
+//	TxID, Timestamp:   transaction that moved the credit
+//	Delta:             signed change of the balance
+//	Balance:           balance after the change
+//	Reason:            one of the Journal* reasons
+//	Ref:               ticket ID for awards, "creditADD" for constant credit, reversed TxID for reversals
+//	Counterparty:      other UserID of a transfer
+//	Memo:              free text
+type JournalEntry struct {
+	UserID       string    `json:"UserID"`
+	TxID         string    `json:"TxID"`
+	Timestamp    time.Time `json:"Timestamp"`
+	Delta        int       `json:"Delta"`
+	Balance      int       `json:"Balance"`
+	Reason       string    `json:"Reason"`
+	Ref          string    `json:"Ref,omitempty"`
+	Counterparty string    `json:"Counterparty,omitempty"`
+	Memo         string    `json:"Memo,omitempty"`
+}
+
+// creditMovement information, input of applyCreditMovements
+//
+//	TicketID:  appended to Credit.TicketIDs when set, so a ticket is only credited once
+//	Uncredit:  removed from Credit.TicketIDs when set, the award of that ticket was reversed
+//	Budget:    moves LoB_Budget of LoBID instead of the credit of UserID, without journal entry
+type creditMovement struct {
+	UserID       string
+	Delta        int
+	Reason       string
+	Ref          string
+	Counterparty string
+	Memo         string
+	TicketID     string
+	Uncredit     string
+	Budget       bool
+	LoBID        int
+}
+
+// Helper: write one journal entry
+func saveJournalEntry(stub shim.ChaincodeStubInterface, entry JournalEntry, seq int) error {
+	key, err := stub.CreateCompositeKey("CreditJournal", []string{
+		entry.UserID,
+		fmt.Sprintf("%020d", entry.Timestamp.UnixNano()),
+		entry.TxID,
+		entry.Reason + "." + strconv.Itoa(seq)})
+	if err != nil {
+		return errors.New("saveJournalEntry: " + err.Error())
+	}
+	bytes, err := json.Marshal(entry)
+	if err != nil {
+		return errors.New("saveJournalEntry: Error marshalling journal entry")
+	}
+	err = stub.PutState(key, bytes)
+	if err != nil {
+		return errors.New("saveJournalEntry: Error storing journal entry")
+	}
+	return nil
+}
+
+// Helper: LoB of a participant, ok is false for credits without participant
+func participantLoBID(stub shim.ChaincodeStubInterface, userID string) (lobID int, ok bool, err error) {
+	var participant Participant
+	bytes, err := stub.GetState(participantKey(stub, userID))
+	if err != nil {
+		return 0, false, errors.New("participantLoBID: Error get participant with ID: " + userID)
+	}
+	if bytes == nil {
+		return 0, false, nil
+	}
+	err = json.Unmarshal(bytes, &participant)
+	if err != nil {
+		return 0, false, errors.New("participantLoBID: Corrupt reading record " + userID)
+	}
+	return participant.LoBID, true, nil
+}
+
+// Helper: apply credit movements to the balances, their LoB totals rolled up the LoB tree, and the journal
+// Every credit and LoB is read and written once, as GetState does not see writes of the same transaction;
+// call it once per transaction. Debits below zero are rejected.
+func applyCreditMovements(stub shim.ChaincodeStubInterface, movements []creditMovement) (map[string]Credit, error) {
+	return applyCreditMovementsWith(stub, movements, make(map[int]lobDelta))
+}
+
+// Helper: applyCreditMovements, further LoB changes in totals are written together with the LoB totals of the movements
+func applyCreditMovementsWith(stub shim.ChaincodeStubInterface, movements []creditMovement, totals map[int]lobDelta) (map[string]Credit, error) {
+	credits := make(map[string]Credit)
+	var creditOrder []string
+	lobDeltas := make(map[int]int)
+	budgetDeltas := make(map[int]int)
+
+	timestamp, err := txTime(stub)
+	if err != nil {
+		return credits, err
+	}
+
+	for seq, movement := range movements {
+		if movement.Budget {
+			budgetDeltas[movement.LoBID] += movement.Delta
+			continue
+		}
+		credit, ok := credits[movement.UserID]
+		if !ok {
+			credit, err = retrieveSingleCredit(stub, movement.UserID)
+			if err != nil {
+				return credits, err
+			}
+			creditOrder = append(creditOrder, movement.UserID)
+		}
+
+		if movement.Delta < 0 && credit.Value+movement.Delta < 0 {
+			return credits, newError(ErrInsufficientCredit, fmt.Sprintf("Insufficient credit, %s has %d and can not be debited %d",
+				movement.UserID, credit.Value, -movement.Delta)).on(CreditObjectType, movement.UserID)
+		}
+		credit.Value += movement.Delta
+		if movement.TicketID != "" && !Is_Inarray(credit.TicketIDs, movement.TicketID) {
+			credit.TicketIDs = append(credit.TicketIDs, movement.TicketID)
+		}
+		if movement.Uncredit != "" {
+			var ticketIDs []string
+			for _, ticketID := range credit.TicketIDs {
+				if ticketID != movement.Uncredit {
+					ticketIDs = append(ticketIDs, ticketID)
+				}
+			}
+			credit.TicketIDs = ticketIDs
+		}
+		credits[movement.UserID] = credit
+
+		lobID, ok, err := participantLoBID(stub, movement.UserID)
+		if err != nil {
+			return credits, err
+		}
+		if ok {
+			lobDeltas[lobID] += movement.Delta
+		}
+
+		err = saveJournalEntry(stub, JournalEntry{
+			UserID:       movement.UserID,
+			TxID:         stub.GetTxID(),
+			Timestamp:    timestamp,
+			Delta:        movement.Delta,
+			Balance:      credit.Value,
+			Reason:       movement.Reason,
+			Ref:          movement.Ref,
+			Counterparty: movement.Counterparty,
+			Memo:         movement.Memo}, seq)
+		if err != nil {
+			return credits, err
+		}
+	}
+
+	for _, userID := range creditOrder {
+		creditAsByteArray, err := json.Marshal(credits[userID])
+		if err != nil {
+			return credits, errors.New("applyCreditMovements: " + err.Error())
+		}
+		err = stub.PutState(creditKey(stub, userID), creditAsByteArray)
+		if err != nil {
+			return credits, errors.New("applyCreditMovements: " + err.Error())
+		}
+	}
+
+	// ==== Own totals of the users' LoBs, rolled up to every ancestor, and budgets ====
+	var lobIDs []int
+	for lobID := range lobDeltas {
+		lobIDs = append(lobIDs, lobID)
+	}
+	sort.Ints(lobIDs)
+	for _, lobID := range lobIDs {
+		err = addLoBCredit(stub, totals, lobID, lobDeltas[lobID])
+		if err != nil {
+			return credits, err
+		}
+	}
+	for lobID, delta := range budgetDeltas {
+		total := totals[lobID]
+		total.Budget += delta
+		totals[lobID] = total
+	}
+	return credits, applyLoBDeltas(stub, totals)
+}
+
+// Helper: every journal entry of a user, oldest first
+func retrieveJournal(stub shim.ChaincodeStubInterface, userID string) ([]JournalEntry, error) {
+	var entries []JournalEntry
+	iterator, err := stub.GetStateByPartialCompositeKey("CreditJournal", []string{userID})
+	if err != nil {
+		return entries, errors.New("retrieveJournal: " + err.Error())
+	}
+	defer iterator.Close()
+
+	for iterator.HasNext() {
+		queryResponse, err := iterator.Next()
+		if err != nil {
+			return entries, errors.New("retrieveJournal: " + err.Error())
+		}
+		var entry JournalEntry
+		err = json.Unmarshal(queryResponse.Value, &entry)
+		if err != nil {
+			return entries, errors.New("retrieveJournal: Corrupt journal entry " + queryResponse.Key)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// Helper: one-time opening entry for every credit with a balance but no journal entry, as stored before the
+// journal existed, so CreditVerify finds it consistent. Range queries only see committed state, credits that
+// migrateKeys moved in the same transaction are found under their legacy key. Returns the number of entries.
+func migrateJournal(stub shim.ChaincodeStubInterface) (int, error) {
+	var userIDs []string
+	seen := make(map[string]bool)
+	iterator, err := stub.GetStateByPartialCompositeKey(CreditObjectType, []string{})
+	if err != nil {
+		return 0, errors.New("migrateJournal: " + err.Error())
+	}
+	for iterator.HasNext() {
+		queryResponse, err := iterator.Next()
+		if err != nil {
+			iterator.Close()
+			return 0, errors.New("migrateJournal: " + err.Error())
+		}
+		_, keyParts, err := stub.SplitCompositeKey(queryResponse.Key)
+		if err == nil && len(keyParts) == 1 && !seen[keyParts[0]] {
+			seen[keyParts[0]] = true
+			userIDs = append(userIDs, keyParts[0])
+		}
+	}
+	iterator.Close()
+	iterator, err = stub.GetStateByRange(legacyCreditPrefix, legacyCreditPrefix[:len(legacyCreditPrefix)-1]+"`")
+	if err != nil {
+		return 0, errors.New("migrateJournal: " + err.Error())
+	}
+	for iterator.HasNext() {
+		queryResponse, err := iterator.Next()
+		if err != nil {
+			iterator.Close()
+			return 0, errors.New("migrateJournal: " + err.Error())
+		}
+		userID := queryResponse.Key[len(legacyCreditPrefix):]
+		if !seen[userID] {
+			seen[userID] = true
+			userIDs = append(userIDs, userID)
+		}
+	}
+	iterator.Close()
+
+	timestamp, err := txTime(stub)
+	if err != nil {
+		return 0, err
+	}
+	opened := 0
+	for _, userID := range userIDs {
+		credit, err := retrieveSingleCredit(stub, userID)
+		if e, ok := err.(*ChaincodeError); ok && e.Code == ErrNotFound {
+			continue
+		}
+		if err != nil {
+			return opened, err
+		}
+		if credit.Value == 0 {
+			continue
+		}
+		journal, err := stub.GetStateByPartialCompositeKey("CreditJournal", []string{userID})
+		if err != nil {
+			return opened, errors.New("migrateJournal: " + err.Error())
+		}
+		journaled := journal.HasNext()
+		journal.Close()
+		if journaled {
+			continue
+		}
+		err = saveJournalEntry(stub, JournalEntry{UserID: userID, TxID: stub.GetTxID(), Timestamp: timestamp,
+			Delta: credit.Value, Balance: credit.Value, Reason: JournalOpening, Memo: "balance before the journal"}, 0)
+		if err != nil {
+			return opened, err
+		}
+		opened++
+	}
+	return opened, nil
+}
+
+// Query Route: CreditJournal
+//
+//	args: UserID [, pageSize [, bookmark]]
+//	returns {"UserID", "Entries": [...], "Count", "Bookmark"}, Bookmark is empty on the last page
+func (rdg *SmartContract) CreditJournal(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	userID := args[0]
+	pageSize := DefaultJournalPageSize
+	bookmark := ""
+	if len(args) > 1 && args[1] != "" {
+		size, err := strconv.Atoi(args[1])
+		if err != nil || size <= 0 {
+			return errorResponse(badRequest("CreditJournal", "pageSize must be a positive integer"))
+		}
+		pageSize = size
+	}
+	if len(args) > 2 {
+		bookmark = args[2]
+	}
+
+	// ==== The peer pages through the journal, only one page is read ====
+	iterator, metadata, err := stub.GetStateByPartialCompositeKeyWithPagination("CreditJournal", []string{userID}, int32(pageSize), bookmark)
+	if err != nil {
+		return errorResponse(errInternal("CreditJournal: " + err.Error()))
+	}
+	defer iterator.Close()
+
+	page := []JournalEntry{}
+	for iterator.HasNext() {
+		queryResponse, err := iterator.Next()
+		if err != nil {
+			return errorResponse(errInternal("CreditJournal: " + err.Error()))
+		}
+		var entry JournalEntry
+		err = json.Unmarshal(queryResponse.Value, &entry)
+		if err != nil {
+			return errorResponse(errInternal("CreditJournal: Corrupt journal entry " + queryResponse.Key))
+		}
+		page = append(page, entry)
+	}
+
+	result, err := json.Marshal(map[string]interface{}{
+		"UserID":   userID,
+		"Entries":  page,
+		"Count":    len(page),
+		"Bookmark": metadata.Bookmark})
+	if err != nil {
+		return errorResponse(errInternal("CreditJournal: " + err.Error()))
+	}
+	return shim.Success(result)
+}
+
+// Query Route: CreditVerify
+//
+//	args[0]: UserID
+//	returns {"UserID", "Balance", "JournalBalance", "Entries", "Consistent"}
+func (rdg *SmartContract) CreditVerify(stub shim.ChaincodeStubInterface, userID string) peer.Response {
+	credit, err := retrieveSingleCredit(stub, userID)
+	if err != nil {
+		return errorResponse(err)
+	}
+	entries, err := retrieveJournal(stub, userID)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	journalBalance := 0
+	for _, entry := range entries {
+		journalBalance += entry.Delta
+	}
+
+	result, err := json.Marshal(map[string]interface{}{
+		"UserID":         userID,
+		"Balance":        credit.Value,
+		"JournalBalance": journalBalance,
+		"Entries":        len(entries),
+		"Consistent":     journalBalance == credit.Value})
+	if err != nil {
+		return errorResponse(errInternal("CreditVerify: " + err.Error()))
+	}
+	return shim.Success(result)
+}
+
+// Helper: undo the award of value on ticketID that CreditReverse takes back from userID. The order returns to Done,
+// Ticket_Awarded drops and the escrow of a funded ticket gets the credit back, so the ticket can award it again.
+// Once the ticket expired or its deadline is over the order is closed and the credit refunded to the funder instead,
+// returning that movement for applyCreditMovements; a deleted ticket has nothing left to free.
+func unaward(stub shim.ChaincodeStubInterface, ticketID string, userID string, value int) ([]creditMovement, error) {
+	var ticket Ticket
+	ticketAsBytes, err := stub.GetState(ticketKey(stub, ticketID))
+	if err != nil {
+		return nil, errInternal("unaward: Error getting ticket " + ticketID)
+	}
+	if ticketAsBytes == nil {
+		return nil, nil
+	}
+	err = json.Unmarshal(ticketAsBytes, &ticket)
+	if err != nil {
+		return nil, errInternal("unaward: Corrupt ticket " + ticketID)
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	expired := ticket.Status == Expired || deadlinePassed(ticket, now)
+
+	// awards credited before Ticket_Awarded was kept are not in it
+	ticket.Awarded -= value
+	if ticket.Awarded < 0 {
+		ticket.Awarded = 0
+	}
+
+	pending := make(map[string]Order)
+	order, err := retrieveOrder(stub, ticketID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if order != nil && order.Status == OrderAwarded {
+		order.Status = OrderDone
+		if expired {
+			order.Status = OrderClosed
+		}
+		_, err = OrderSaving(stub, *order)
+		if err != nil {
+			return nil, err
+		}
+		pending[userID] = *order
+		err = emitEvent(stub, ExchainEvent{Name: EventOrderUpdated, TicketID: ticketID, UserID: userID,
+			OldStatus: intPtr(OrderAwarded), NewStatus: intPtr(order.Status)})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var refunds []creditMovement
+	escrow, err := retrieveEscrow(stub, ticketID)
+	if err != nil {
+		return nil, err
+	}
+	if escrow != nil {
+		escrow.Released -= value
+		if expired {
+			escrow.Refunded += value
+			refunds = append(refunds, fundingMovement(*escrow, value, JournalRefund))
+			err = emitEvent(stub, ExchainEvent{Name: EventEscrowRefunded, TicketID: ticketID, UserID: escrow.UserID, Delta: value})
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			escrow.Locked += value
+		}
+		err = saveEscrow(stub, *escrow)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = updateTicketStatus(stub, ticket, pending)
+	if err != nil {
+		return nil, err
+	}
+	return refunds, nil
+}
+
+// Invoke Route: CreditReverse
+//
+//	args[0]: {"UserID": "iXXXXXX", "TxID": "...", "Memo": "..."}
+//	reverses every movement of UserID in TxID; for transfers the counterparty side is reversed too.
+//	A reversed award is taken off its ticket in the same transaction (unaward), the ticket can award it again.
+//	Escrow movements are refunded by TicketDelete and ExpireTickets and can not be reversed.
+func (rdg *SmartContract) CreditReverse(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	var request struct {
+		UserID string `json:"UserID"`
+		TxID   string `json:"TxID"`
+		Memo   string `json:"Memo"`
+	}
+	err := json.Unmarshal([]byte(args[0]), &request)
+	if err != nil {
+		return errorResponse(badRequest("CreditReverse", err.Error()))
+	}
+	if request.UserID == "" || request.TxID == "" {
+		return errorResponse(badRequest("CreditReverse", "UserID and TxID are needed"))
+	}
+
+	var movements []creditMovement
+	reverse := func(userID string, counterparty bool) error {
+		entries, err := retrieveJournal(stub, userID)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if entry.Reason == JournalReversal && entry.Ref == request.TxID {
+				return newError(ErrConflict, "CreditReverse: Transaction "+request.TxID+" was already reversed for "+userID).
+					on(CreditObjectType, userID)
+			}
+		}
+		for _, entry := range entries {
+			if entry.TxID != request.TxID || entry.Reason == JournalReversal {
+				continue
+			}
+			if counterparty && entry.Counterparty != request.UserID {
+				continue
+			}
+			if entry.Reason == JournalEscrow || entry.Reason == JournalRefund {
+				return newError(ErrConflict, "CreditReverse: The "+entry.Reason+" of ticket "+entry.Ref+" in transaction "+request.TxID+
+					" can not be reversed").on(CreditObjectType, userID)
+			}
+			uncredit := ""
+			if entry.Reason == JournalAward {
+				uncredit = entry.Ref
+			}
+			movements = append(movements, creditMovement{
+				UserID:       userID,
+				Delta:        -entry.Delta,
+				Reason:       JournalReversal,
+				Ref:          request.TxID,
+				Counterparty: entry.Counterparty,
+				Memo:         request.Memo,
+				Uncredit:     uncredit})
+		}
+		return nil
+	}
+
+	err = reverse(request.UserID, false)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if len(movements) == 0 {
+		return errorResponse(newError(ErrNotFound, "CreditReverse: No movement of "+request.UserID+" in transaction "+request.TxID).
+			on(CreditObjectType, request.UserID))
+	}
+	for _, movement := range movements {
+		if movement.Counterparty != "" && movement.Counterparty != request.UserID {
+			err = reverse(movement.Counterparty, true)
+			if err != nil {
+				return errorResponse(err)
+			}
+			break
+		}
+	}
+
+	// ==== Reversed awards give their credit back to the ticket, in the same pass over credits and LoBs ====
+	var refunds []creditMovement
+	for _, movement := range movements {
+		if movement.Uncredit == "" {
+			continue
+		}
+		refund, err := unaward(stub, movement.Uncredit, movement.UserID, -movement.Delta)
+		if err != nil {
+			return errorResponse(err)
+		}
+		refunds = append(refunds, refund...)
+	}
+
+	_, err = applyCreditMovements(stub, append(movements, refunds...))
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	for _, movement := range movements {
+		err = emitEvent(stub, ExchainEvent{Name: EventCreditReversed, UserID: movement.UserID, Delta: movement.Delta})
+		if err != nil {
+			return errorResponse(err)
+		}
+	}
+	result, err := json.Marshal(map[string]interface{}{"TxID": request.TxID, "Reversed": len(movements)})
+	if err != nil {
+		return errorResponse(errInternal("CreditReverse: " + err.Error()))
+	}
+	return shim.Success(result)
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// Object types of the composite keys every entity is stored under
+//
+//	("Participant", UserID)   ("Ticket", TicketID)   ("LoB", LoBID)
+//	("Credit", UserID)        ("Order", TicketID, UserID)   ("Escrow", TicketID)
+const (
+	ParticipantObjectType = "Participant"
+	TicketObjectType      = "Ticket"
+	LoBObjectType         = "LoB"
+	CreditObjectType      = "Credit"
+	OrderObjectType       = "Order"
+	EscrowObjectType      = "Escrow"
+)
+
+// legacyCreditPrefix - prefix of the credit keys before they were namespaced
+const legacyCreditPrefix = "Credit_UerID_"
+
+// an invalid attribute gives an empty key, which the ledger then rejects
+func participantKey(stub shim.ChaincodeStubInterface, userID string) string {
+	key, _ := stub.CreateCompositeKey(ParticipantObjectType, []string{userID})
+	return key
+}
+
+func ticketKey(stub shim.ChaincodeStubInterface, ticketID string) string {
+	key, _ := stub.CreateCompositeKey(TicketObjectType, []string{ticketID})
+	return key
+}
+
+func lobKey(stub shim.ChaincodeStubInterface, LoBID int) string {
+	key, _ := stub.CreateCompositeKey(LoBObjectType, []string{strconv.Itoa(LoBID)})
+	return key
+}
+
+func creditKey(stub shim.ChaincodeStubInterface, userID string) string {
+	key, _ := stub.CreateCompositeKey(CreditObjectType, []string{userID})
+	return key
+}
+
+func orderKey(stub shim.ChaincodeStubInterface, ticketID string, userID string) string {
+	key, _ := stub.CreateCompositeKey(OrderObjectType, []string{ticketID, userID})
+	return key
+}
+
+func escrowKey(stub shim.ChaincodeStubInterface, ticketID string) string {
+	key, _ := stub.CreateCompositeKey(EscrowObjectType, []string{ticketID})
+	return key
+}
+
+// pendingStub - stub whose GetState sees the writes of the running transaction, which GetState of a peer does not.
+// The migrations of Init build on each other's writes in one transaction: passwords are moved out of participants
+// that migrateKeys just moved, the LoB_UserIDs cleanup reads LoBs migrated a step before. Range queries still
+// only see committed state, the migrations run them before writing the keys they return.
+type pendingStub struct {
+	shim.ChaincodeStubInterface
+	pending map[string][]byte
+}
+
+func newPendingStub(stub shim.ChaincodeStubInterface) *pendingStub {
+	if pending, ok := stub.(*pendingStub); ok {
+		return pending
+	}
+	return &pendingStub{ChaincodeStubInterface: stub, pending: make(map[string][]byte)}
+}
+
+func (s *pendingStub) GetState(key string) ([]byte, error) {
+	if value, ok := s.pending[key]; ok {
+		return value, nil
+	}
+	return s.ChaincodeStubInterface.GetState(key)
+}
+
+func (s *pendingStub) PutState(key string, value []byte) error {
+	err := s.ChaincodeStubInterface.PutState(key, value)
+	if err == nil {
+		s.pending[key] = value
+	}
+	return err
+}
+
+// a deleted key reads as nil, like a key that never existed
+func (s *pendingStub) DelState(key string) error {
+	err := s.ChaincodeStubInterface.DelState(key)
+	if err == nil {
+		s.pending[key] = nil
+	}
+	return err
+}
+
+// KeyMigrationReport information, result of MigrateKeys
+//
+//	Participants, Credits, LoBs, Tickets:   records moved to their composite key
+//	Dropped:                                UserIDs removed from readingIDIndex / LoB_UserIDs, their record was lost
+type KeyMigrationReport struct {
+	Participants int      `json:"Participants"`
+	Credits      int      `json:"Credits"`
+	LoBs         int      `json:"LoBs"`
+	Tickets      int      `json:"Tickets"`
+	Dropped      []string `json:"Dropped"`
+}
+
+// Helper: move the value of a plain key to a composite key, keep reports whether it was moved
+func moveKey(stub shim.ChaincodeStubInterface, from string, to string, keep func([]byte) bool) (bool, error) {
+	bytes, err := stub.GetState(from)
+	if err != nil {
+		return false, errors.New("moveKey: Error getting " + from)
+	}
+	if bytes == nil || !keep(bytes) {
+		return false, nil
+	}
+	err = stub.PutState(to, bytes)
+	if err != nil {
+		return false, errors.New("moveKey: Error storing " + from)
+	}
+	err = stub.DelState(from)
+	if err != nil {
+		return false, errors.New("moveKey: Error deleting " + from)
+	}
+	return true, nil
+}
+
+// Helper: one-time rewrite of plain keys (UserID, TicketID, LoB name, Credit_UerID_*) into composite keys.
+// It is idempotent: keys already moved are no longer found under their plain name.
+func migrateKeys(stub shim.ChaincodeStubInterface) (KeyMigrationReport, error) {
+	var report KeyMigrationReport
+	var readingIDs ReadingIDIndex
+	report.Dropped = []string{}
+
+	// ==== LoBs, before participants so LoB_UserIDs can be checked; legacy records had no LoB_Name ====
+	for LoBID, LobName := range defaultLoBs {
+		bytes, err := stub.GetState(LobName)
+		if err != nil {
+			return report, errors.New("migrateKeys: Error getting " + LobName)
+		}
+		var fields map[string]interface{}
+		var lob LoB
+		if bytes == nil || json.Unmarshal(bytes, &fields) != nil || fields["LoB_LoBID"] != float64(LoBID) || json.Unmarshal(bytes, &lob) != nil {
+			continue
+		}
+		if lob.Name == "" {
+			lob.Name = LobName
+		}
+		// legacy LoBs had no sub-LoBs
+		lob.RolledUpCredit = lob.TotalCredit
+		err = saveLoB(stub, lob)
+		if err != nil {
+			return report, err
+		}
+		err = stub.DelState(LobName)
+		if err != nil {
+			return report, errors.New("migrateKeys: Error deleting " + LobName)
+		}
+		report.LoBs++
+	}
+
+	// ==== Tickets numbered by the retired TICKETID counter, and tickets of the TicketIndex ====
+	var legacyTicketIDs []string
+	counterAsBytes, err := stub.GetState("TICKETID")
+	if err != nil {
+		return report, errors.New("migrateKeys: Error getting TICKETID")
+	}
+	counter, _ := strconv.Atoi(string(counterAsBytes))
+	for i := 1; i <= counter; i++ {
+		legacyTicketIDs = append(legacyTicketIDs, strconv.Itoa(i))
+	}
+	indexIterator, err := stub.GetStateByPartialCompositeKey("TicketIndex", []string{})
+	if err != nil {
+		return report, errors.New("migrateKeys: " + err.Error())
+	}
+	var indexKeys []string
+	for indexIterator.HasNext() {
+		queryResponse, err := indexIterator.Next()
+		if err != nil {
+			indexIterator.Close()
+			return report, errors.New("migrateKeys: " + err.Error())
+		}
+		_, keyParts, err := stub.SplitCompositeKey(queryResponse.Key)
+		if err == nil && len(keyParts) == 1 {
+			legacyTicketIDs = append(legacyTicketIDs, keyParts[0])
+		}
+		indexKeys = append(indexKeys, queryResponse.Key)
+	}
+	indexIterator.Close()
+
+	for _, ticketID := range legacyTicketIDs {
+		moved, err := moveKey(stub, ticketID, ticketKey(stub, ticketID), func(bytes []byte) bool {
+			var ticket Ticket
+			return json.Unmarshal(bytes, &ticket) == nil && ticket.TicketID == ticketID
+		})
+		if err != nil {
+			return report, err
+		}
+		if moved {
+			report.Tickets++
+		}
+	}
+	for _, key := range indexKeys {
+		err = stub.DelState(key)
+		if err != nil {
+			return report, errors.New("migrateKeys: Error deleting ticket index")
+		}
+	}
+	if counterAsBytes != nil {
+		err = stub.DelState("TICKETID")
+		if err != nil {
+			return report, errors.New("migrateKeys: Error deleting TICKETID")
+		}
+	}
+
+	// ==== Credits, including those created without participant ====
+	creditIterator, err := stub.GetStateByRange(legacyCreditPrefix, legacyCreditPrefix[:len(legacyCreditPrefix)-1]+"`")
+	if err != nil {
+		return report, errors.New("migrateKeys: " + err.Error())
+	}
+	var creditUserIDs []string
+	for creditIterator.HasNext() {
+		queryResponse, err := creditIterator.Next()
+		if err != nil {
+			creditIterator.Close()
+			return report, errors.New("migrateKeys: " + err.Error())
+		}
+		creditUserIDs = append(creditUserIDs, queryResponse.Key[len(legacyCreditPrefix):])
+	}
+	creditIterator.Close()
+	for _, userID := range creditUserIDs {
+		moved, err := moveKey(stub, legacyCreditPrefix+userID, creditKey(stub, userID), func(bytes []byte) bool { return true })
+		if err != nil {
+			return report, err
+		}
+		if moved {
+			report.Credits++
+		}
+	}
+
+	// ==== Participants listed in readingIDIndex ====
+	bytes, err := stub.GetState("readingIDIndex")
+	if err != nil {
+		return report, errors.New("migrateKeys: Error getting readingIDIndex array")
+	}
+	if len(bytes) == 0 {
+		return report, nil
+	}
+	err = json.Unmarshal(bytes, &readingIDs)
+	if err != nil {
+		return report, errors.New("migrateKeys: Error unmarshalling readingIDIndex array JSON")
+	}
+
+	var kept []string
+	seen := make(map[string]bool)
+	for _, participantID := range readingIDs.UserIDs {
+		if seen[participantID] {
+			continue
+		}
+		seen[participantID] = true
+		moved, err := moveKey(stub, participantID, participantKey(stub, participantID), func(bytes []byte) bool {
+			var participant Participant
+			return json.Unmarshal(bytes, &participant) == nil && participant.UserID == participantID
+		})
+		if err != nil {
+			return report, err
+		}
+		if moved {
+			report.Participants++
+		}
+		// overwritten by a ticket or LoB with the same key before the migration
+		exists, err := stub.GetState(participantKey(stub, participantID))
+		if err != nil {
+			return report, errors.New("migrateKeys: Error getting participant with ID: " + participantID)
+		}
+		if exists == nil {
+			report.Dropped = append(report.Dropped, participantID)
+			continue
+		}
+		kept = append(kept, participantID)
+	}
+
+	if len(report.Dropped) > 0 || len(kept) != len(readingIDs.UserIDs) {
+		readingIDs.UserIDs = kept
+		bytes, err = json.Marshal(readingIDs)
+		if err != nil {
+			return report, errors.New("migrateKeys: Error marshalling readingIDIndex")
+		}
+		err = stub.PutState("readingIDIndex", bytes)
+		if err != nil {
+			return report, errors.New("migrateKeys: Error storing readingIDIndex")
+		}
+
+		for LoBID := range defaultLoBs {
+			var lob LoB
+			bytes, err = stub.GetState(lobKey(stub, LoBID))
+			if err != nil || bytes == nil || json.Unmarshal(bytes, &lob) != nil {
+				continue
+			}
+			var members []string
+			for _, userID := range lob.UserIDs {
+				if Is_Inarray(kept, userID) {
+					members = append(members, userID)
+				}
+			}
+			if len(members) == len(lob.UserIDs) {
+				continue
+			}
+			lob.UserIDs = members
+			bytes, err = json.Marshal(lob)
+			if err != nil {
+				return report, errors.New("migrateKeys: Error marshalling LoB info")
+			}
+			err = stub.PutState(lobKey(stub, LoBID), bytes)
+			if err != nil {
+				return report, errors.New("migrateKeys: Error storing LoB info")
+			}
+		}
+	}
+	return report, nil
+}
+
+// Invoke Route: MigrateKeys - run the key migration again, Init runs it on every upgrade
+func (rdg *SmartContract) MigrateKeys(stub shim.ChaincodeStubInterface) peer.Response {
+	report, err := migrateKeys(newPendingStub(stub))
+	if err != nil {
+		return errorResponse(err)
+	}
+	reportAsBytes, err := json.Marshal(report)
+	if err != nil {
+		return errorResponse(errInternal("MigrateKeys: " + err.Error()))
+	}
+	return shim.Success(reportAsBytes)
+}
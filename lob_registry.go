@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// defaultLoBs - names of the LoBs Init seeds when they are missing, indexed by LoBID (see the MD_office ... IoT enum).
+// Records stored under these names before keys were namespaced are migrated by migrateKeys.
+var defaultLoBs = []string{"MD_office", "HANA", "SMB", "IBS", "S4_HANA", "GS", "SF", "IoT"}
+
+// MaxLoBNameLength - longest LoB_Name accepted by LoBCreate and LoBRename
+const MaxLoBNameLength = 64
+
+// Helper: add the default LoBs that are not on the ledger yet, LoBs are never deleted so existing ones are kept
+func seedLoBs(stub shim.ChaincodeStubInterface) (int, error) {
+	seeded := 0
+	for LoBID, name := range defaultLoBs {
+		bytes, err := stub.GetState(lobKey(stub, LoBID))
+		if err != nil {
+			return seeded, errInternal("seedLoBs: Error getting LoB " + strconv.Itoa(LoBID))
+		}
+		if bytes != nil {
+			continue
+		}
+		err = saveLoB(stub, LoB{LoBID: LoBID, Name: name})
+		if err != nil {
+			return seeded, err
+		}
+		seeded++
+	}
+	return seeded, nil
+}
+
+func saveLoB(stub shim.ChaincodeStubInterface, lob LoB) error {
+	bytes, err := json.Marshal(lob)
+	if err != nil {
+		return errInternal("saveLoB: " + err.Error())
+	}
+	err = stub.PutState(lobKey(stub, lob.LoBID), bytes)
+	if err != nil {
+		return errInternal("saveLoB: " + err.Error())
+	}
+	return nil
+}
+
+// Helper: a LoB of the registry, NotFound if there is none with LoBID
+func retrieveLoB(stub shim.ChaincodeStubInterface, LoBID int) (LoB, error) {
+	var lob LoB
+	bytes, err := stub.GetState(lobKey(stub, LoBID))
+	if err != nil {
+		return lob, errInternal("retrieveLoB: Error getting LoB " + strconv.Itoa(LoBID))
+	}
+	if bytes == nil {
+		return lob, errNotFound(LoBObjectType, strconv.Itoa(LoBID))
+	}
+	err = json.Unmarshal(bytes, &lob)
+	if err != nil {
+		return lob, errInternal("retrieveLoB: Corrupt LoB " + strconv.Itoa(LoBID))
+	}
+	return lob, nil
+}
+
+// Helper: a LoB that still takes participants, funding and a new name
+func activeLoB(stub shim.ChaincodeStubInterface, function string, LoBID int) (LoB, error) {
+	lob, err := retrieveLoB(stub, LoBID)
+	if err != nil {
+		return lob, err
+	}
+	if lob.Archived {
+		return lob, newError(ErrConflict, function+": LoB "+strconv.Itoa(LoBID)+" is archived").on(LoBObjectType, strconv.Itoa(LoBID))
+	}
+	return lob, nil
+}
+
+// Helper: the LoB a participant is registered in or moved to must be an active LoB of the registry
+func checkParticipantLoB(stub shim.ChaincodeStubInterface, function string, LoBID int) error {
+	_, err := activeLoB(stub, function, LoBID)
+	if e, ok := err.(*ChaincodeError); ok && (e.Code == ErrNotFound || e.Code == ErrConflict) {
+		return badRequest(function, "Participant_LoBID "+strconv.Itoa(LoBID)+" is not an active LoB")
+	}
+	return err
+}
+
+// Helper: every LoB of the registry, archived ones included, by LoBID
+func listLoBs(stub shim.ChaincodeStubInterface) ([]LoB, error) {
+	lobs := []LoB{}
+	iterator, err := stub.GetStateByPartialCompositeKey(LoBObjectType, []string{})
+	if err != nil {
+		return nil, errInternal("listLoBs: " + err.Error())
+	}
+	defer iterator.Close()
+
+	for iterator.HasNext() {
+		queryResponse, err := iterator.Next()
+		if err != nil {
+			return nil, errInternal("listLoBs: " + err.Error())
+		}
+		var lob LoB
+		err = json.Unmarshal(queryResponse.Value, &lob)
+		if err != nil {
+			return nil, errInternal("listLoBs: Corrupt LoB " + queryResponse.Key)
+		}
+		lobs = append(lobs, lob)
+	}
+	// keys sort "10" before "2"
+	sort.Slice(lobs, func(i, j int) bool {
+		return lobs[i].LoBID < lobs[j].LoBID
+	})
+	return lobs, nil
+}
+
+// Helper: refuse a LoB_Name another LoB, archived or not, already has
+func checkLoBName(lobs []LoB, LoBID int, name string) error {
+	for _, lob := range lobs {
+		if lob.LoBID != LoBID && lob.Name == name {
+			return newError(ErrAlreadyExists, "LoB name "+name+" is taken by LoB "+strconv.Itoa(lob.LoBID)).
+				on(LoBObjectType, strconv.Itoa(lob.LoBID))
+		}
+	}
+	return nil
+}
+
+// Invoke Route: LoBCreate
+//
+//	args[0]: {"LoB_Name": "Ariba", "LoB_ParentID": 1}, LoB_ParentID is optional
+//	adds a LoB with the next free LoBID and returns it
+func (rdg *SmartContract) LoBCreate(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	var lob LoB
+	err := json.Unmarshal([]byte(args[0]), &lob)
+	if err != nil {
+		return errorResponse(badRequest("LoBCreate", err.Error()))
+	}
+
+	lobs, err := listLoBs(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = checkLoBName(lobs, -1, lob.Name)
+	if err != nil {
+		return errorResponse(err)
+	}
+	lob = LoB{LoBID: 0, Name: lob.Name, ParentID: lob.ParentID}
+	if len(lobs) > 0 {
+		lob.LoBID = lobs[len(lobs)-1].LoBID + 1
+	}
+	// ==== A new LoB has no credit yet, the totals of its ancestors stay as they are ====
+	_, err = checkLoBParent(stub, "LoBCreate", lob.LoBID, lob.ParentID)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	err = saveLoB(stub, lob)
+	if err != nil {
+		return errorResponse(err)
+	}
+	logger.Info("LoBCreate:", lob.LoBID, lob.Name)
+
+	lobAsBytes, err := json.Marshal(lob)
+	if err != nil {
+		return errorResponse(errInternal("LoBCreate: " + err.Error()))
+	}
+	return shim.Success(lobAsBytes)
+}
+
+// Invoke Route: LoBRename
+//
+//	args[0]: {"LoB_LoBID": 0, "LoB_Name": "MD office"}
+func (rdg *SmartContract) LoBRename(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	var request LoB
+	err := json.Unmarshal([]byte(args[0]), &request)
+	if err != nil {
+		return errorResponse(badRequest("LoBRename", err.Error()))
+	}
+
+	lob, err := activeLoB(stub, "LoBRename", request.LoBID)
+	if err != nil {
+		return errorResponse(err)
+	}
+	lobs, err := listLoBs(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = checkLoBName(lobs, lob.LoBID, request.Name)
+	if err != nil {
+		return errorResponse(err)
+	}
+	lob.Name = request.Name
+
+	err = saveLoB(stub, lob)
+	if err != nil {
+		return errorResponse(err)
+	}
+	lobAsBytes, err := json.Marshal(lob)
+	if err != nil {
+		return errorResponse(errInternal("LoBRename: " + err.Error()))
+	}
+	return shim.Success(lobAsBytes)
+}
+
+// Invoke Route: LoBArchive
+//
+//	args[0]: LoBID of a LoB without participants and active sub-LoBs
+//	an archived LoB keeps its ID, name, credit and budget for history and takes no new participants
+func (rdg *SmartContract) LoBArchive(stub shim.ChaincodeStubInterface, LoBid string) peer.Response {
+	LoBID, _ := strconv.Atoi(LoBid)
+	lob, err := activeLoB(stub, "LoBArchive", LoBID)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if len(lob.UserIDs) > 0 {
+		return errorResponse(newError(ErrConflict, "LoBArchive: LoB "+LoBid+" still has "+strconv.Itoa(len(lob.UserIDs))+" participants").
+			on(LoBObjectType, LoBid))
+	}
+	lobs, err := listLoBs(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if children := subLoBs(lobs, LoBID); len(children) > 0 {
+		return errorResponse(newError(ErrConflict, "LoBArchive: LoB "+LoBid+" still has "+strconv.Itoa(len(children))+" sub-LoBs").
+			on(LoBObjectType, LoBid))
+	}
+	lob.Archived = true
+
+	err = saveLoB(stub, lob)
+	if err != nil {
+		return errorResponse(err)
+	}
+	lobAsBytes, err := json.Marshal(lob)
+	if err != nil {
+		return errorResponse(errInternal("LoBArchive: " + err.Error()))
+	}
+	return shim.Success(lobAsBytes)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+func (c *testChain) lobs() []LoB {
+	c.t.Helper()
+	var lobs []LoB
+	c.mustUnmarshal(c.mustInvoke("", "LoBReadAll"), &lobs)
+	return lobs
+}
+
+func TestLoBCreate(t *testing.T) {
+	c := newExchain(t)
+	c.mustFail("Forbidden", owner, "LoBCreate", `{"LoB_Name": "Ariba"}`)
+	c.mustFail("field LoB_Name must not be empty", admin, "LoBCreate", `{"LoB_Name": ""}`)
+
+	var lob LoB
+	c.mustUnmarshal(c.mustInvoke(admin, "LoBCreate", `{"LoB_Name": "Ariba"}`), &lob)
+	ariba := len(defaultLoBs)
+	if lob.LoBID != ariba || lob.Name != "Ariba" || lob.Archived {
+		t.Errorf("unexpected LoB %+v", lob)
+	}
+	e := c.failure(admin, "LoBCreate", `{"LoB_Name": "HANA"}`)
+	if e.Code != ErrAlreadyExists || e.Entity != LoBObjectType || e.ID != strconv.Itoa(HANA) {
+		t.Errorf("unexpected error %+v", e)
+	}
+
+	// the new LoB takes participants and credit like the seeded ones
+	c.register("i000005", ariba, false)
+	c.mustInvoke(admin, "CreditAdd", `{"userID": "i000005", "value": 5, "ticketID": "creditADD"}`)
+	lobs := c.lobs()
+	if len(lobs) != ariba+1 || lobs[ariba].Name != "Ariba" || lobs[ariba].TotalCredit != 5 || lobs[ariba].UserIDs != nil {
+		t.Errorf("unexpected LoBs %+v", lobs)
+	}
+	c.mustFail("Participant_LoBID 10 is not an active LoB", admin, "addParticipant", participantJSON("i000006", 10, false))
+
+	// IDs keep growing past ten, listed in numeric order
+	for i := ariba + 1; i <= 11; i++ {
+		c.mustInvoke(admin, "LoBCreate", `{"LoB_Name": "LoB `+strconv.Itoa(i)+`"}`)
+	}
+	for i, lob := range c.lobs() {
+		if lob.LoBID != i {
+			t.Errorf("LoB %d listed at %d", lob.LoBID, i)
+		}
+	}
+}
+
+func TestLoBRenameAndArchive(t *testing.T) {
+	c := newExchain(t)
+	var lob LoB
+	c.mustUnmarshal(c.mustInvoke(admin, "LoBRename", `{"LoB_LoBID": 0, "LoB_Name": "MD office"}`), &lob)
+	if lob.LoBID != MD_office || lob.Name != "MD office" || !equalStrings(lob.UserIDs, []string{admin}) {
+		t.Errorf("unexpected LoB %+v", lob)
+	}
+	c.mustInvoke(admin, "LoBRename", `{"LoB_LoBID": 0, "LoB_Name": "MD office"}`)
+	if e := c.failure(admin, "LoBRename", `{"LoB_LoBID": 0, "LoB_Name": "SMB"}`); e.Code != ErrAlreadyExists {
+		t.Errorf("unexpected error %+v", e)
+	}
+	if e := c.failure(admin, "LoBRename", `{"LoB_LoBID": 42, "LoB_Name": "x"}`); e.Code != ErrNotFound {
+		t.Errorf("unexpected error %+v", e)
+	}
+	c.mustFail("Forbidden", owner, "LoBRename", `{"LoB_LoBID": 0, "LoB_Name": "x"}`)
+
+	// Init on upgrade does not undo the rename
+	response := c.run("", func(stub *testStub) peer.Response { return c.cc.Init(stub) })
+	if response.Status != shim.OK || c.lobs()[MD_office].Name != "MD office" {
+		t.Fatalf("Init reset the registry: %s", response.Message)
+	}
+
+	// only LoBs without participants are archived
+	e := c.failure(admin, "LoBArchive", strconv.Itoa(SMB))
+	if e.Code != ErrConflict || e.Message != "LoBArchive: LoB 2 still has 2 participants" {
+		t.Errorf("unexpected error %+v", e)
+	}
+	c.mustFail("Forbidden", owner, "LoBArchive", strconv.Itoa(IoT))
+	c.mustUnmarshal(c.mustInvoke(admin, "LoBArchive", strconv.Itoa(IoT)), &lob)
+	if !lob.Archived || !c.lobs()[IoT].Archived {
+		t.Errorf("unexpected LoB %+v", lob)
+	}
+
+	// an archived LoB keeps its name and takes no participants, funding or new name
+	c.mustFail("Participant_LoBID 7 is not an active LoB", admin, "addParticipant", participantJSON("i000005", IoT, false))
+	c.mustFail("Participant_LoBID 7 is not an active LoB", admin, "ParticipantChangeLoB",
+		`{"UserID": "`+owner+`", "LoBID": 7, "CreditPolicy": "Move"}`)
+	for _, call := range [][]string{
+		{"LoBRename", `{"LoB_LoBID": 7, "LoB_Name": "Things"}`},
+		{"LoBFund", `{"LoBID": 7, "Value": 10}`},
+		{"LoBArchive", "7"},
+	} {
+		if e := c.failure(admin, call[0], call[1]); e.Code != ErrConflict || e.Entity != LoBObjectType || e.ID != "7" {
+			t.Errorf("%s: unexpected error %+v", call[0], e)
+		}
+	}
+	if e := c.failure(admin, "LoBCreate", `{"LoB_Name": "IoT"}`); e.Code != ErrAlreadyExists {
+		t.Errorf("unexpected error %+v", e)
+	}
+	c.mustUnmarshal(c.mustInvoke(admin, "LoBCreate", `{"LoB_Name": "Things"}`), &lob)
+	if lob.LoBID != len(defaultLoBs) {
+		t.Errorf("archived LoBs keep their ID, got %+v", lob)
+	}
+
+	bytes, _ := json.Marshal(c.lobs()[IoT])
+	if string(bytes) != `{"LoB_LoBID":7,"LoB_Name":"IoT","LoB_Archived":true,"LoB_TotalCredit":0,"LoB_RolledUpCredit":0,"LoB_Budget":0,"LoB_UserIDs":null}` {
+		t.Errorf("unexpected LoB %s", bytes)
+	}
+}
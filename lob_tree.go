@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// lobDelta information, change of one LoB, see updateLoBCredit
+//
+//	Credit:         LoB_TotalCredit, credit of its own participants
+//	Retained:       LoB_RetainedCredit, part of Credit former participants left behind
+//	RolledUp:       LoB_RolledUpCredit, credit of its participants and those of its sub-LoBs
+//	Budget:         LoB_Budget
+//	Join, Leave:    UserID added to or removed from LoB_UserIDs
+type lobDelta struct {
+	Credit   int
+	Retained int
+	RolledUp int
+	Budget   int
+	Join     string
+	Leave    string
+}
+
+// LoBTotals information, a LoB as returned by LoBReadAll
+//
+//	Members:           participants of the LoB itself
+//	RolledUpMembers:   participants of the LoB and of all its sub-LoBs
+type LoBTotals struct {
+	LoB
+	Members         int `json:"LoB_Members"`
+	RolledUpMembers int `json:"LoB_RolledUpMembers"`
+}
+
+// Helper: parent, grandparent, ... of a LoB up to its top-level LoB
+func lobAncestors(stub shim.ChaincodeStubInterface, LoBID int) ([]int, error) {
+	var ancestors []int
+	lob, err := retrieveLoB(stub, LoBID)
+	if err != nil {
+		return nil, err
+	}
+	seen := map[int]bool{LoBID: true}
+	for lob.ParentID != nil {
+		parentID := *lob.ParentID
+		if seen[parentID] {
+			return nil, errInternal("lobAncestors: LoB " + strconv.Itoa(LoBID) + " is in a cycle")
+		}
+		seen[parentID] = true
+		ancestors = append(ancestors, parentID)
+		lob, err = retrieveLoB(stub, parentID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ancestors, nil
+}
+
+// Helper: add a change of the own credit of LoBID to totals, rolled up to the LoB itself and each of its ancestors
+func addLoBCredit(stub shim.ChaincodeStubInterface, totals map[int]lobDelta, LoBID int, credit int) error {
+	if credit == 0 {
+		return nil
+	}
+	ancestors, err := lobAncestors(stub, LoBID)
+	if err != nil {
+		return err
+	}
+	total := totals[LoBID]
+	total.Credit += credit
+	totals[LoBID] = total
+	for _, rolledUpID := range append([]int{LoBID}, ancestors...) {
+		total = totals[rolledUpID]
+		total.RolledUp += credit
+		totals[rolledUpID] = total
+	}
+	return nil
+}
+
+// Helper: write the changes of totals, every LoB once and by LoBID, as GetState does not see writes of the same transaction
+func applyLoBDeltas(stub shim.ChaincodeStubInterface, totals map[int]lobDelta) error {
+	var lobIDs []int
+	for lobID := range totals {
+		lobIDs = append(lobIDs, lobID)
+	}
+	sort.Ints(lobIDs)
+	for _, lobID := range lobIDs {
+		if totals[lobID] == (lobDelta{}) {
+			continue
+		}
+		_, err := updateLoBCredit(stub, lobID, totals[lobID])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Helper: walk every LoB of lobs up to its top-level LoB, visit gets each LoB and each of its ancestors in turn
+func walkLoBTree(lobs []LoB, visit func(lob LoB, ancestor LoB)) {
+	byID := make(map[int]LoB)
+	for _, lob := range lobs {
+		byID[lob.LoBID] = lob
+	}
+	for _, lob := range lobs {
+		seen := make(map[int]bool)
+		ancestor, ok := lob, true
+		for ok && !seen[ancestor.LoBID] {
+			seen[ancestor.LoBID] = true
+			visit(lob, ancestor)
+			if ancestor.ParentID == nil {
+				break
+			}
+			ancestor, ok = byID[*ancestor.ParentID]
+		}
+	}
+}
+
+// Helper: lobs with their own and rolled-up member counts
+func lobTotals(lobs []LoB) []LoBTotals {
+	rolledUp := make(map[int]int)
+	walkLoBTree(lobs, func(lob LoB, ancestor LoB) {
+		rolledUp[ancestor.LoBID] += len(lob.UserIDs)
+	})
+	totals := make([]LoBTotals, 0, len(lobs))
+	for _, lob := range lobs {
+		totals = append(totals, LoBTotals{LoB: lob, Members: len(lob.UserIDs), RolledUpMembers: rolledUp[lob.LoBID]})
+	}
+	return totals
+}
+
+// Helper: recompute LoB_RolledUpCredit from the own totals, for LoBs stored before the tree existed.
+// Init runs it first, so it only reads records committed before the upgrade.
+func rollUpLoBs(stub shim.ChaincodeStubInterface) (int, error) {
+	lobs, err := listLoBs(stub)
+	if err != nil {
+		return 0, err
+	}
+	rolledUp := make(map[int]int)
+	walkLoBTree(lobs, func(lob LoB, ancestor LoB) {
+		rolledUp[ancestor.LoBID] += lob.TotalCredit
+	})
+	fixed := 0
+	for _, lob := range lobs {
+		if lob.RolledUpCredit == rolledUp[lob.LoBID] {
+			continue
+		}
+		lob.RolledUpCredit = rolledUp[lob.LoBID]
+		err = saveLoB(stub, lob)
+		if err != nil {
+			return fixed, err
+		}
+		fixed++
+	}
+	return fixed, nil
+}
+
+// Helper: the parent of a new or moved LoB must be an active LoB outside of its own subtree
+func checkLoBParent(stub shim.ChaincodeStubInterface, function string, LoBID int, parentID *int) ([]int, error) {
+	if parentID == nil {
+		return nil, nil
+	}
+	_, err := activeLoB(stub, function, *parentID)
+	if err != nil {
+		return nil, err
+	}
+	ancestors, err := lobAncestors(stub, *parentID)
+	if err != nil {
+		return nil, err
+	}
+	ancestors = append([]int{*parentID}, ancestors...)
+	for _, ancestorID := range ancestors {
+		if ancestorID == LoBID {
+			return nil, badRequest(function, "LoB "+strconv.Itoa(LoBID)+" can not be placed under its own sub-LoB "+strconv.Itoa(*parentID))
+		}
+	}
+	return ancestors, nil
+}
+
+// Helper: active LoBs whose parent is LoBID
+func subLoBs(lobs []LoB, LoBID int) []int {
+	var children []int
+	for _, lob := range lobs {
+		if lob.ParentID != nil && *lob.ParentID == LoBID && !lob.Archived {
+			children = append(children, lob.LoBID)
+		}
+	}
+	return children
+}
+
+// Invoke Route: LoBMove
+//
+//	args[0]: {"LoB_LoBID": 4, "LoB_ParentID": 1}, without LoB_ParentID the LoB becomes a top-level LoB
+//	moves the LoB with its sub-LoBs; their credit leaves the rolled-up totals of the old ancestors
+//	and joins those of the new ones
+func (rdg *SmartContract) LoBMove(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	var request LoB
+	err := json.Unmarshal([]byte(args[0]), &request)
+	if err != nil {
+		return errorResponse(badRequest("LoBMove", err.Error()))
+	}
+
+	lob, err := activeLoB(stub, "LoBMove", request.LoBID)
+	if err != nil {
+		return errorResponse(err)
+	}
+	newAncestors, err := checkLoBParent(stub, "LoBMove", lob.LoBID, request.ParentID)
+	if err != nil {
+		return errorResponse(err)
+	}
+	oldAncestors, err := lobAncestors(stub, lob.LoBID)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	// ==== Common ancestors net out ====
+	totals := make(map[int]lobDelta)
+	for _, ancestorID := range oldAncestors {
+		totals[ancestorID] = lobDelta{RolledUp: totals[ancestorID].RolledUp - lob.RolledUpCredit}
+	}
+	for _, ancestorID := range newAncestors {
+		totals[ancestorID] = lobDelta{RolledUp: totals[ancestorID].RolledUp + lob.RolledUpCredit}
+	}
+	err = applyLoBDeltas(stub, totals)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	lob.ParentID = request.ParentID
+	err = saveLoB(stub, lob)
+	if err != nil {
+		return errorResponse(err)
+	}
+	logger.Info("LoBMove:", lob.LoBID, oldAncestors, newAncestors)
+
+	lobAsBytes, err := json.Marshal(lob)
+	if err != nil {
+		return errorResponse(errInternal("LoBMove: " + err.Error()))
+	}
+	return shim.Success(lobAsBytes)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+func (c *testChain) lobTotals() []LoBTotals {
+	c.t.Helper()
+	var lobs []LoBTotals
+	c.mustUnmarshal(c.mustInvoke("", "LoBReadAll"), &lobs)
+	return lobs
+}
+
+// lobTree - HANA > "HANA Cloud" > "HANA DB", returns the IDs of the two sub-LoBs
+func (c *testChain) lobTree() (int, int) {
+	c.t.Helper()
+	var cloud, db LoB
+	c.mustUnmarshal(c.mustInvoke(admin, "LoBCreate", `{"LoB_Name": "HANA Cloud", "LoB_ParentID": 1}`), &cloud)
+	c.mustUnmarshal(c.mustInvoke(admin, "LoBCreate", `{"LoB_Name": "HANA DB", "LoB_ParentID": `+strconv.Itoa(cloud.LoBID)+`}`), &db)
+	return cloud.LoBID, db.LoBID
+}
+
+func TestLoBTreeRollUp(t *testing.T) {
+	c := newExchain(t)
+	cloud, db := c.lobTree()
+	if lob := c.lobs()[db]; lob.ParentID == nil || *lob.ParentID != cloud {
+		t.Fatalf("unexpected LoB %+v", lob)
+	}
+	if e := c.failure(admin, "LoBCreate", `{"LoB_Name": "x", "LoB_ParentID": 42}`); e.Code != ErrNotFound || e.ID != "42" {
+		t.Errorf("unexpected error %+v", e)
+	}
+
+	// an award in HANA DB counts for HANA DB, HANA Cloud and HANA, each with its own members
+	c.register("i000005", db, false)
+	ticketID := c.createTicket(owner, 0, 10, "")
+	c.finish(ticketID, "i000005", applicant)
+	c.orderUpdate(admin, ticketID, "Award", "i000005", applicant)
+	want := map[int][4]int{
+		MD_office: {0, 0, 1, 1},
+		HANA:      {0, 5, 1, 2},
+		SMB:       {5, 5, 2, 2},
+		cloud:     {0, 5, 0, 1},
+		db:        {5, 5, 1, 1},
+	}
+	for _, lob := range c.lobTotals() {
+		expected, ok := want[lob.LoBID]
+		if !ok {
+			expected = [4]int{}
+		}
+		if got := [4]int{lob.TotalCredit, lob.RolledUpCredit, lob.Members, lob.RolledUpMembers}; got != expected {
+			t.Errorf("LoB %d: got %v, expected %v", lob.LoBID, got, expected)
+		}
+	}
+	var lob LoBReading
+	c.mustUnmarshal(c.mustInvoke("", "LoBRead", strconv.Itoa(HANA)), &lob)
+	if lob.TotalCredit != 0 || lob.RolledUpCredit != 5 || lob.Members != 1 || lob.RolledUpMembers != 2 {
+		t.Errorf("unexpected LoB %+v", lob)
+	}
+	c.mustUnmarshal(c.mustInvoke("", "LoBRead", strconv.Itoa(db)), &lob)
+	if lob.ParentID == nil || *lob.ParentID != cloud {
+		t.Errorf("LoBRead without ParentID %+v", lob)
+	}
+
+	// a parent with active sub-LoBs stays
+	e := c.failure(admin, "LoBArchive", strconv.Itoa(cloud))
+	if e.Code != ErrConflict || e.Message != "LoBArchive: LoB 8 still has 1 sub-LoBs" {
+		t.Errorf("unexpected error %+v", e)
+	}
+}
+
+func TestLoBMove(t *testing.T) {
+	c := newExchain(t)
+	cloud, db := c.lobTree()
+	c.register("i000005", db, false)
+	c.mustInvoke(admin, "CreditAdd", `{"userID": "i000005", "value": 10, "ticketID": "creditADD"}`)
+
+	c.mustFail("Forbidden", owner, "LoBMove", `{"LoB_LoBID": 9, "LoB_ParentID": 2}`)
+	c.mustFail("LoB 1 can not be placed under its own sub-LoB 9", admin, "LoBMove", `{"LoB_LoBID": 1, "LoB_ParentID": 9}`)
+	c.mustFail("LoB 9 can not be placed under its own sub-LoB 9", admin, "LoBMove", `{"LoB_LoBID": 9, "LoB_ParentID": 9}`)
+
+	// the credit of HANA DB leaves HANA Cloud and HANA and joins SMB
+	var lob LoB
+	c.mustUnmarshal(c.mustInvoke(admin, "LoBMove", `{"LoB_LoBID": 9, "LoB_ParentID": 2}`), &lob)
+	if lob.ParentID == nil || *lob.ParentID != SMB {
+		t.Errorf("unexpected LoB %+v", lob)
+	}
+	lobs := c.lobs()
+	if lobs[HANA].RolledUpCredit != 0 || lobs[cloud].RolledUpCredit != 0 || lobs[SMB].RolledUpCredit != 10 || lobs[db].RolledUpCredit != 10 {
+		t.Errorf("unexpected LoBs %+v", lobs)
+	}
+
+	// within the same tree the common ancestors keep their totals
+	c.mustInvoke(admin, "LoBMove", `{"LoB_LoBID": 8, "LoB_ParentID": 2}`)
+	c.mustInvoke(admin, "LoBMove", `{"LoB_LoBID": 9, "LoB_ParentID": 8}`)
+	lobs = c.lobs()
+	if lobs[SMB].RolledUpCredit != 10 || lobs[cloud].RolledUpCredit != 10 || lobs[HANA].RolledUpCredit != 0 {
+		t.Errorf("unexpected LoBs %+v", lobs)
+	}
+
+	// back to the top level
+	lob = LoB{}
+	c.mustUnmarshal(c.mustInvoke(admin, "LoBMove", `{"LoB_LoBID": 8}`), &lob)
+	lobs = c.lobs()
+	if lob.ParentID != nil || lobs[SMB].RolledUpCredit != 0 || lobs[cloud].RolledUpCredit != 10 {
+		t.Errorf("unexpected LoBs %+v", lobs)
+	}
+	c.mustInvoke(admin, "LoBArchive", strconv.Itoa(IoT))
+	if e := c.failure(admin, "LoBMove", `{"LoB_LoBID": 8, "LoB_ParentID": 7}`); e.Code != ErrConflict || e.ID != "7" {
+		t.Errorf("unexpected error %+v", e)
+	}
+}
+
+func TestInitRollsUpLoBs(t *testing.T) {
+	c := newExchain(t)
+	c.mustInvoke(admin, "CreditAdd", `{"userID": "`+applicant+`", "value": 12, "ticketID": "creditADD"}`)
+
+	// SMB placed under HANA by a record written before the totals were rolled up
+	response := c.run("", func(stub *testStub) peer.Response {
+		lob, err := retrieveLoB(stub, SMB)
+		if err != nil {
+			return errorResponse(err)
+		}
+		parentID := HANA
+		lob.ParentID, lob.RolledUpCredit = &parentID, 0
+		if err = saveLoB(stub, lob); err != nil {
+			return errorResponse(err)
+		}
+		return shim.Success(nil)
+	})
+	if response.Status != shim.OK {
+		t.Fatal(response.Message)
+	}
+
+	response = c.run("", func(stub *testStub) peer.Response { return c.cc.Init(stub) })
+	if response.Status != shim.OK {
+		t.Fatalf("Init failed: %s", response.Message)
+	}
+	lobs := c.lobs()
+	if lobs[SMB].RolledUpCredit != 12 || lobs[HANA].RolledUpCredit != 12 || lobs[HANA].TotalCredit != 0 {
+		t.Errorf("unexpected LoBs %+v", lobs)
+	}
+	bytes, _ := json.Marshal(c.lobTotals()[HANA])
+	if !strings.Contains(string(bytes), `"LoB_RolledUpCredit":12,`) || !strings.Contains(string(bytes), `"LoB_Members":1,"LoB_RolledUpMembers":3`) {
+		t.Errorf("unexpected LoB %s", bytes)
+	}
+}
@@ -0,0 +1,461 @@
+package main
+
+import (
+	"container/list"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+	"github.com/hyperledger/fabric/protos/msp"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// testMSPID - MSP of every client identity in the tests
+const testMSPID = "Org1MSP"
+
+// attrOID - certificate extension the Fabric CA stores enrollment attributes in
+var attrOID = asn1.ObjectIdentifier{1, 2, 3, 4, 5, 6, 7, 8, 1}
+
+var testKey *ecdsa.PrivateKey
+
+// testStub - MockStub with a client identity and arguments of its own,
+// MockStub.MockInvoke passes itself to the chaincode and has no creator
+type testStub struct {
+	*shim.MockStub
+	args    [][]byte
+	creator []byte
+	// query stands in for CouchDB, MockStub has no rich queries
+	query func(query string, pageSize int32, bookmark string) ([]*queryresult.KV, string, error)
+	// history stands in for the history database, MockStub has none
+	history func(key string) ([]*queryresult.KeyModification, error)
+	// committed is the state before the running transaction; like a peer, reads do not see the
+	// transaction's own writes, which MockStub does
+	committed map[string][]byte
+	// committedPvt is committed for the private data collections
+	committedPvt map[string]map[string][]byte
+	// transient is the transient map of the running transaction, MockStub has none
+	transient map[string][]byte
+}
+
+func (s *testStub) GetState(key string) ([]byte, error) {
+	if s.committed == nil {
+		return s.MockStub.GetState(key)
+	}
+	return s.committed[key], nil
+}
+
+func (s *testStub) GetPrivateData(collection string, key string) ([]byte, error) {
+	if s.committedPvt == nil {
+		return s.MockStub.GetPrivateData(collection, key)
+	}
+	return s.committedPvt[collection][key], nil
+}
+
+// DelPrivateData - MockStub does not implement it
+func (s *testStub) DelPrivateData(collection string, key string) error {
+	delete(s.PvtState[collection], key)
+	return nil
+}
+
+func (s *testStub) GetTransient() (map[string][]byte, error) {
+	return s.transient, nil
+}
+
+// committedRange - committed records with startKey <= key < endKey by key, an empty endKey has no end
+func (s *testStub) committedRange(startKey string, endKey string, match func(key string) bool) []*queryresult.KV {
+	var keys []string
+	for key := range s.committed {
+		if key >= startKey && (endKey == "" || key < endKey) && match(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	records := []*queryresult.KV{}
+	for _, key := range keys {
+		records = append(records, &queryresult.KV{Key: key, Value: s.committed[key]})
+	}
+	return records
+}
+
+// page - pageSize records from the bookmark on, and the key of the next record as bookmark, "" after the last page
+func page(records []*queryresult.KV, pageSize int32, bookmark string) ([]*queryresult.KV, string) {
+	for len(records) > 0 && records[0].Key < bookmark {
+		records = records[1:]
+	}
+	if pageSize <= 0 || int(pageSize) >= len(records) {
+		return records, ""
+	}
+	return records[:pageSize], records[pageSize].Key
+}
+
+func (s *testStub) GetStateByRange(startKey string, endKey string) (shim.StateQueryIteratorInterface, error) {
+	if s.committed == nil {
+		return s.MockStub.GetStateByRange(startKey, endKey)
+	}
+	return &kvIterator{records: s.committedRange(startKey, endKey, func(string) bool { return true })}, nil
+}
+
+func (s *testStub) partialCompositeKey(objectType string, attributes []string) ([]*queryresult.KV, error) {
+	prefix, err := s.CreateCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, err
+	}
+	return s.committedRange(prefix, "", func(key string) bool { return strings.HasPrefix(key, prefix) }), nil
+}
+
+func (s *testStub) GetStateByPartialCompositeKey(objectType string, attributes []string) (shim.StateQueryIteratorInterface, error) {
+	if s.committed == nil {
+		return s.MockStub.GetStateByPartialCompositeKey(objectType, attributes)
+	}
+	records, err := s.partialCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, err
+	}
+	return &kvIterator{records: records}, nil
+}
+
+func (s *testStub) GetStateByPartialCompositeKeyWithPagination(objectType string, attributes []string, pageSize int32,
+	bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	records, err := s.partialCompositeKey(objectType, attributes)
+	if err != nil {
+		return nil, nil, err
+	}
+	records, next := page(records, pageSize, bookmark)
+	return &kvIterator{records: records}, &peer.QueryResponseMetadata{FetchedRecordsCount: int32(len(records)), Bookmark: next}, nil
+}
+
+func (s *testStub) GetArgs() [][]byte {
+	return s.args
+}
+
+func (s *testStub) GetStringArgs() []string {
+	var args []string
+	for _, arg := range s.args {
+		args = append(args, string(arg))
+	}
+	return args
+}
+
+func (s *testStub) GetFunctionAndParameters() (string, []string) {
+	args := s.GetStringArgs()
+	if len(args) == 0 {
+		return "", []string{}
+	}
+	return args[0], args[1:]
+}
+
+func (s *testStub) GetCreator() ([]byte, error) {
+	return s.creator, nil
+}
+
+func (s *testStub) GetQueryResultWithPagination(query string, pageSize int32, bookmark string) (shim.StateQueryIteratorInterface, *peer.QueryResponseMetadata, error) {
+	if s.query == nil {
+		return s.MockStub.GetQueryResultWithPagination(query, pageSize, bookmark)
+	}
+	records, next, err := s.query(query, pageSize, bookmark)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &kvIterator{records: records}, &peer.QueryResponseMetadata{FetchedRecordsCount: int32(len(records)), Bookmark: next}, nil
+}
+
+func (s *testStub) GetHistoryForKey(key string) (shim.HistoryQueryIteratorInterface, error) {
+	if s.history == nil {
+		return s.MockStub.GetHistoryForKey(key)
+	}
+	modifications, err := s.history(key)
+	if err != nil {
+		return nil, err
+	}
+	return &historyIterator{modifications: modifications}, nil
+}
+
+// historyIterator - history iterator over fixed versions
+type historyIterator struct {
+	modifications []*queryresult.KeyModification
+}
+
+func (it *historyIterator) HasNext() bool {
+	return len(it.modifications) > 0
+}
+
+func (it *historyIterator) Next() (*queryresult.KeyModification, error) {
+	modification := it.modifications[0]
+	it.modifications = it.modifications[1:]
+	return modification, nil
+}
+
+func (it *historyIterator) Close() error {
+	return nil
+}
+
+// kvIterator - state iterator over fixed records
+type kvIterator struct {
+	records []*queryresult.KV
+}
+
+func (it *kvIterator) HasNext() bool {
+	return len(it.records) > 0
+}
+
+func (it *kvIterator) Next() (*queryresult.KV, error) {
+	record := it.records[0]
+	it.records = it.records[1:]
+	return record, nil
+}
+
+func (it *kvIterator) Close() error {
+	return nil
+}
+
+// testChain - chaincode under test on an initialized MockStub
+type testChain struct {
+	t    *testing.T
+	cc   *SmartContract
+	stub *testStub
+	txN  int
+	// clock is the timestamp of the next transactions, the wall clock if zero
+	clock time.Time
+	// transient is the transient map of the next transaction only, see secret
+	transient map[string][]byte
+}
+
+func newTestChain(t *testing.T) *testChain {
+	cc := new(SmartContract)
+	c := &testChain{t: t, cc: cc, stub: &testStub{MockStub: shim.NewMockStub("exchain", cc)}}
+	response := c.run("", func(stub *testStub) peer.Response { return cc.Init(stub) })
+	if response.Status != shim.OK {
+		t.Fatalf("Init failed: %s", response.Message)
+	}
+	return c
+}
+
+func (c *testChain) nextTxID() string {
+	c.txN++
+	return "tx" + strconv.Itoa(c.txN)
+}
+
+// run fn in a transaction submitted by userID, no identity if userID is empty;
+// like a peer, the writes of a failed transaction are discarded
+func (c *testChain) run(userID string, fn func(stub *testStub) peer.Response) peer.Response {
+	c.stub.creator = nil
+	if userID != "" {
+		c.stub.creator = clientIdentity(c.t, testMSPID, userID)
+	}
+	c.stub.transient, c.transient = c.transient, nil
+	txID := c.nextTxID()
+	c.stub.MockTransactionStart(txID)
+	if !c.clock.IsZero() {
+		c.stub.TxTimestamp = &timestamp.Timestamp{Seconds: c.clock.Unix(), Nanos: int32(c.clock.Nanosecond())}
+	}
+	defer c.stub.MockTransactionEnd(txID)
+
+	state := make(map[string][]byte)
+	for key, value := range c.stub.State {
+		state[key] = value
+	}
+	keys := list.New()
+	keys.PushBackList(c.stub.Keys)
+	pvtState := make(map[string]map[string][]byte)
+	for collection, records := range c.stub.PvtState {
+		pvtState[collection] = make(map[string][]byte)
+		for key, value := range records {
+			pvtState[collection][key] = value
+		}
+	}
+	c.stub.committed, c.stub.committedPvt = state, pvtState
+	defer func() { c.stub.committed, c.stub.committedPvt = nil, nil }()
+	response := fn(c.stub)
+	if response.Status != shim.OK {
+		c.stub.State, c.stub.Keys, c.stub.PvtState = state, keys, pvtState
+	}
+	return response
+}
+
+func (c *testChain) invoke(userID string, function string, args ...string) peer.Response {
+	c.stub.args = [][]byte{[]byte(function)}
+	for _, arg := range args {
+		c.stub.args = append(c.stub.args, []byte(arg))
+	}
+	return c.run(userID, func(stub *testStub) peer.Response { return c.cc.Invoke(stub) })
+}
+
+// lastTxID - TicketCreate uses the tx ID as TicketID
+func (c *testChain) lastTxID() string {
+	return "tx" + strconv.Itoa(c.txN)
+}
+
+func (c *testChain) mustInvoke(userID string, function string, args ...string) []byte {
+	c.t.Helper()
+	response := c.invoke(userID, function, args...)
+	if response.Status != shim.OK {
+		c.t.Fatalf("%s by %q failed: %s", function, userID, response.Message)
+	}
+	return response.Payload
+}
+
+// failure - the ChaincodeError of a failed invocation
+func (c *testChain) failure(userID string, function string, args ...string) ChaincodeError {
+	c.t.Helper()
+	response := c.invoke(userID, function, args...)
+	if response.Status == shim.OK {
+		c.t.Fatalf("%s by %q succeeded, expected an error", function, userID)
+	}
+	return chaincodeError(c.t, response)
+}
+
+func (c *testChain) mustFail(contains string, userID string, function string, args ...string) {
+	c.t.Helper()
+	e := c.failure(userID, function, args...)
+	if !strings.Contains(e.Message, contains) {
+		c.t.Fatalf("%s by %q failed with %q, expected an error containing %q", function, userID, e.Message, contains)
+	}
+}
+
+// chaincodeError decodes the Message of a failed response, which must agree with its status
+func chaincodeError(t *testing.T, response peer.Response) ChaincodeError {
+	t.Helper()
+	var e ChaincodeError
+	err := json.Unmarshal([]byte(response.Message), &e)
+	if err != nil {
+		t.Fatalf("error message is not a ChaincodeError: %q", response.Message)
+	}
+	if e.Status != response.Status || errorStatuses[e.Code] != e.Status || e.Message == "" {
+		t.Fatalf("inconsistent error: status %d, %q", response.Status, response.Message)
+	}
+	return e
+}
+
+func (c *testChain) mustUnmarshal(payload []byte, v interface{}) {
+	c.t.Helper()
+	err := json.Unmarshal(payload, v)
+	if err != nil {
+		c.t.Fatalf("invalid JSON %q: %s", payload, err)
+	}
+}
+
+// secret sets the transient map of the next transaction, fields are name, value pairs
+func (c *testChain) secret(fields ...string) *testChain {
+	c.transient = make(map[string][]byte)
+	for i := 0; i+1 < len(fields); i += 2 {
+		c.transient[fields[i]] = []byte(fields[i+1])
+	}
+	return c
+}
+
+// passwordSecret - the transient map addParticipant takes for the password pw-UserID
+func (c *testChain) passwordSecret(userID string) *testChain {
+	return c.secret("Password", "pw-"+userID, "Salt", "random salt of "+userID)
+}
+
+func (c *testChain) register(userID string, LoBID int, isAdmin bool) {
+	c.t.Helper()
+	c.passwordSecret(userID).mustInvoke(userID, "addParticipant", participantJSON(userID, LoBID, isAdmin))
+}
+
+func (c *testChain) credit(userID string) int {
+	c.t.Helper()
+	var credit Credit
+	c.mustUnmarshal(c.mustInvoke("", "CreditRead", userID), &credit)
+	return credit.Value
+}
+
+func (c *testChain) lobTotal(LoBID int) int {
+	c.t.Helper()
+	var lobs []LoB
+	c.mustUnmarshal(c.mustInvoke("", "LoBReadAll"), &lobs)
+	return lobs[LoBID].TotalCredit
+}
+
+func (c *testChain) ticket(ticketID string) Ticket {
+	c.t.Helper()
+	var ticket Ticket
+	c.mustUnmarshal(c.mustInvoke("", "TicketRead", ticketID), &ticket)
+	return ticket
+}
+
+func (c *testChain) order(ticketID string, userID string) Order {
+	c.t.Helper()
+	var order Order
+	c.mustUnmarshal(c.mustInvoke("", "OrderRead", ticketID, userID), &order)
+	return order
+}
+
+// events set since the last call
+func (c *testChain) events() []string {
+	var names []string
+	for {
+		select {
+		case event := <-c.stub.ChaincodeEventsChannel:
+			names = append(names, event.EventName)
+		default:
+			return names
+		}
+	}
+}
+
+func participantJSON(userID string, LoBID int, isAdmin bool) string {
+	bytes, _ := json.Marshal(Participant{UserID: userID, UserName: "User " + userID, IsAdmin: isAdmin, LoBID: LoBID})
+	return string(bytes)
+}
+
+func ticketJSON(userID string, value int) string {
+	bytes, _ := json.Marshal(map[string]interface{}{"Ticket_Title": "Review the release notes", "Ticket_Type": 0, "Ticket_Value": value,
+		"Ticket_UserID": userID, "Ticket_Comment": "test", "Ticket_Policy": "policy"})
+	return string(bytes)
+}
+
+// ticketUpdateJSON - ticket as TicketUpdate takes it, without the status
+func ticketUpdateJSON(ticket Ticket) string {
+	var fields map[string]interface{}
+	bytes, _ := json.Marshal(ticket)
+	json.Unmarshal(bytes, &fields)
+	delete(fields, "Ticket_Status")
+	bytes, _ = json.Marshal(fields)
+	return string(bytes)
+}
+
+// clientIdentity - serialized identity with a certificate carrying the exchain.userID attribute
+func clientIdentity(t *testing.T, mspID string, userID string) []byte {
+	var err error
+	if testKey == nil {
+		testKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	attrs, _ := json.Marshal(map[string]interface{}{"attrs": map[string]string{UserIDAttribute: userID}})
+	template := &x509.Certificate{
+		SerialNumber:    big.NewInt(1),
+		Subject:         pkix.Name{CommonName: userID},
+		NotBefore:       time.Now().Add(-time.Hour),
+		NotAfter:        time.Now().Add(time.Hour),
+		ExtraExtensions: []pkix.Extension{{Id: attrOID, Value: attrs}},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &testKey.PublicKey, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	creator, err := proto.Marshal(&msp.SerializedIdentity{
+		Mspid:   mspID,
+		IdBytes: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return creator
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Order status
+//
+//	Applied  ->  Confirmed  ->  Done  ->  Awarded
+//	   |             |            |
+//	   |-> Rejected  |-> Withdrawn|-> Closed
+//	Waitlisted  ->  Applied, once a seat of a full ticket is free again (capacity.go)
+const (
+	OrderApplied = iota + 1
+	OrderConfirmed
+	OrderDone
+	OrderAwarded
+	OrderClosed
+	OrderRejected
+	OrderWithdrawn
+	OrderWaitlisted
+)
+
+// orderStatusInfo information
+//
+//	Name:           status name used in OrderUpdate responses
+//	TicketStatus:   ticket status an order in this status lifts its ticket to, -1 if it does not count
+//	Seat:           the order takes one of the Ticket_Capacity seats, applications do not until they are confirmed
+type orderStatusInfo struct {
+	Name         string
+	TicketStatus int
+	Seat         bool
+}
+
+var orderStatuses = map[int]orderStatusInfo{
+	OrderApplied:    {"Applied", Applied, false},
+	OrderConfirmed:  {"Confirmed", Ongoing, true},
+	OrderDone:       {"Done", Done, true},
+	OrderAwarded:    {"Awarded", Awarded, true},
+	OrderClosed:     {"Closed", -1, false},
+	OrderRejected:   {"Rejected", -1, false},
+	OrderWithdrawn:  {"Withdrawn", -1, false},
+	OrderWaitlisted: {"Waitlisted", Applied, false},
+}
+
+// orderTransition information
+//
+//	Action:   field of the OrderUpdate request listing the UserIDs to move
+//	From:     statuses the order may be in
+//	To:       status the order moves to
+//	Role:     who may trigger it: AccessTicketOwner, AccessAdmin or AccessSelf (the applicant); admins always may
+type orderTransition struct {
+	Action string
+	From   []int
+	To     int
+	Role   int
+}
+
+// orderTransitions is the order lifecycle, applied in this order by OrderUpdate
+var orderTransitions = []orderTransition{
+	{Action: "Confirm", From: []int{OrderApplied}, To: OrderConfirmed, Role: AccessTicketOwner},
+	{Action: "Reject", From: []int{OrderApplied, OrderWaitlisted}, To: OrderRejected, Role: AccessTicketOwner},
+	{Action: "Done", From: []int{OrderConfirmed}, To: OrderDone, Role: AccessTicketOwner},
+	{Action: "Award", From: []int{OrderDone}, To: OrderAwarded, Role: AccessAdmin},
+	{Action: "Withdraw", From: []int{OrderApplied, OrderWaitlisted, OrderConfirmed}, To: OrderWithdrawn, Role: AccessSelf},
+	{Action: "Close", From: []int{OrderApplied, OrderWaitlisted, OrderConfirmed, OrderDone}, To: OrderClosed, Role: AccessTicketOwner},
+}
+
+// OrderTransitionResult information, one per UserID of an OrderUpdate request
+//
+//	Code, Error:   error code (errors.go) and message if the order was not moved
+type OrderTransitionResult struct {
+	UserID string `json:"UserID"`
+	Action string `json:"Action"`
+	From   string `json:"From,omitempty"`
+	To     string `json:"To,omitempty"`
+	Code   string `json:"Code,omitempty"`
+	Error  string `json:"Error,omitempty"`
+}
+
+func orderStatusName(status int) string {
+	info, ok := orderStatuses[status]
+	if !ok {
+		return "Unknown(" + strconv.Itoa(status) + ")"
+	}
+	return info.Name
+}
+
+// Helper: whether an order in status is still open, i.e. the Close transition accepts it
+func orderClosable(status int) bool {
+	for _, t := range orderTransitions {
+		if t.To != OrderClosed {
+			continue
+		}
+		for _, from := range t.From {
+			if status == from {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Helper: whether caller may trigger the transition on the order of ticket
+func (t orderTransition) allowed(caller Caller, ticket Ticket, order Order) bool {
+	if caller.Participant.IsAdmin {
+		return true
+	}
+	switch t.Role {
+	case AccessTicketOwner:
+		return ticket.UserID == caller.UserID
+	case AccessSelf:
+		return order.UserID == caller.UserID
+	}
+	return false
+}
+
+// Helper: move order along transition t, or explain why it can not
+func (t orderTransition) apply(caller Caller, ticket Ticket, order Order) (Order, *ChaincodeError) {
+	id := orderID(order.TicketID, order.UserID)
+	if !t.allowed(caller, ticket, order) {
+		return order, newError(ErrForbidden, "Forbidden: "+caller.UserID+" can not "+t.Action+" this order").on(OrderObjectType, id)
+	}
+	for _, from := range t.From {
+		if order.Status == from {
+			order.Status = t.To
+			return order, nil
+		}
+	}
+	return order, newError(ErrInvalidTransition, "Invalid transition: "+t.Action+" is not allowed from "+orderStatusName(order.Status)).
+		on(OrderObjectType, id)
+}
+
+// Helper: read a single order, nil if it does not exist
+func retrieveOrder(stub shim.ChaincodeStubInterface, ticketID string, userID string) (*Order, error) {
+	var order Order
+	key, err := stub.CreateCompositeKey(OrderObjectType, []string{ticketID, userID})
+	if err != nil {
+		return nil, err
+	}
+	orderAsBytes, err := stub.GetState(key)
+	if err != nil {
+		return nil, errors.New("retrieveOrder: Error getting order " + ticketID + "/" + userID)
+	}
+	if orderAsBytes == nil {
+		return nil, nil
+	}
+	err = json.Unmarshal(orderAsBytes, &order)
+	if err != nil {
+		return nil, errors.New("retrieveOrder: Corrupt order " + ticketID + "/" + userID)
+	}
+	return &order, nil
+}
+
+// Helper: every order of ticket, in key order followed by the ones first written in this transaction
+// pending holds orders written earlier in this transaction, which GetState does not return yet
+func ticketOrders(stub shim.ChaincodeStubInterface, ticketID string, pending map[string]Order) ([]Order, error) {
+	var orders []Order
+	orderIterator, err := stub.GetStateByPartialCompositeKey(OrderObjectType, []string{ticketID})
+	if err != nil {
+		return nil, errors.New("ticketOrders: " + err.Error())
+	}
+	defer orderIterator.Close()
+
+	seen := make(map[string]bool)
+	for orderIterator.HasNext() {
+		queryResponse, err := orderIterator.Next()
+		if err != nil {
+			return nil, errors.New("ticketOrders: " + err.Error())
+		}
+		var order Order
+		if json.Unmarshal(queryResponse.Value, &order) != nil {
+			continue
+		}
+		if updated, ok := pending[order.UserID]; ok {
+			order = updated
+		}
+		seen[order.UserID] = true
+		orders = append(orders, order)
+	}
+	var added []string
+	for userID := range pending {
+		if !seen[userID] {
+			added = append(added, userID)
+		}
+	}
+	sort.Strings(added)
+	for _, userID := range added {
+		orders = append(orders, pending[userID])
+	}
+	return orders, nil
+}
+
+// Helper: one-time rewrite of orders closed before the transition table, which stored Close as status 0.
+// It is idempotent: no status maps to 0 any more.
+func migrateOrders(stub shim.ChaincodeStubInterface) (int, error) {
+	var closed []Order
+	orderIterator, err := stub.GetStateByPartialCompositeKey(OrderObjectType, []string{})
+	if err != nil {
+		return 0, errors.New("migrateOrders: " + err.Error())
+	}
+	for orderIterator.HasNext() {
+		queryResponse, err := orderIterator.Next()
+		if err != nil {
+			orderIterator.Close()
+			return 0, errors.New("migrateOrders: " + err.Error())
+		}
+		var order Order
+		if json.Unmarshal(queryResponse.Value, &order) == nil && order.Status == 0 {
+			closed = append(closed, order)
+		}
+	}
+	orderIterator.Close()
+
+	for _, order := range closed {
+		order.Status = OrderClosed
+		_, err = OrderSaving(stub, order)
+		if err != nil {
+			return 0, errors.New("migrateOrders: Error storing order " + order.TicketID + "/" + order.UserID)
+		}
+	}
+	return len(closed), nil
+}
+
+// Helper: ticket status derived from its orders through orderStatuses
+func ticketStatusFromOrders(stub shim.ChaincodeStubInterface, ticketID string, pending map[string]Order) (int, error) {
+	status := Applied
+	orders, err := ticketOrders(stub, ticketID, pending)
+	if err != nil {
+		return status, errors.New("ticketStatusFromOrders: " + err.Error())
+	}
+	for _, order := range orders {
+		info, ok := orderStatuses[order.Status]
+		if ok && info.TicketStatus > status {
+			status = info.TicketStatus
+		}
+	}
+	return status, nil
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// Modes of deleteParticipant
+//
+//	Soft:   the participant is deactivated, its records stay for history and its credit stays with its LoB
+//	Hard:   the participant, its password and its credit are removed, the journal stays
+const (
+	DeleteSoft = "Soft"
+	DeleteHard = "Hard"
+)
+
+// ParticipantDeleteReport information, response of deleteParticipant
+//
+//	ExpiredTickets:    open tickets of the participant moved to Expired
+//	ClosedOrders:      "TicketID/UserID" of the open orders on them
+//	WithdrawnOrders:   "TicketID/UserID" of the participant's own open orders
+//	PromotedOrders:    "TicketID/UserID" of waitlisted orders that took the freed seats
+//	Refunded:          escrow credit of the participant's tickets handed back to the funders
+//	Credit:            balance removed (Hard) or left with the LoB (Soft)
+type ParticipantDeleteReport struct {
+	UserID          string   `json:"UserID"`
+	Mode            string   `json:"Mode"`
+	ExpiredTickets  []string `json:"ExpiredTickets"`
+	ClosedOrders    []string `json:"ClosedOrders"`
+	WithdrawnOrders []string `json:"WithdrawnOrders"`
+	PromotedOrders  []string `json:"PromotedOrders"`
+	Refunded        int      `json:"Refunded"`
+	Credit          int      `json:"Credit"`
+}
+
+// Helper: tickets created by userID that are not expired yet
+func ownedTickets(stub shim.ChaincodeStubInterface, userID string) ([]Ticket, error) {
+	var tickets []Ticket
+	iterator, err := stub.GetStateByPartialCompositeKey(TicketObjectType, []string{})
+	if err != nil {
+		return nil, errInternal("ownedTickets: " + err.Error())
+	}
+	defer iterator.Close()
+
+	for iterator.HasNext() {
+		queryResponse, err := iterator.Next()
+		if err != nil {
+			return nil, errInternal("ownedTickets: " + err.Error())
+		}
+		var ticket Ticket
+		err = json.Unmarshal(queryResponse.Value, &ticket)
+		if err != nil {
+			return nil, errInternal("ownedTickets: Corrupt ticket " + queryResponse.Key)
+		}
+		if ticket.UserID == userID && ticket.Status != Expired {
+			tickets = append(tickets, ticket)
+		}
+	}
+	return tickets, nil
+}
+
+// Helper: open orders of userID on tickets of others, an order is open while the Close transition accepts it
+func openOrders(stub shim.ChaincodeStubInterface, userID string, skip map[string]bool) ([]Order, error) {
+	var orders []Order
+	iterator, err := stub.GetStateByPartialCompositeKey(OrderObjectType, []string{})
+	if err != nil {
+		return nil, errInternal("openOrders: " + err.Error())
+	}
+	defer iterator.Close()
+
+	for iterator.HasNext() {
+		queryResponse, err := iterator.Next()
+		if err != nil {
+			return nil, errInternal("openOrders: " + err.Error())
+		}
+		var order Order
+		if json.Unmarshal(queryResponse.Value, &order) != nil {
+			continue
+		}
+		if order.UserID == userID && !skip[order.TicketID] && orderClosable(order.Status) {
+			orders = append(orders, order)
+		}
+	}
+	return orders, nil
+}
+
+// Helper: withdraw the open orders of userID, a Done order waiting for its award is closed instead,
+// then hand the freed seats to the waitlists and update the ticket status
+func withdrawOrders(stub shim.ChaincodeStubInterface, userID string, skip map[string]bool, report *ParticipantDeleteReport) error {
+	orders, err := openOrders(stub, userID, skip)
+	if err != nil {
+		return err
+	}
+	for _, order := range orders {
+		oldStatus := order.Status
+		order.Status = OrderWithdrawn
+		if oldStatus == OrderDone {
+			order.Status = OrderClosed
+		}
+		order.Position = 0
+		_, err = OrderSaving(stub, order)
+		if err != nil {
+			return err
+		}
+		err = emitEvent(stub, ExchainEvent{Name: EventOrderUpdated, TicketID: order.TicketID, UserID: userID,
+			OldStatus: intPtr(oldStatus), NewStatus: intPtr(order.Status)})
+		if err != nil {
+			return err
+		}
+		report.WithdrawnOrders = append(report.WithdrawnOrders, orderID(order.TicketID, userID))
+
+		// ==== Orders of a missing ticket are left to Reconcile, there is no ticket to update then ====
+		ticketAsBytes, err := stub.GetState(ticketKey(stub, order.TicketID))
+		if err != nil {
+			return errInternal("withdrawOrders: Error getting ticket " + order.TicketID)
+		}
+		if ticketAsBytes == nil {
+			continue
+		}
+		var ticket Ticket
+		err = json.Unmarshal(ticketAsBytes, &ticket)
+		if err != nil {
+			return errInternal("withdrawOrders: Corrupt ticket " + order.TicketID)
+		}
+		pending := map[string]Order{userID: order}
+		if ticket.Status != Expired {
+			promoted, err := promoteWaitlist(stub, ticket, pending)
+			if err != nil {
+				return err
+			}
+			for _, result := range promoted {
+				report.PromotedOrders = append(report.PromotedOrders, orderID(order.TicketID, result.UserID))
+			}
+		}
+		_, err = updateTicketStatus(stub, ticket, pending)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Invoke Route: deleteParticipant
+//
+//	args[0]: UserID
+//	args[1]: Mode, Soft or Hard, Hard if left out
+//	in one transaction: the participant's open tickets expire with their orders and escrows refunded, its own open
+//	orders are withdrawn, it leaves the LoB_UserIDs of its LoB and the participant itself is deactivated or removed
+func (rdg *SmartContract) deleteParticipant(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	userID := args[0]
+	mode := DeleteHard
+	if len(args) > 1 && args[1] != "" {
+		mode = args[1]
+	}
+	if mode != DeleteSoft && mode != DeleteHard {
+		return errorResponse(badRequest("deleteParticipant", "Mode must be Soft or Hard"))
+	}
+
+	var participant Participant
+	participantAsBytes, err := rdg.retrieveParticipant(stub, userID)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = json.Unmarshal(participantAsBytes, &participant)
+	if err != nil {
+		return errorResponse(errInternal("deleteParticipant: Corrupt participant " + userID))
+	}
+	if mode == DeleteSoft && participant.Deactivated {
+		return errorResponse(newError(ErrConflict, "deleteParticipant: "+userID+" is already deactivated").
+			on(ParticipantObjectType, userID))
+	}
+	report := ParticipantDeleteReport{UserID: userID, Mode: mode, ExpiredTickets: []string{}, ClosedOrders: []string{},
+		WithdrawnOrders: []string{}, PromotedOrders: []string{}}
+
+	// ==== Tickets of the participant: expire the open ones, refund what is left of every escrow ====
+	tickets, err := ownedTickets(stub, userID)
+	if err != nil {
+		return errorResponse(err)
+	}
+	owned := make(map[string]bool)
+	var movements []creditMovement
+	for _, ticket := range tickets {
+		owned[ticket.TicketID] = true
+		if ticket.Status < Awarded {
+			closed, err := expireTicket(stub, ticket)
+			if err != nil {
+				return errorResponse(err)
+			}
+			report.ExpiredTickets = append(report.ExpiredTickets, ticket.TicketID)
+			report.ClosedOrders = append(report.ClosedOrders, closed...)
+		}
+		refunds, err := refundEscrow(stub, ticket.TicketID)
+		if err != nil {
+			return errorResponse(err)
+		}
+		movements = append(movements, refunds...)
+	}
+	refunded := 0
+	for _, movement := range movements {
+		report.Refunded += movement.Delta
+		if !movement.Budget && movement.UserID == userID {
+			refunded += movement.Delta
+		}
+	}
+
+	// ==== Orders of the participant on tickets of others ====
+	err = withdrawOrders(stub, userID, owned, &report)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	// ==== Credit and LoB, written with the refunds in one applyCreditMovementsWith ====
+	credit, err := retrieveSingleCredit(stub, userID)
+	hasCredit := err == nil
+	if e, ok := err.(*ChaincodeError); err != nil && !(ok && e.Code == ErrNotFound) {
+		return errorResponse(err)
+	}
+	report.Credit = credit.Value + refunded
+	totals := map[int]lobDelta{participant.LoBID: {Leave: userID}}
+	if mode == DeleteSoft && hasCredit {
+		// the LoB keeps the balance as retained credit, like ParticipantChangeLoB with Keep
+		lob := totals[participant.LoBID]
+		lob.Retained += report.Credit - participant.CreditBase
+		totals[participant.LoBID] = lob
+		participant.CreditBase = report.Credit
+	} else if mode == DeleteHard && hasCredit {
+		if report.Credit != 0 {
+			movements = append(movements, creditMovement{UserID: userID, Delta: -report.Credit, Reason: JournalDelete})
+		}
+		// the debit counts against the LoB in full, CreditBase was never counted there
+		err = addLoBCredit(stub, totals, participant.LoBID, participant.CreditBase)
+		if err != nil {
+			return errorResponse(err)
+		}
+	}
+	_, err = applyCreditMovementsWith(stub, movements, totals)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	// ==== The participant itself ====
+	if mode == DeleteSoft {
+		participant.Deactivated = true
+		_, err = rdg.saveParticipant(stub, participant)
+		if err != nil {
+			return errorResponse(err)
+		}
+	} else {
+		credentialKey, err := passwordKey(stub, userID)
+		if err != nil {
+			return errorResponse(errInternal("deleteParticipant: " + err.Error()))
+		}
+		for _, key := range []string{participantKey(stub, userID), creditKey(stub, userID)} {
+			err = stub.DelState(key)
+			if err != nil {
+				return errorResponse(errInternal("deleteParticipant: " + err.Error()))
+			}
+		}
+		err = stub.DelPrivateData(CredentialCollection, credentialKey)
+		if err != nil {
+			return errorResponse(errInternal("deleteParticipant: " + err.Error()))
+		}
+		_, err = rdg.deleteReadingIDIndex(stub, userID)
+		if err != nil {
+			return errorResponse(err)
+		}
+	}
+	logger.Info("deleteParticipant:", userID, mode, report.ExpiredTickets, report.WithdrawnOrders)
+
+	err = emitEvent(stub, ExchainEvent{Name: EventParticipantDeleted, UserID: userID, Delta: report.Credit})
+	if err != nil {
+		return errorResponse(err)
+	}
+	reportAsBytes, err := json.Marshal(report)
+	if err != nil {
+		return errorResponse(errInternal("deleteParticipant: " + err.Error()))
+	}
+	return shim.Success(reportAsBytes)
+}
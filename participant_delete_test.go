@@ -0,0 +1,148 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func (c *testChain) deleteParticipant(userID string, mode string) ParticipantDeleteReport {
+	c.t.Helper()
+	var report ParticipantDeleteReport
+	c.mustUnmarshal(c.mustInvoke(admin, "deleteParticipant", userID, mode), &report)
+	return report
+}
+
+func (c *testChain) credentialsValid(userID string, password string) bool {
+	c.t.Helper()
+	var result struct {
+		Valid bool
+	}
+	c.mustUnmarshal(c.secret("Password", password).mustInvoke("", "VerifyCredentials", `{"UserID": "`+userID+`"}`), &result)
+	return result.Valid
+}
+
+func TestDeleteParticipantHard(t *testing.T) {
+	c := newExchain(t)
+	host := "i000005"
+	c.register(host, IBS, false)
+	c.mustInvoke(admin, "CreditAdd", `{"userID": "`+owner+`", "value": 50, "ticketID": "creditADD"}`)
+
+	// a funded ticket of owner with an application, and a seat of owner on a full ticket of host
+	c.mustInvoke(owner, "TicketCreate", fundedTicketJSON(FundingCredit, 30, 10, ""))
+	funded := c.lastTxID()
+	c.apply(funded, applicant)
+	full := c.createTicketWithCapacity(host, 1)
+	c.apply(full, owner)
+	c.mustInvoke(host, "OrderUpdate", orderUpdate(full, "Confirm", owner))
+	if order := c.apply(full, applicant); order.Status != OrderWaitlisted {
+		t.Fatalf("unexpected order %+v", order)
+	}
+	c.events()
+
+	c.mustFail("Forbidden", owner, "deleteParticipant", owner)
+	c.mustFail("Mode must be Soft or Hard", admin, "deleteParticipant", owner, "Later")
+	report := c.deleteParticipant(owner, "")
+	if report.Mode != DeleteHard || !equalStrings(report.ExpiredTickets, []string{funded}) ||
+		!equalStrings(report.ClosedOrders, []string{funded + "/" + applicant}) ||
+		!equalStrings(report.WithdrawnOrders, []string{full + "/" + owner}) ||
+		!equalStrings(report.PromotedOrders, []string{full + "/" + applicant}) || report.Refunded != 30 || report.Credit != 50 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if got := c.events(); len(got) != 1 || got[0] != EventBatch {
+		t.Errorf("unexpected events %v", got)
+	}
+
+	// nothing of owner is left but the journal, tickets and orders stay for the others
+	c.mustFail("Participant i000002 does not exist", "", "readParticipant", owner)
+	c.mustFail("Credit i000002 does not exist", "", "CreditRead", owner)
+	credentialKey, _ := passwordKey(c.stub, owner)
+	if c.credentialsValid(owner, "pw-"+owner) || c.stub.PvtState[CredentialCollection][credentialKey] != nil {
+		t.Error("a deleted participant signed in")
+	}
+	lobs := c.lobTotals()
+	if lobs[HANA].TotalCredit != 0 || lobs[HANA].Members != 0 {
+		t.Errorf("unexpected LoB %+v", lobs[HANA])
+	}
+	if c.ticket(funded).Status != Expired || c.order(funded, applicant).Status != OrderClosed {
+		t.Errorf("unexpected ticket %+v", c.ticket(funded))
+	}
+	if c.order(full, owner).Status != OrderWithdrawn || c.order(full, applicant).Status != OrderApplied {
+		t.Errorf("unexpected orders %+v %+v", c.order(full, owner), c.order(full, applicant))
+	}
+	if escrow := c.escrow(funded); escrow.Locked != 0 || escrow.Refunded != 30 {
+		t.Errorf("unexpected escrow %+v", escrow)
+	}
+	var journal struct {
+		Entries []JournalEntry
+	}
+	c.mustUnmarshal(c.mustInvoke("", "CreditJournal", owner), &journal)
+	var reasons []string
+	for _, entry := range journal.Entries {
+		reasons = append(reasons, entry.Reason+" "+strconv.Itoa(entry.Delta))
+	}
+	// entries of one transaction are keyed by reason
+	if !equalStrings(reasons, []string{"add 50", "escrow -30", "delete -50", "refund 30"}) {
+		t.Errorf("unexpected journal %v", reasons)
+	}
+
+	// the UserID is free again
+	c.register(owner, SMB, false)
+	if c.credit(owner) != 0 || c.lobTotals()[SMB].Members != 3 {
+		t.Errorf("unexpected credit %d", c.credit(owner))
+	}
+}
+
+func TestDeleteParticipantSoft(t *testing.T) {
+	c := newExchain(t)
+	c.mustInvoke(admin, "CreditAdd", `{"userID": "`+applicant+`", "value": 12, "ticketID": "creditADD"}`)
+	ticketID := c.createTicket(owner, 0, 10, "")
+	c.apply(ticketID, applicant)
+
+	report := c.deleteParticipant(applicant, DeleteSoft)
+	if !equalStrings(report.WithdrawnOrders, []string{ticketID + "/" + applicant}) || report.Credit != 12 || len(report.ExpiredTickets) != 0 {
+		t.Errorf("unexpected report %+v", report)
+	}
+
+	// the records stay, the credit stays counted in SMB, but not as a member
+	var participant Participant
+	c.mustUnmarshal(c.mustInvoke("", "readParticipant", applicant), &participant)
+	if !participant.Deactivated || participant.CreditBase != 12 || c.credit(applicant) != 12 {
+		t.Errorf("unexpected participant %+v", participant)
+	}
+	lobs := c.lobTotals()
+	if lobs[SMB].TotalCredit != 12 || lobs[SMB].RetainedCredit != 12 || lobs[SMB].Members != 1 {
+		t.Errorf("unexpected LoB %+v", lobs[SMB])
+	}
+	if c.order(ticketID, applicant).Status != OrderWithdrawn {
+		t.Errorf("unexpected order %+v", c.order(ticketID, applicant))
+	}
+
+	// no more sign-in or transactions
+	if c.credentialsValid(applicant, "pw-"+applicant) {
+		t.Error("a deactivated participant signed in")
+	}
+	if e := c.failure(applicant, "TicketCreate", ticketJSON(applicant, 10)); e.Code != ErrForbidden || !strings.Contains(e.Message, "is deactivated") {
+		t.Errorf("unexpected error %+v", e)
+	}
+	c.mustFail("is already deactivated", admin, "deleteParticipant", applicant, DeleteSoft)
+	c.mustFail("i000003 is deactivated", admin, "ParticipantChangeLoB", changeLoB(applicant, IBS, CreditPolicyMove))
+	if e := c.failure(owner, "CreditTransfer", `{"From": "`+owner+`", "To": "`+applicant+`", "Value": 1}`); e.Code != ErrForbidden || !strings.Contains(e.Message, "i000003 is deactivated") {
+		t.Errorf("unexpected error %+v", e)
+	}
+	c.mustFail("Participant i000003 already exists", admin, "addParticipant", participantJSON(applicant, SMB, false))
+	c.mustInvoke(admin, "updateParticipant",
+		`{"Participant_UserID": "`+applicant+`", "Participant_UserName": "x", "Participant_IsAdmin": false, "Participant_LoBID": 2}`)
+	c.mustUnmarshal(c.mustInvoke("", "readParticipant", applicant), &participant)
+	if !participant.Deactivated {
+		t.Error("updateParticipant reactivated the participant")
+	}
+
+	// a hard delete afterwards removes the records, SMB keeps what it retained
+	report = c.deleteParticipant(applicant, DeleteHard)
+	lobs = c.lobTotals()
+	if report.Credit != 12 || lobs[SMB].TotalCredit != 12 || lobs[SMB].RetainedCredit != 12 {
+		t.Errorf("unexpected LoB %+v", lobs[SMB])
+	}
+	c.mustFail("Participant i000003 does not exist", "", "readParticipant", applicant)
+}
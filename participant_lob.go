@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// Credit policies of ParticipantChangeLoB, what happens to the credit the participant earned in the old LoB
+//
+//	Move:   it leaves the LoB_TotalCredit of the old LoB and joins that of the new one
+//	Keep:   it stays with the old LoB as LoB_RetainedCredit, the new LoB only counts credit earned from now on
+const (
+	CreditPolicyMove = "Move"
+	CreditPolicyKeep = "Keep"
+)
+
+// LoBChange information, a move of a participant between LoBs, stored with the participant
+//
+//	Credit:   credit that moved with the participant (Move) or stayed with FromLoBID (Keep)
+type LoBChange struct {
+	FromLoBID    int       `json:"FromLoBID"`
+	ToLoBID      int       `json:"ToLoBID"`
+	CreditPolicy string    `json:"CreditPolicy"`
+	Credit       int       `json:"Credit"`
+	TxID         string    `json:"TxID"`
+	Timestamp    time.Time `json:"Timestamp"`
+}
+
+// Helper: the part of a participant's credit counted in the LoB_TotalCredit of its LoB, 0 without credit record
+func countedCredit(stub shim.ChaincodeStubInterface, participant Participant) (int, error) {
+	credit, err := retrieveSingleCredit(stub, participant.UserID)
+	if e, ok := err.(*ChaincodeError); ok && e.Code == ErrNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return credit.Value - participant.CreditBase, nil
+}
+
+// Invoke Route: ParticipantChangeLoB
+//
+//	args[0]: {"UserID": "i000003", "LoBID": 3, "CreditPolicy": "Move"}
+//	moves the participant from the LoB_UserIDs of its LoB to those of LoBID in one transaction,
+//	the LoB totals follow CreditPolicy
+func (rdg *SmartContract) ParticipantChangeLoB(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	var request struct {
+		UserID       string `json:"UserID"`
+		LoBID        int    `json:"LoBID"`
+		CreditPolicy string `json:"CreditPolicy"`
+	}
+	err := json.Unmarshal([]byte(args[0]), &request)
+	if err != nil {
+		return errorResponse(badRequest("ParticipantChangeLoB", err.Error()))
+	}
+	if request.CreditPolicy != CreditPolicyMove && request.CreditPolicy != CreditPolicyKeep {
+		return errorResponse(badRequest("ParticipantChangeLoB", "CreditPolicy must be Move or Keep"))
+	}
+
+	var participant Participant
+	participantAsBytes, err := rdg.retrieveParticipant(stub, request.UserID)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = json.Unmarshal(participantAsBytes, &participant)
+	if err != nil {
+		return errorResponse(errInternal("ParticipantChangeLoB: Corrupt participant " + request.UserID))
+	}
+	if participant.Deactivated {
+		return errorResponse(newError(ErrConflict, "ParticipantChangeLoB: "+request.UserID+" is deactivated").
+			on(ParticipantObjectType, request.UserID))
+	}
+	if participant.LoBID == request.LoBID {
+		return errorResponse(newError(ErrConflict, "ParticipantChangeLoB: "+request.UserID+" is already in LoB "+strconv.Itoa(request.LoBID)).
+			on(ParticipantObjectType, request.UserID))
+	}
+	err = checkParticipantLoB(stub, "ParticipantChangeLoB", request.LoBID)
+	if err != nil {
+		return errorResponse(err)
+	}
+	counted, err := countedCredit(stub, participant)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	// ==== Membership and credit of both LoBs change together, ancestors the two share net out ====
+	totals := map[int]lobDelta{
+		participant.LoBID: {Leave: participant.UserID},
+		request.LoBID:     {Join: participant.UserID},
+	}
+	if request.CreditPolicy == CreditPolicyMove {
+		err = addLoBCredit(stub, totals, participant.LoBID, -counted)
+		if err == nil {
+			err = addLoBCredit(stub, totals, request.LoBID, counted)
+		}
+		if err != nil {
+			return errorResponse(err)
+		}
+	} else {
+		old := totals[participant.LoBID]
+		old.Retained += counted
+		totals[participant.LoBID] = old
+		participant.CreditBase += counted
+	}
+	err = applyLoBDeltas(stub, totals)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	timestamp, err := txTime(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	participant.LoBChange = &LoBChange{FromLoBID: participant.LoBID, ToLoBID: request.LoBID, CreditPolicy: request.CreditPolicy,
+		Credit: counted, TxID: stub.GetTxID(), Timestamp: timestamp}
+	participant.LoBID = request.LoBID
+	participantAsBytes, err = rdg.saveParticipant(stub, participant)
+	if err != nil {
+		return errorResponse(err)
+	}
+	logger.Info("ParticipantChangeLoB:", participant.UserID, participant.LoBChange.FromLoBID, participant.LoBID, request.CreditPolicy, counted)
+
+	err = emitEvent(stub, ExchainEvent{Name: EventParticipantMoved, UserID: participant.UserID, Delta: counted})
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(participantAsBytes)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"strconv"
+	"testing"
+)
+
+func changeLoB(userID string, LoBID int, policy string) string {
+	return `{"UserID": "` + userID + `", "LoBID": ` + strconv.Itoa(LoBID) + `, "CreditPolicy": "` + policy + `"}`
+}
+
+func TestParticipantChangeLoBMove(t *testing.T) {
+	c := newExchain(t)
+	c.mustInvoke(admin, "CreditAdd", `{"userID": "`+applicant+`", "value": 12, "ticketID": "creditADD"}`)
+	c.events()
+
+	var participant Participant
+	c.mustUnmarshal(c.mustInvoke(admin, "ParticipantChangeLoB", changeLoB(applicant, IBS, CreditPolicyMove)), &participant)
+	change := participant.LoBChange
+	if participant.LoBID != IBS || change == nil || change.FromLoBID != SMB || change.ToLoBID != IBS || change.Credit != 12 ||
+		change.CreditPolicy != CreditPolicyMove || change.TxID != c.lastTxID() {
+		t.Errorf("unexpected participant %+v", participant)
+	}
+	if got := c.events(); !equalStrings(got, []string{EventParticipantMoved}) {
+		t.Errorf("unexpected events %v", got)
+	}
+
+	// membership and credit leave SMB together
+	lobs := c.lobTotals()
+	if lobs[SMB].TotalCredit != 0 || lobs[SMB].Members != 1 || lobs[IBS].TotalCredit != 12 || lobs[IBS].Members != 1 {
+		t.Errorf("unexpected LoBs %+v", lobs)
+	}
+	c.mustUnmarshal(c.mustInvoke("", "readParticipant", applicant), &participant)
+	if participant.LoBID != IBS || participant.LoBChange == nil || participant.CreditBase != 0 {
+		t.Errorf("unexpected participant %+v", participant)
+	}
+
+	// later credit counts for the new LoB
+	c.mustInvoke(admin, "CreditAdd", `{"userID": "`+applicant+`", "value": 3, "ticketID": "creditADD"}`)
+	if c.lobTotal(IBS) != 15 || c.lobTotal(SMB) != 0 {
+		t.Errorf("unexpected LoB totals IBS %d SMB %d", c.lobTotal(IBS), c.lobTotal(SMB))
+	}
+
+	// ancestors of both LoBs keep their rolled-up credit
+	var east LoB
+	c.mustUnmarshal(c.mustInvoke(admin, "LoBCreate", `{"LoB_Name": "IBS East", "LoB_ParentID": 3}`), &east)
+	c.mustInvoke(admin, "ParticipantChangeLoB", changeLoB(applicant, east.LoBID, CreditPolicyMove))
+	lobs = c.lobTotals()
+	if lobs[IBS].TotalCredit != 0 || lobs[IBS].RolledUpCredit != 15 || lobs[east.LoBID].TotalCredit != 15 || lobs[IBS].RolledUpMembers != 1 {
+		t.Errorf("unexpected LoBs %+v", lobs)
+	}
+}
+
+func TestParticipantChangeLoBKeep(t *testing.T) {
+	c := newExchain(t)
+	c.mustInvoke(admin, "CreditAdd", `{"userID": "`+other+`", "value": 8, "ticketID": "creditADD"}`)
+
+	// the credit earned so far stays with SMB
+	var participant Participant
+	c.mustUnmarshal(c.mustInvoke(admin, "ParticipantChangeLoB", changeLoB(other, IBS, CreditPolicyKeep)), &participant)
+	if participant.CreditBase != 8 || participant.LoBChange.Credit != 8 || participant.LoBChange.CreditPolicy != CreditPolicyKeep {
+		t.Errorf("unexpected participant %+v", participant)
+	}
+	lobs := c.lobTotals()
+	if lobs[SMB].TotalCredit != 8 || lobs[SMB].RetainedCredit != 8 || lobs[SMB].Members != 1 || lobs[IBS].TotalCredit != 0 || lobs[IBS].Members != 1 {
+		t.Errorf("unexpected LoBs %+v", lobs)
+	}
+
+	// IBS only counts what is earned from now on
+	c.mustInvoke(admin, "CreditAdd", `{"userID": "`+other+`", "value": 5, "ticketID": "creditADD"}`)
+	if c.lobTotal(IBS) != 5 || c.lobTotal(SMB) != 8 || c.credit(other) != 13 {
+		t.Errorf("unexpected LoB totals IBS %d SMB %d", c.lobTotal(IBS), c.lobTotal(SMB))
+	}
+
+	// moving on takes the part IBS counted, not what SMB kept
+	c.mustUnmarshal(c.mustInvoke(admin, "ParticipantChangeLoB", changeLoB(other, HANA, CreditPolicyMove)), &participant)
+	if participant.CreditBase != 8 || participant.LoBChange.Credit != 5 || c.lobTotal(IBS) != 0 || c.lobTotal(HANA) != 5 || c.lobTotal(SMB) != 8 {
+		t.Errorf("unexpected participant %+v", participant)
+	}
+	// updateParticipant keeps the base
+	c.mustInvoke(admin, "updateParticipant",
+		`{"Participant_UserID": "`+other+`", "Participant_UserName": "x", "Participant_IsAdmin": false, "Participant_LoBID": 1}`)
+	c.mustUnmarshal(c.mustInvoke("", "readParticipant", other), &participant)
+	if participant.CreditBase != 8 || participant.LoBChange == nil || participant.UserName != "x" {
+		t.Errorf("unexpected participant %+v", participant)
+	}
+}
+
+func TestParticipantChangeLoBErrors(t *testing.T) {
+	c := newExchain(t)
+	c.mustFail("Forbidden", applicant, "ParticipantChangeLoB", changeLoB(applicant, IBS, CreditPolicyMove))
+	c.mustFail("CreditPolicy must be Move or Keep", admin, "ParticipantChangeLoB", changeLoB(applicant, IBS, "Split"))
+	c.mustFail("missing field CreditPolicy", admin, "ParticipantChangeLoB", `{"UserID": "`+applicant+`", "LoBID": 3}`)
+	c.mustFail("Participant_LoBID 42 is not an active LoB", admin, "ParticipantChangeLoB", changeLoB(applicant, 42, CreditPolicyMove))
+	if e := c.failure(admin, "ParticipantChangeLoB", changeLoB("i999999", IBS, CreditPolicyMove)); e.Code != ErrNotFound {
+		t.Errorf("unexpected error %+v", e)
+	}
+	e := c.failure(admin, "ParticipantChangeLoB", changeLoB(applicant, SMB, CreditPolicyMove))
+	if e.Code != ErrConflict || e.Entity != ParticipantObjectType || e.ID != applicant {
+		t.Errorf("unexpected error %+v", e)
+	}
+
+	// updateParticipant no longer moves participants
+	c.mustFail("Participant_LoBID changes with ParticipantChangeLoB", admin, "updateParticipant",
+		`{"Participant_UserID": "`+applicant+`", "Participant_UserName": "x", "Participant_IsAdmin": false, "Participant_LoBID": 3}`)
+	var participant Participant
+	c.mustUnmarshal(c.mustInvoke("", "readParticipant", applicant), &participant)
+	if participant.LoBID != SMB {
+		t.Errorf("unexpected participant %+v", participant)
+	}
+}
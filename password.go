@@ -0,0 +1,294 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// PasswordIterations - PBKDF2 rounds used for new password hashes
+const PasswordIterations = 10000
+
+// CredentialCollection - private data collection of the password records, see collections_config.json
+const CredentialCollection = "exchainCredentials"
+
+// MinSaltLength - bytes of the random salt a client sends along with a new password
+const MinSaltLength = 16
+
+// MaxPasswordLength - bytes of a password in the transient map
+const MaxPasswordLength = 128
+
+// PasswordRecord information, stored in CredentialCollection under the composite key ("Credential", UserID)
+//
+//	UserID:        iXXXXXX
+//	Salt:          hex SHA-256 of the transient Salt of the client and the UserID
+//	Hash:          hex PBKDF2-HMAC-SHA256(password, salt)
+//	Iterations:    PBKDF2 rounds
+type PasswordRecord struct {
+	UserID     string `json:"UserID"`
+	Salt       string `json:"Salt"`
+	Hash       string `json:"Hash"`
+	Iterations int    `json:"Iterations"`
+}
+
+// Helper: single block PBKDF2-HMAC-SHA256, enough for a 32 byte key
+func pbkdf2SHA256(password []byte, salt []byte, iterations int) []byte {
+	prf := hmac.New(sha256.New, password)
+	prf.Write(salt)
+	prf.Write([]byte{0, 0, 0, 1})
+	u := prf.Sum(nil)
+	key := make([]byte, len(u))
+	copy(key, u)
+	for i := 1; i < iterations; i++ {
+		prf.Reset()
+		prf.Write(u)
+		u = prf.Sum(u[:0])
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+	return key
+}
+
+func passwordKey(stub shim.ChaincodeStubInterface, userID string) (string, error) {
+	return stub.CreateCompositeKey("Credential", []string{userID})
+}
+
+// Helper: a field of the transient map of fn, passwords and salts never travel in the arguments; every endorser
+// gets the same transient map and so computes the same record
+func transientField(stub shim.ChaincodeStubInterface, fn string, name string, minLength int, maxLength int) ([]byte, error) {
+	transient, err := stub.GetTransient()
+	if err != nil {
+		return nil, errInternal(fn + ": Error getting the transient map")
+	}
+	value, ok := transient[name]
+	if !ok {
+		return nil, badRequest(fn, "missing transient field "+name)
+	}
+	if len(value) < minLength || len(value) > maxLength {
+		return nil, badRequest(fn, fmt.Sprintf("transient field %s must be %d to %d bytes", name, minLength, maxLength))
+	}
+	return value, nil
+}
+
+// Helper: the password under name in the transient map of fn
+func transientPassword(stub shim.ChaincodeStubInterface, fn string, name string) (string, error) {
+	password, err := transientField(stub, fn, name, 1, MaxPasswordLength)
+	return string(password), err
+}
+
+// Helper: the random Salt a client sends in the transient map of fn; unlike the tx ID it is never public
+func transientSalt(stub shim.ChaincodeStubInterface, fn string) ([]byte, error) {
+	return transientField(stub, fn, "Salt", MinSaltLength, 1024)
+}
+
+// Helper: hash and store a password in CredentialCollection, salted with the client's secret salt and the UserID
+func savePassword(stub shim.ChaincodeStubInterface, userID string, password string, clientSalt []byte) error {
+	if password == "" {
+		return badRequest("savePassword", "Password can not be empty")
+	}
+	salt := sha256.Sum256(append(append([]byte{}, clientSalt...), userID...))
+	record := PasswordRecord{
+		UserID:     userID,
+		Salt:       hex.EncodeToString(salt[:]),
+		Iterations: PasswordIterations}
+	record.Hash = hex.EncodeToString(pbkdf2SHA256([]byte(password), salt[:], record.Iterations))
+
+	bytes, err := json.Marshal(record)
+	if err != nil {
+		return errors.New("savePassword: Error marshalling password record")
+	}
+	key, err := passwordKey(stub, userID)
+	if err != nil {
+		return errors.New("savePassword: " + err.Error())
+	}
+	err = stub.PutPrivateData(CredentialCollection, key, bytes)
+	if err != nil {
+		return errors.New("savePassword: Error storing password record")
+	}
+	return nil
+}
+
+// Helper: compare a password against the stored hash
+func checkPassword(stub shim.ChaincodeStubInterface, userID string, password string) (bool, error) {
+	var record PasswordRecord
+	key, err := passwordKey(stub, userID)
+	if err != nil {
+		return false, errors.New("checkPassword: " + err.Error())
+	}
+	bytes, err := stub.GetPrivateData(CredentialCollection, key)
+	if err != nil {
+		return false, errors.New("checkPassword: Error getting password record of " + userID)
+	}
+	if bytes == nil {
+		return false, nil
+	}
+	err = json.Unmarshal(bytes, &record)
+	if err != nil {
+		return false, errors.New("checkPassword: Corrupt password record of " + userID)
+	}
+	salt, err := hex.DecodeString(record.Salt)
+	if err != nil {
+		return false, errors.New("checkPassword: Corrupt password salt of " + userID)
+	}
+	expected, err := hex.DecodeString(record.Hash)
+	if err != nil {
+		return false, errors.New("checkPassword: Corrupt password hash of " + userID)
+	}
+	hash := pbkdf2SHA256([]byte(password), salt, record.Iterations)
+	return subtle.ConstantTimeCompare(hash, expected) == 1, nil
+}
+
+// Helper: move plaintext passwords of existing participants into password records, salted with the transient Salt
+// of Init, and password records kept in public state by earlier versions into CredentialCollection
+func migratePasswords(stub shim.ChaincodeStubInterface) (int, error) {
+	var readingIDs ReadingIDIndex
+	bytes, err := stub.GetState("readingIDIndex")
+	if err != nil {
+		return 0, errors.New("migratePasswords: Error getting readingIDIndex array")
+	}
+	if len(bytes) == 0 {
+		return 0, nil
+	}
+	err = json.Unmarshal(bytes, &readingIDs)
+	if err != nil {
+		return 0, errors.New("migratePasswords: Error unmarshalling readingIDIndex array JSON")
+	}
+
+	migrated := 0
+	var salt []byte
+	for _, participantID := range readingIDs.UserIDs {
+		var participant Participant
+		bytes, err = stub.GetState(participantKey(stub, participantID))
+		if err != nil {
+			return migrated, errors.New("migratePasswords: Error getting participant with ID: " + participantID)
+		}
+		if json.Unmarshal(bytes, &participant) != nil || participant.Password == "" {
+			continue
+		}
+		if salt == nil {
+			salt, err = transientSalt(stub, "Init")
+			if err != nil {
+				return migrated, err
+			}
+		}
+		err = savePassword(stub, participant.UserID, participant.Password, salt)
+		if err != nil {
+			return migrated, err
+		}
+		participant.Password = ""
+		bytes, err = json.Marshal(participant)
+		if err != nil {
+			return migrated, errors.New("migratePasswords: Error marshalling participant " + participantID)
+		}
+		err = stub.PutState(participantKey(stub, participantID), bytes)
+		if err != nil {
+			return migrated, errors.New("migratePasswords: Error storing participant " + participantID)
+		}
+		migrated++
+	}
+
+	records, err := stub.GetStateByPartialCompositeKey("Credential", []string{})
+	if err != nil {
+		return migrated, errors.New("migratePasswords: Error getting public password records")
+	}
+	defer records.Close()
+	for records.HasNext() {
+		record, err := records.Next()
+		if err != nil {
+			return migrated, errors.New("migratePasswords: Error iterating public password records")
+		}
+		err = stub.PutPrivateData(CredentialCollection, record.Key, record.Value)
+		if err != nil {
+			return migrated, errors.New("migratePasswords: Error storing password record " + record.Key)
+		}
+		err = stub.DelState(record.Key)
+		if err != nil {
+			return migrated, errors.New("migratePasswords: Error deleting public password record " + record.Key)
+		}
+		migrated++
+	}
+	return migrated, nil
+}
+
+// Invoke Route: ChangePassword, the passwords and a new random salt travel in the transient map
+//
+//	args[0]:   {"UserID": "iXXXXXX"}
+//	transient: {"OldPassword": "...", "NewPassword": "...", "Salt": at least MinSaltLength random bytes}
+func (rdg *SmartContract) ChangePassword(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	var request struct {
+		UserID string `json:"UserID"`
+	}
+	err := json.Unmarshal([]byte(args[0]), &request)
+	if err != nil {
+		return errorResponse(badRequest("ChangePassword", "Input is not a valid JSON"))
+	}
+	oldPassword, err := transientPassword(stub, "ChangePassword", "OldPassword")
+	if err != nil {
+		return errorResponse(err)
+	}
+	newPassword, err := transientPassword(stub, "ChangePassword", "NewPassword")
+	if err != nil {
+		return errorResponse(err)
+	}
+	salt, err := transientSalt(stub, "ChangePassword")
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	valid, err := checkPassword(stub, request.UserID, oldPassword)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if !valid {
+		return errorResponse(forbidden("ChangePassword", "old password does not match"))
+	}
+
+	err = savePassword(stub, request.UserID, newPassword, salt)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(nil)
+}
+
+// Query Route: VerifyCredentials
+//
+//	args[0]:   {"UserID": "iXXXXXX"}
+//	transient: {"Password": "..."}
+//	returns {"UserID": "iXXXXXX", "Valid": true}
+func (rdg *SmartContract) VerifyCredentials(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	var request struct {
+		UserID string `json:"UserID"`
+	}
+	err := json.Unmarshal([]byte(args[0]), &request)
+	if err != nil {
+		return errorResponse(badRequest("VerifyCredentials", "Input is not a valid JSON"))
+	}
+	password, err := transientPassword(stub, "VerifyCredentials", "Password")
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	valid, err := checkPassword(stub, request.UserID, password)
+	if err != nil {
+		return errorResponse(err)
+	}
+	// ==== A soft-deleted participant keeps its password record but can not sign in ====
+	if valid {
+		var participant Participant
+		bytes, err := stub.GetState(participantKey(stub, request.UserID))
+		if err != nil {
+			return errorResponse(errInternal("VerifyCredentials: Error getting participant with ID: " + request.UserID))
+		}
+		valid = bytes != nil && json.Unmarshal(bytes, &participant) == nil && !participant.Deactivated
+	}
+	result, _ := json.Marshal(map[string]interface{}{"UserID": request.UserID, "Valid": valid})
+	return shim.Success(result)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// DefaultQueryPageSize - page size of the rich query routes when none is given
+const DefaultQueryPageSize = 20
+
+// MaxQueryPageSize - largest page the rich query routes return
+const MaxQueryPageSize = 100
+
+// richQueryType information, the documents a rich query route searches
+//
+//	Entity:     object type of the documents
+//	Selector:   CouchDB selector matching only documents of that type
+//	Record:     struct the documents unmarshal into, its JSON fields may be selected and sorted on
+//	Hidden:     fields of Record that may not be queried
+type richQueryType struct {
+	Entity   string
+	Selector map[string]interface{}
+	Record   interface{}
+	Hidden   []string
+}
+
+func exists(fields ...string) map[string]interface{} {
+	selector := make(map[string]interface{})
+	for _, field := range fields {
+		selector[field] = map[string]interface{}{"$exists": true}
+	}
+	return selector
+}
+
+// richQueryTypes - the rich query routes; the indexes for them are in META-INF/statedb/couchdb/indexes
+var richQueryTypes = map[string]richQueryType{
+	"TicketQuery":      {Entity: TicketObjectType, Selector: exists("Ticket_TicketID"), Record: Ticket{}},
+	"OrderQuery":       {Entity: OrderObjectType, Selector: exists("TicketID", "UserID", "Status"), Record: Order{}},
+	"ParticipantQuery": {Entity: ParticipantObjectType, Selector: exists("Participant_UserID"), Record: Participant{}, Hidden: []string{"Participant_Password"}},
+}
+
+// RichQuery information, first argument of the rich query routes
+//
+//	Selector:     CouchDB selector on the JSON fields of the entity, it is combined with the type selector
+//	Sort:         fields to sort on, each needs an index
+//	Descending:   sort all fields descending instead of ascending
+type RichQuery struct {
+	Selector   map[string]json.RawMessage `json:"selector"`
+	Sort       []string                   `json:"sort"`
+	Descending bool                       `json:"descending"`
+}
+
+// QueryPage information, response of the rich query routes
+//
+//	Items:      matching records
+//	Count:      len(Items)
+//	Bookmark:   pass it to get the next page, empty or repeated once there are no more records
+type QueryPage struct {
+	Items    []json.RawMessage `json:"Items"`
+	Count    int               `json:"Count"`
+	Bookmark string            `json:"Bookmark"`
+}
+
+// Helper: JSON field names of a struct
+func jsonFieldNames(record interface{}) map[string]bool {
+	names := make(map[string]bool)
+	t := reflect.TypeOf(record)
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+// Helper: field names of a selector that are not fields of entity. Operands of operators such as $and, $or,
+// $nor, $not or $elemMatch are checked as selectors again at any depth; the conditions on a field are left to CouchDB.
+func selectorProblems(entity string, fields map[string]bool, selector map[string]interface{}) []string {
+	var problems []string
+	for name, value := range selector {
+		if !strings.HasPrefix(name, "$") {
+			if !fields[name] {
+				problems = append(problems, "selector field "+name+" is not a field of "+entity)
+			}
+			continue
+		}
+		problems = append(problems, operandProblems(entity, fields, value)...)
+	}
+	return problems
+}
+
+// Helper: selectorProblems of the selectors in the operand of an operator
+func operandProblems(entity string, fields map[string]bool, operand interface{}) []string {
+	switch operand := operand.(type) {
+	case map[string]interface{}:
+		return selectorProblems(entity, fields, operand)
+	case []interface{}:
+		var problems []string
+		for _, item := range operand {
+			problems = append(problems, operandProblems(entity, fields, item)...)
+		}
+		return problems
+	}
+	return nil
+}
+
+// Helper: CouchDB query of a rich query route; fields outside the entity are rejected, operators pass to CouchDB
+func buildRichQuery(function string, queryType richQueryType, request RichQuery) (string, error) {
+	fields := jsonFieldNames(queryType.Record)
+	for _, name := range queryType.Hidden {
+		delete(fields, name)
+	}
+	var problems []string
+	for name, raw := range request.Selector {
+		var value interface{}
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return "", badRequest(function, "selector field "+name+": "+err.Error())
+		}
+		problems = append(problems, selectorProblems(queryType.Entity, fields, map[string]interface{}{name: value})...)
+	}
+	for _, name := range request.Sort {
+		if !fields[name] {
+			problems = append(problems, "sort field "+name+" is not a field of "+queryType.Entity)
+		}
+	}
+	if len(problems) > 0 {
+		return "", badRequest(function, problems...)
+	}
+
+	query := map[string]interface{}{"selector": queryType.Selector}
+	if len(request.Selector) > 0 {
+		query["selector"] = map[string]interface{}{"$and": []interface{}{queryType.Selector, request.Selector}}
+	}
+	if len(request.Sort) > 0 {
+		direction := "asc"
+		if request.Descending {
+			direction = "desc"
+		}
+		var sort []map[string]string
+		for _, name := range request.Sort {
+			sort = append(sort, map[string]string{name: direction})
+		}
+		query["sort"] = sort
+	}
+	queryAsBytes, err := json.Marshal(query)
+	if err != nil {
+		return "", errInternal(function + ": " + err.Error())
+	}
+	return string(queryAsBytes), nil
+}
+
+// Helper: a LevelDB peer rejects rich queries, the mock stub returns no iterator
+func richQueryUnsupported(function string) *ChaincodeError {
+	return newError(ErrUnsupported, function+": Rich queries need CouchDB as state database, use TicketList, OrderRead2 or readAllParticipant")
+}
+
+// Query Route: TicketQuery, OrderQuery, ParticipantQuery
+//
+//	args: RichQuery JSON [, pageSize [, bookmark]]
+//	returns a QueryPage; paginated queries are only allowed in read-only (query) transactions
+func (rdg *SmartContract) richQuery(stub shim.ChaincodeStubInterface, function string, args []string) peer.Response {
+	var request RichQuery
+	queryType := richQueryTypes[function]
+	pageSize := DefaultQueryPageSize
+	bookmark := ""
+
+	err := json.Unmarshal([]byte(args[0]), &request)
+	if err != nil {
+		return errorResponse(badRequest(function, err.Error()))
+	}
+	if len(args) > 1 && args[1] != "" {
+		size, err := strconv.Atoi(args[1])
+		if err != nil || size <= 0 || size > MaxQueryPageSize {
+			return errorResponse(badRequest(function, "pageSize must be between 1 and "+strconv.Itoa(MaxQueryPageSize)))
+		}
+		pageSize = size
+	}
+	if len(args) > 2 {
+		bookmark = args[2]
+	}
+
+	query, err := buildRichQuery(function, queryType, request)
+	if err != nil {
+		return errorResponse(err)
+	}
+	logger.Info("richQuery:", function, query)
+
+	iterator, metadata, err := stub.GetQueryResultWithPagination(query, int32(pageSize), bookmark)
+	if err != nil {
+		if strings.Contains(err.Error(), "not supported") {
+			return errorResponse(richQueryUnsupported(function))
+		}
+		return errorResponse(errInternal(function + ": " + err.Error()))
+	}
+	if iterator == nil {
+		return errorResponse(richQueryUnsupported(function))
+	}
+	defer iterator.Close()
+
+	page := QueryPage{Items: []json.RawMessage{}}
+	for iterator.HasNext() {
+		queryResponse, err := iterator.Next()
+		if err != nil {
+			return errorResponse(errInternal(function + ": " + err.Error()))
+		}
+		item := json.RawMessage(queryResponse.Value)
+		// participants never carry a password, even records written before it moved out
+		if queryType.Entity == ParticipantObjectType {
+			var participant Participant
+			err = json.Unmarshal(queryResponse.Value, &participant)
+			if err != nil {
+				return errorResponse(errInternal(function + ": Corrupt participant " + queryResponse.Key))
+			}
+			participant.Password = ""
+			item, err = json.Marshal(participant)
+			if err != nil {
+				return errorResponse(errInternal(function + ": " + err.Error()))
+			}
+		}
+		page.Items = append(page.Items, item)
+	}
+	page.Count = len(page.Items)
+	if metadata != nil {
+		page.Bookmark = metadata.Bookmark
+	}
+
+	pageAsBytes, err := json.Marshal(page)
+	if err != nil {
+		return errorResponse(errInternal(function + ": " + err.Error()))
+	}
+	return shim.Success(pageAsBytes)
+}
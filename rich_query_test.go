@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/hyperledger/fabric/protos/ledger/queryresult"
+)
+
+func TestRichQueryNeedsCouchDB(t *testing.T) {
+	c := newExchain(t)
+
+	// MockStub, like a LevelDB peer, has no rich queries
+	for _, function := range []string{"TicketQuery", "OrderQuery", "ParticipantQuery"} {
+		e := c.failure("", function, `{"selector": {}}`)
+		if e.Code != ErrUnsupported || e.Status != 501 {
+			t.Errorf("%s: unexpected error %+v", function, e)
+		}
+	}
+
+	c.stub.query = func(query string, pageSize int32, bookmark string) ([]*queryresult.KV, string, error) {
+		return nil, "", errors.New("GetQueryResult not supported for leveldb")
+	}
+	if e := c.failure("", "TicketQuery", `{"selector": {}}`); e.Code != ErrUnsupported {
+		t.Errorf("unexpected error %+v", e)
+	}
+	c.stub.query = func(query string, pageSize int32, bookmark string) ([]*queryresult.KV, string, error) {
+		return nil, "", errors.New("couchdb unreachable")
+	}
+	if e := c.failure("", "TicketQuery", `{"selector": {}}`); e.Code != ErrInternal {
+		t.Errorf("unexpected error %+v", e)
+	}
+}
+
+func TestRichQuery(t *testing.T) {
+	c := newExchain(t)
+	var queries []string
+	var pageSizes []int32
+	var bookmarks []string
+	var records []*queryresult.KV
+	c.stub.query = func(query string, pageSize int32, bookmark string) ([]*queryresult.KV, string, error) {
+		queries = append(queries, query)
+		pageSizes = append(pageSizes, pageSize)
+		bookmarks = append(bookmarks, bookmark)
+		return records, "next", nil
+	}
+
+	// the client selector is combined with the type selector
+	records = []*queryresult.KV{{Key: "t1", Value: []byte(`{"Ticket_TicketID":"t1","Ticket_Value":60}`)}}
+	var page QueryPage
+	c.mustUnmarshal(c.mustInvoke("", "TicketQuery",
+		`{"selector": {"Ticket_Status": 1, "Ticket_Value": {"$gt": 50}}, "sort": ["Ticket_Value"], "descending": true}`, "10", "b1"), &page)
+	expected := `{"selector":{"$and":[{"Ticket_TicketID":{"$exists":true}},{"Ticket_Status":1,"Ticket_Value":{"$gt":50}}]},"sort":[{"Ticket_Value":"desc"}]}`
+	if queries[0] != expected {
+		t.Errorf("query %s, expected %s", queries[0], expected)
+	}
+	if pageSizes[0] != 10 || bookmarks[0] != "b1" {
+		t.Errorf("pageSize %d and bookmark %q were not passed on", pageSizes[0], bookmarks[0])
+	}
+	if page.Count != 1 || string(page.Items[0]) != string(records[0].Value) || page.Bookmark != "next" {
+		t.Errorf("unexpected page %+v", page)
+	}
+
+	// an empty selector only selects the type, the default page size applies
+	records = nil
+	c.mustUnmarshal(c.mustInvoke("", "OrderQuery", `{"selector": {}, "sort": ["TicketID", "Status"]}`), &page)
+	expected = `{"selector":{"Status":{"$exists":true},"TicketID":{"$exists":true},"UserID":{"$exists":true}},"sort":[{"TicketID":"asc"},{"Status":"asc"}]}`
+	if queries[1] != expected || pageSizes[1] != DefaultQueryPageSize {
+		t.Errorf("query %s with page size %d", queries[1], pageSizes[1])
+	}
+	if page.Count != 0 || page.Items == nil {
+		t.Errorf("unexpected page %+v", page)
+	}
+
+	// passwords never leave the chaincode
+	records = []*queryresult.KV{{Key: "p1", Value: []byte(`{"Participant_UserID":"i1","Participant_Password":"secret","Participant_LoBID":1}`)}}
+	c.mustUnmarshal(c.mustInvoke("", "ParticipantQuery", `{"selector": {"Participant_LoBID": {"$in": [1, 2]}}}`), &page)
+	var participant map[string]interface{}
+	c.mustUnmarshal(page.Items[0], &participant)
+	if _, ok := participant["Participant_Password"]; ok || participant["Participant_UserID"] != "i1" {
+		t.Errorf("unexpected participant %v", participant)
+	}
+
+	c.mustFail("selector field Participant_Password is not a field of Participant", "", "ParticipantQuery",
+		`{"selector": {"Participant_Password": "secret"}}`)
+	for _, selector := range []string{
+		`{"$or": [{"Participant_LoBID": 1}, {"Participant_Password": "secret"}]}`,
+		`{"$and": [{"$nor": [{"Participant_LoBID": 2}]}, {"$not": {"Participant_Password": {"$regex": "^s"}}}]}`,
+		`{"$elemMatch": {"Participant_Password": "secret"}}`,
+	} {
+		c.mustFail("selector field Participant_Password is not a field of Participant", "", "ParticipantQuery", `{"selector": `+selector+`}`)
+	}
+	c.mustInvoke("", "ParticipantQuery", `{"selector": {"$or": [{"Participant_LoBID": 1}, {"Participant_UserID": {"$in": ["i1"]}}]}}`)
+	c.mustFail("selector field Title is not a field of Ticket; sort field Value is not a field of Ticket", "", "TicketQuery",
+		`{"selector": {"Title": "x"}, "sort": ["Value"]}`)
+	c.mustFail("missing field selector", "", "OrderQuery", `{"sort": ["Status"]}`)
+	c.mustFail("field selector must be a JSON object", "", "OrderQuery", `{"selector": []}`)
+	c.mustFail("pageSize must be between 1 and 100", "", "TicketQuery", `{"selector": {}}`, "101")
+	if len(queries) != 4 {
+		t.Errorf("%d queries reached the state database", len(queries))
+	}
+}
+
+func TestIndexesNameEntityFields(t *testing.T) {
+	files, err := filepath.Glob("META-INF/statedb/couchdb/indexes/*.json")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no index definitions: %v", err)
+	}
+	fields := make(map[string]bool)
+	for _, queryType := range richQueryTypes {
+		for name := range jsonFieldNames(queryType.Record) {
+			fields[name] = true
+		}
+	}
+	for _, file := range files {
+		bytes, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var index struct {
+			Index struct {
+				Fields []string `json:"fields"`
+			} `json:"index"`
+			DDoc string `json:"ddoc"`
+			Name string `json:"name"`
+			Type string `json:"type"`
+		}
+		err = json.Unmarshal(bytes, &index)
+		if err != nil {
+			t.Errorf("%s: %v", file, err)
+			continue
+		}
+		if index.Type != "json" || index.Name == "" || index.DDoc != index.Name+"Doc" || len(index.Index.Fields) == 0 {
+			t.Errorf("%s: unexpected index %+v", file, index)
+		}
+		for _, field := range index.Index.Fields {
+			if !fields[field] {
+				t.Errorf("%s: %s is not a field of a queried entity", file, field)
+			}
+		}
+	}
+}
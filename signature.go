@@ -0,0 +1,455 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Kinds of Invoke arguments and of the fields of JSON arguments
+//
+//	String:        a string, plain arguments must not be empty
+//	Int:           a decimal integer, a JSON number without fraction
+//	Bool:          a JSON true or false
+//	StringArray:   a JSON array of strings
+//	Object:        a JSON object, Schema names its definition in schemas; as a field any object
+const (
+	KindString = iota
+	KindInt
+	KindBool
+	KindStringArray
+	KindObject
+)
+
+// MaxIDLength - longest UserID, TicketID or TxID accepted in a JSON argument
+const MaxIDLength = 64
+
+// fieldSpec information, a property of a schema
+//
+//	Optional:              may be left out, otherwise it is listed as required
+//	Minimum, Maximum:      bounds of an Int field, nil if unbounded
+//	MinLength, MaxLength:  bounds of a String field in characters, MaxLength 0 if unbounded
+//	Format:                "date-time" for RFC 3339 timestamps
+type fieldSpec struct {
+	Name      string
+	Kind      int
+	Optional  bool
+	Minimum   *int
+	Maximum   *int
+	MinLength int
+	MaxLength int
+	Format    string
+}
+
+// argSpec information, a positional argument of an Invoke route
+//
+//	Optional:  may be omitted or empty, only trailing arguments are optional
+//	Schema:    definition of an Object argument
+type argSpec struct {
+	Name     string
+	Kind     int
+	Optional bool
+	Schema   string
+}
+
+func arg(name string, kind int) argSpec {
+	return argSpec{Name: name, Kind: kind}
+}
+
+func optionalArg(name string, kind int) argSpec {
+	return argSpec{Name: name, Kind: kind, Optional: true}
+}
+
+func object(schema string) argSpec {
+	return argSpec{Name: schema, Kind: KindObject, Schema: schema}
+}
+
+func optionalObject(schema string) argSpec {
+	return argSpec{Name: schema, Kind: KindObject, Optional: true, Schema: schema}
+}
+
+func field(name string, kind int) fieldSpec {
+	return fieldSpec{Name: name, Kind: kind}
+}
+
+func optionalField(name string, kind int) fieldSpec {
+	return fieldSpec{Name: name, Kind: kind, Optional: true}
+}
+
+func (f fieldSpec) between(minimum int, maximum int) fieldSpec {
+	f.Minimum, f.Maximum = intPtr(minimum), intPtr(maximum)
+	return f
+}
+
+func (f fieldSpec) atLeast(minimum int) fieldSpec {
+	f.Minimum = intPtr(minimum)
+	return f
+}
+
+func (f fieldSpec) length(minLength int, maxLength int) fieldSpec {
+	f.MinLength, f.MaxLength = minLength, maxLength
+	return f
+}
+
+func (f fieldSpec) format(format string) fieldSpec {
+	f.Format = format
+	return f
+}
+
+func id(name string) fieldSpec {
+	return field(name, KindString).length(1, MaxIDLength)
+}
+
+func optionalText(name string, maxLength int) fieldSpec {
+	return optionalField(name, KindString).length(0, maxLength)
+}
+
+// schemas of the JSON arguments, kept in sync with the definitions of ticket.yaml (see signature_test.go);
+// unknown fields are rejected
+var schemas = map[string][]fieldSpec{
+	"Participant": {
+		id("Participant_UserID"),
+		field("Participant_UserName", KindString).length(1, 128),
+		field("Participant_IsAdmin", KindBool),
+		field("Participant_LoBID", KindInt).atLeast(0),
+		optionalText("Participant_MSPID", MaxIDLength),
+	},
+	"ParticipantUpdate": {
+		id("Participant_UserID"),
+		field("Participant_UserName", KindString).length(1, 128),
+		field("Participant_IsAdmin", KindBool),
+		field("Participant_LoBID", KindInt).atLeast(0),
+		optionalText("Participant_MSPID", MaxIDLength),
+	},
+	"ParticipantChangeLoB": {
+		id("UserID"),
+		field("LoBID", KindInt).atLeast(0),
+		field("CreditPolicy", KindString),
+	},
+	"PasswordChange": {
+		id("UserID"),
+	},
+	"Credentials": {
+		id("UserID"),
+	},
+
+	"CreditAdd": {
+		id("userID"),
+		field("value", KindInt).atLeast(1),
+		id("ticketID"),
+	},
+	"CreditTransfer": {
+		id("From"),
+		id("To"),
+		field("Value", KindInt).atLeast(1),
+		optionalText("Memo", 256),
+	},
+	"CreditReverse": {
+		id("UserID"),
+		id("TxID"),
+		optionalText("Memo", 256),
+	},
+
+	"LoBInit": {
+		field("LoB_Name", KindString).length(1, MaxLoBNameLength),
+		optionalField("LoB_ParentID", KindInt).atLeast(0),
+	},
+	"LoBMove": {
+		field("LoB_LoBID", KindInt).atLeast(0),
+		optionalField("LoB_ParentID", KindInt).atLeast(0),
+	},
+	"LoBRename": {
+		field("LoB_LoBID", KindInt).atLeast(0),
+		field("LoB_Name", KindString).length(1, MaxLoBNameLength),
+	},
+	"LoBFund": {
+		field("LoBID", KindInt).atLeast(0),
+		field("Value", KindInt).atLeast(1),
+	},
+
+	"TicketInit": {
+		field("Ticket_Title", KindString).length(1, 256),
+		field("Ticket_Type", KindInt).atLeast(0),
+		field("Ticket_Value", KindInt).atLeast(0),
+		id("Ticket_UserID"),
+		optionalField("Ticket_Deadline", KindString).format("date-time"),
+		optionalText("Ticket_Comment", 2048),
+		optionalText("Ticket_Policy", 1024),
+		optionalField("Ticket_Capacity", KindInt).atLeast(0),
+		optionalField("Ticket_Award", KindObject),
+		optionalField("Ticket_Funding", KindString),
+	},
+	"Ticket": {
+		id("Ticket_TicketID"),
+		field("Ticket_Title", KindString).length(1, 256),
+		field("Ticket_Type", KindInt).atLeast(0),
+		field("Ticket_Value", KindInt).atLeast(0),
+		id("Ticket_UserID"),
+		optionalField("Ticket_Deadline", KindString).format("date-time"),
+		optionalText("Ticket_Comment", 2048),
+		optionalText("Ticket_Policy", 1024),
+		optionalField("Ticket_Capacity", KindInt).atLeast(0),
+		optionalField("Ticket_Award", KindObject),
+		optionalField("Ticket_Awarded", KindInt).atLeast(0),
+		optionalField("Ticket_Funding", KindString),
+	},
+
+	"TicketFilter": {
+		optionalField("Status", KindInt).between(Created, Expired),
+		optionalField("Type", KindInt).atLeast(0),
+		optionalField("UserID", KindString).length(1, MaxIDLength),
+		optionalField("DeadlineFrom", KindString).format("date-time"),
+		optionalField("DeadlineTo", KindString).format("date-time"),
+		optionalField("MinValue", KindInt).atLeast(0),
+		optionalField("MaxValue", KindInt).atLeast(0),
+	},
+
+	"RichQuery": {
+		field("selector", KindObject),
+		optionalField("sort", KindStringArray),
+		optionalField("descending", KindBool),
+	},
+
+	"HistoryRange": {
+		optionalField("From", KindString).format("date-time"),
+		optionalField("To", KindString).format("date-time"),
+	},
+
+	"OrderInit": {
+		id("TicketID"),
+		id("UserID"),
+	},
+	"OrderUpdate": orderUpdateFields(),
+}
+
+// signatureTable declares the arguments of every Invoke route, Invoke validates them before routing
+var signatureTable = map[string][]argSpec{
+	"addParticipant":       {object("Participant")},
+	"readParticipant":      {arg("UserID", KindString)},
+	"readAllParticipant":   {},
+	"updateParticipant":    {object("ParticipantUpdate")},
+	"deleteParticipant":    {arg("UserID", KindString), optionalArg("Mode", KindString)},
+	"ParticipantChangeLoB": {object("ParticipantChangeLoB")},
+	"ChangePassword":       {object("PasswordChange")},
+	"VerifyCredentials":    {object("Credentials")},
+	"ParticipantQuery":     {object("RichQuery"), optionalArg("pageSize", KindInt), optionalArg("bookmark", KindString)},
+
+	"CreditCreate":   {arg("UserID", KindString), arg("Value", KindInt)},
+	"CreditRead":     {arg("UserID", KindString)},
+	"CreditAdd":      {object("CreditAdd")},
+	"CreditDelete":   {arg("UserID", KindString)},
+	"CreditTransfer": {object("CreditTransfer")},
+	"CreditReverse":  {object("CreditReverse")},
+	"CreditJournal":  {arg("UserID", KindString), optionalArg("pageSize", KindInt), optionalArg("bookmark", KindString)},
+	"CreditVerify":   {arg("UserID", KindString)},
+	"TopTenCredit":   {},
+
+	"LoBReadAll": {},
+	"LoBRead":    {arg("LoBID", KindInt)},
+	"LoBFund":    {object("LoBFund")},
+	"LoBCreate":  {object("LoBInit")},
+	"LoBRename":  {object("LoBRename")},
+	"LoBArchive": {arg("LoBID", KindInt)},
+	"LoBMove":    {object("LoBMove")},
+
+	"TicketCreate":           {object("TicketInit")},
+	"TicketRead":             {arg("TicketID", KindString)},
+	"TicketRead2":            {},
+	"TicketList":             {optionalObject("TicketFilter"), optionalArg("pageSize", KindInt), optionalArg("bookmark", KindString)},
+	"TicketQuery":            {object("RichQuery"), optionalArg("pageSize", KindInt), optionalArg("bookmark", KindString)},
+	"TicketUpdate":           {object("Ticket")},
+	"AutoUpdateTicketStatus": {arg("TicketID", KindString)},
+	"TicketDelete":           {arg("TicketID", KindString)},
+	"ExpireTickets":          {},
+	"EscrowRead":             {arg("TicketID", KindString)},
+
+	"OrderCreate": {object("OrderInit")},
+	"OrderRead":   {arg("TicketID", KindString), arg("UserID", KindString)},
+	"OrderRead2":  {arg("TicketID", KindString)},
+	"OrderUpdate": {object("OrderUpdate")},
+	"OrderQuery":  {object("RichQuery"), optionalArg("pageSize", KindInt), optionalArg("bookmark", KindString)},
+
+	"GetHistory": {arg("Entity", KindString), arg("ID", KindString), optionalObject("HistoryRange"),
+		optionalArg("pageSize", KindInt), optionalArg("bookmark", KindString)},
+
+	"MigrateKeys":     {},
+	"AuditInvariants": {},
+	"Reconcile":       {},
+}
+
+// Helper: OrderUpdate takes a UserID array per action of orderTransitions
+func orderUpdateFields() []fieldSpec {
+	fields := []fieldSpec{id("TicketID")}
+	for _, transition := range orderTransitions {
+		fields = append(fields, optionalField(transition.Action, KindStringArray))
+	}
+	return fields
+}
+
+// Helper: BadRequest error of function, every problem is also listed in the details
+func badRequest(function string, problems ...string) *ChaincodeError {
+	return newError(ErrBadRequest, "Bad request: "+function+" "+strings.Join(problems, "; ")).with(problems...)
+}
+
+func unknownFunction(function string) *ChaincodeError {
+	return newError(ErrUnknownFunction, "Received unknown function invocation "+function).on("Function", function)
+}
+
+// Helper: check args against the signature of function, before access control and routing
+func validateArgs(function string, args []string) error {
+	specs, ok := signatureTable[function]
+	if !ok {
+		return unknownFunction(function)
+	}
+	if len(args) > len(specs) {
+		return badRequest(function, "takes at most "+strconv.Itoa(len(specs))+" arguments, got "+strconv.Itoa(len(args)))
+	}
+	for i, spec := range specs {
+		if i >= len(args) || (spec.Optional && args[i] == "") {
+			if spec.Optional {
+				continue
+			}
+			return badRequest(function, "missing argument "+spec.Name)
+		}
+		problems := validateArg(spec, args[i])
+		if len(problems) > 0 {
+			return badRequest(function, problems...)
+		}
+	}
+	return nil
+}
+
+// Helper: what is wrong with a positional argument, nil if nothing
+func validateArg(spec argSpec, value string) []string {
+	switch spec.Kind {
+	case KindString:
+		if value == "" {
+			return []string{"argument " + spec.Name + " must not be empty"}
+		}
+	case KindInt:
+		_, err := strconv.Atoi(value)
+		if err != nil {
+			return []string{"argument " + spec.Name + " must be an integer"}
+		}
+	case KindObject:
+		return validateObject(spec.Schema, value)
+	}
+	return nil
+}
+
+// Helper: check a JSON object against a schema, every failing field is reported
+func validateObject(schema string, value string) []string {
+	var raw map[string]json.RawMessage
+	if json.Unmarshal([]byte(value), &raw) != nil || raw == nil {
+		return []string{"argument " + schema + " must be a JSON object"}
+	}
+
+	var problems []string
+	known := make(map[string]bool)
+	for _, field := range schemas[schema] {
+		known[field.Name] = true
+		fieldValue, ok := raw[field.Name]
+		if !ok {
+			if !field.Optional {
+				problems = append(problems, "missing field "+field.Name)
+			}
+			continue
+		}
+		problem := validateField(field, fieldValue)
+		if problem != "" {
+			problems = append(problems, "field "+field.Name+" "+problem)
+		}
+	}
+
+	var unknown []string
+	for name := range raw {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		problems = append(problems, "unknown field "+name)
+	}
+
+	return problems
+}
+
+// Helper: what is wrong with a JSON value of field, empty if nothing
+func validateField(field fieldSpec, value json.RawMessage) string {
+	// null unmarshals into anything
+	if bytes.Equal(bytes.TrimSpace(value), []byte("null")) {
+		if field.Kind == KindStringArray {
+			return ""
+		}
+		return "must be " + kindName(field.Kind)
+	}
+
+	switch field.Kind {
+	case KindString:
+		var s string
+		if json.Unmarshal(value, &s) != nil {
+			return "must be " + kindName(field.Kind)
+		}
+		n := utf8.RuneCountInString(s)
+		if n < field.MinLength {
+			if field.MinLength == 1 {
+				return "must not be empty"
+			}
+			return "must be at least " + strconv.Itoa(field.MinLength) + " characters"
+		}
+		if field.MaxLength > 0 && n > field.MaxLength {
+			return "must be at most " + strconv.Itoa(field.MaxLength) + " characters"
+		}
+		if field.Format == "date-time" {
+			_, err := string2time(s)
+			if err != nil {
+				return "must be an RFC 3339 date-time"
+			}
+		}
+	case KindInt:
+		var i int
+		if json.Unmarshal(value, &i) != nil {
+			return "must be " + kindName(field.Kind)
+		}
+		if field.Minimum != nil && i < *field.Minimum {
+			return "must be at least " + strconv.Itoa(*field.Minimum)
+		}
+		if field.Maximum != nil && i > *field.Maximum {
+			return "must be at most " + strconv.Itoa(*field.Maximum)
+		}
+	case KindBool:
+		var b bool
+		if json.Unmarshal(value, &b) != nil {
+			return "must be " + kindName(field.Kind)
+		}
+	case KindStringArray:
+		var a []string
+		if json.Unmarshal(value, &a) != nil {
+			return "must be " + kindName(field.Kind)
+		}
+	case KindObject:
+		var o map[string]json.RawMessage
+		if json.Unmarshal(value, &o) != nil {
+			return "must be " + kindName(field.Kind)
+		}
+	}
+	return ""
+}
+
+func kindName(kind int) string {
+	switch kind {
+	case KindString:
+		return "a string"
+	case KindInt:
+		return "an integer"
+	case KindBool:
+		return "a boolean"
+	case KindStringArray:
+		return "an array of strings"
+	}
+	return "a JSON object"
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+	"gopkg.in/yaml.v2"
+)
+
+func TestSignatureTableCoversAccessTable(t *testing.T) {
+	for function := range accessTable {
+		if _, ok := signatureTable[function]; !ok {
+			t.Errorf("%s has an access rule but no signature", function)
+		}
+	}
+	for function := range signatureTable {
+		if _, ok := accessTable[function]; !ok {
+			t.Errorf("%s has a signature but no access rule", function)
+		}
+	}
+}
+
+func TestMalformedCallsAreBadRequests(t *testing.T) {
+	c := newExchain(t)
+
+	for function, specs := range signatureTable {
+		// no arguments at all
+		response := c.invoke(admin, function)
+		required := len(specs) > 0 && !specs[0].Optional
+		if required && !isBadRequest(t, response, function+" missing argument") {
+			t.Errorf("%s without arguments: %d %s", function, response.Status, response.Message)
+		}
+
+		// one argument too many
+		args := make([]string, len(specs)+1)
+		for i := range args {
+			args[i] = "x"
+		}
+		response = c.invoke(admin, function, args...)
+		if !isBadRequest(t, response, function+" takes at most") {
+			t.Errorf("%s with too many arguments: %d %s", function, response.Status, response.Message)
+		}
+
+		// an argument of the wrong kind
+		for i, spec := range specs {
+			if spec.Kind == KindString {
+				continue
+			}
+			args = make([]string, i+1)
+			for j := range args {
+				args[j] = "x"
+			}
+			args[i] = "not valid"
+			response = c.invoke(admin, function, args...)
+			if !isBadRequest(t, response, function+" ") {
+				t.Errorf("%s with an invalid %s: %d %s", function, spec.Name, response.Status, response.Message)
+			}
+		}
+	}
+}
+
+func isBadRequest(t *testing.T, response peer.Response, prefix string) bool {
+	if response.Status == shim.OK {
+		return false
+	}
+	e := chaincodeError(t, response)
+	return e.Code == ErrBadRequest && e.Status == 400 && strings.HasPrefix(e.Message, "Bad request: "+prefix)
+}
+
+func TestJSONFieldKinds(t *testing.T) {
+	c := newExchain(t)
+
+	c.mustFail("Bad request: CreditAdd field value must be an integer", admin, "CreditAdd",
+		`{"userID": "`+applicant+`", "value": "10", "ticketID": "creditADD"}`)
+	c.mustFail("Bad request: CreditAdd field value must be an integer", admin, "CreditAdd",
+		`{"userID": "`+applicant+`", "value": 1.5, "ticketID": "creditADD"}`)
+	c.mustFail("Bad request: CreditAdd field userID must be a string", admin, "CreditAdd",
+		`{"userID": 3, "value": 10, "ticketID": "creditADD"}`)
+	c.mustFail("Bad request: CreditAdd field ticketID must be a string", admin, "CreditAdd",
+		`{"userID": "`+applicant+`", "value": 10, "ticketID": null}`)
+	c.mustFail("Bad request: addParticipant field Participant_IsAdmin must be a boolean", "i000005", "addParticipant",
+		`{"Participant_UserID": "i000005", "Participant_UserName": "x", "Participant_IsAdmin": "no", "Participant_LoBID": 1}`)
+	c.mustFail("Bad request: OrderUpdate field Close must be an array of strings", owner, "OrderUpdate",
+		`{"TicketID": "t", "Close": [1, 2]}`)
+	c.mustFail("Bad request: readParticipant argument UserID must not be empty", "", "readParticipant", "")
+
+	// optional trailing arguments may be left empty
+	c.mustInvoke("", "CreditJournal", applicant, "", "")
+	c.mustInvoke(admin, "CreditAdd", `{"userID": "`+applicant+`", "value": 10, "ticketID": "creditADD"}`)
+	if c.credit(applicant) != 10 {
+		t.Errorf("unexpected credit %d", c.credit(applicant))
+	}
+}
+
+// yamlSchema - the parts of a swagger definition the schemas are compared on
+type yamlSchema struct {
+	Type                 string   `yaml:"type"`
+	Required             []string `yaml:"required"`
+	AdditionalProperties *bool    `yaml:"additionalProperties"`
+	Properties           map[string]struct {
+		Type      string `yaml:"type"`
+		Format    string `yaml:"format"`
+		Minimum   *int   `yaml:"minimum"`
+		Maximum   *int   `yaml:"maximum"`
+		MinLength int    `yaml:"minLength"`
+		MaxLength int    `yaml:"maxLength"`
+		Items     struct {
+			Type string `yaml:"type"`
+		} `yaml:"items"`
+	} `yaml:"properties"`
+}
+
+func TestSchemasMatchTicketYAML(t *testing.T) {
+	var swagger struct {
+		Definitions map[string]yamlSchema `yaml:"definitions"`
+	}
+	bytes, err := ioutil.ReadFile("ticket.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = yaml.Unmarshal(bytes, &swagger)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	yamlTypes := map[int]string{KindString: "string", KindInt: "integer", KindBool: "boolean", KindStringArray: "array", KindObject: "object"}
+	sameBound := func(a *int, b *int) bool {
+		return (a == nil && b == nil) || (a != nil && b != nil && *a == *b)
+	}
+	for name, fields := range schemas {
+		definition, ok := swagger.Definitions[name]
+		if !ok {
+			t.Errorf("ticket.yaml has no definition %s", name)
+			continue
+		}
+		if definition.AdditionalProperties == nil || *definition.AdditionalProperties {
+			t.Errorf("%s: ticket.yaml must set additionalProperties: false", name)
+		}
+		var required []string
+		for _, field := range fields {
+			if !field.Optional {
+				required = append(required, field.Name)
+			}
+			property, ok := definition.Properties[field.Name]
+			if !ok {
+				t.Errorf("%s: ticket.yaml has no property %s", name, field.Name)
+				continue
+			}
+			if property.Type != yamlTypes[field.Kind] || (field.Kind == KindStringArray && property.Items.Type != "string") {
+				t.Errorf("%s.%s: type %s in ticket.yaml", name, field.Name, property.Type)
+			}
+			if !sameBound(property.Minimum, field.Minimum) || !sameBound(property.Maximum, field.Maximum) ||
+				property.MinLength != field.MinLength || property.MaxLength != field.MaxLength || property.Format != field.Format {
+				t.Errorf("%s.%s: constraints differ from ticket.yaml", name, field.Name)
+			}
+		}
+		if len(definition.Properties) != len(fields) {
+			t.Errorf("%s: ticket.yaml has %d properties, the schema %d", name, len(definition.Properties), len(fields))
+		}
+		if strings.Join(definition.Required, ",") != strings.Join(required, ",") {
+			t.Errorf("%s: required %v in ticket.yaml, %v in the schema", name, definition.Required, required)
+		}
+	}
+}
+
+func TestSchemaErrorsNameEveryField(t *testing.T) {
+	c := newExchain(t)
+
+	c.mustFail("Bad request: TicketCreate field Ticket_Value must be at least 0; field Ticket_UserID must not be empty; unknown field Ticket_Owner",
+		owner, "TicketCreate", `{"Ticket_Title": "t", "Ticket_Type": 0, "Ticket_Value": -1, "Ticket_UserID": "", "Ticket_Owner": "x"}`)
+	c.mustFail("Participant_LoBID 8 is not an active LoB", "i000005", "addParticipant",
+		`{"Participant_UserID": "i000005", "Participant_UserName": "x", "Participant_IsAdmin": false, "Participant_LoBID": 8}`)
+	c.mustFail("field Participant_LoBID must be at least 0", "i000005", "addParticipant",
+		`{"Participant_UserID": "i000005", "Participant_UserName": "x", "Participant_IsAdmin": false, "Participant_LoBID": -1}`)
+	c.mustFail("field Ticket_Title must be at most 256 characters", owner, "TicketCreate",
+		`{"Ticket_Title": "`+strings.Repeat("x", 257)+`", "Ticket_Type": 0, "Ticket_Value": 1, "Ticket_UserID": "`+owner+`"}`)
+	c.mustFail("field Ticket_Deadline must be an RFC 3339 date-time", owner, "TicketCreate",
+		`{"Ticket_Title": "t", "Ticket_Type": 0, "Ticket_Value": 1, "Ticket_UserID": "`+owner+`", "Ticket_Deadline": "2018-11-26 18:05:00"}`)
+	c.mustFail("field Value must be at least 1", applicant, "CreditTransfer", `{"From": "`+applicant+`", "To": "`+owner+`", "Value": 0}`)
+	c.mustFail("unknown field Participant_Password", owner, "updateParticipant",
+		`{"Participant_UserID": "`+owner+`", "Participant_UserName": "x", "Participant_Password": "x", "Participant_IsAdmin": false, "Participant_LoBID": 1}`)
+
+	// a field name inside a value is not the field
+	c.mustFail("missing field UserID", applicant, "OrderCreate", `{"TicketID": "\"UserID\""}`)
+
+	// multi-byte characters count once
+	c.mustInvoke(owner, "TicketCreate",
+		`{"Ticket_Title": "`+strings.Repeat("ü", 256)+`", "Ticket_Type": 0, "Ticket_Value": 1, "Ticket_UserID": "`+owner+`", "Ticket_Deadline": "2030-01-01T00:00:00Z"}`)
+}
@@ -5,7 +5,6 @@ import (
 	"errors"
 	"fmt"
 	"strconv"
-	"strings"
 	"time"
 	"bytes"
 	"sort"
@@ -17,13 +16,7 @@ import (
 
 var logger = shim.NewLogger("ExchainChaincode")
 
-var TICKETID = 0
-
-// declare Lob_Name array to translate LoBID(int) into corresponding string name
-const NumberOfLoBs = 8
-var Lob_Name = [NumberOfLoBs]string{"MD_office", "HANA", "SMB", "IBS", "S4_HANA", "GS", "SF", "IoT"}
-
-//Enum of LOBs
+//LoBIDs of the LoBs seeded by Init (defaultLoBs), further LoBs are added with LoBCreate (lob_registry.go)
 const(
 	MD_office = iota
 	HANA
@@ -33,7 +26,6 @@ const(
 	GS
 	SF
 	IoT
-	//numberOfLoBs
 )
 
 const(
@@ -41,35 +33,46 @@ const(
 	P1
 )
 
-//TicketID status
-//Created   ->  Applied  -> Ongoing  ->   Done
+//TicketID status, derived from its orders (order_status.go)
+//Created   ->  Applied  -> Ongoing  ->   Done  ->  Awarded
+//Expired is set by ExpireTickets once the deadline is over (deadline.go) and is final
 const(
 	Created = iota
 	Applied
 	Ongoing
 	Done
+	Awarded
+	Expired
 )
 
 //Participant information
 //   UserID:        iXXXXXX
 //   UserName:      Bill Xu
-//   Password:      *********
+//   Password:      plaintext of records written before password.go, only read by the migration of Init
 //   IsAdmin:       True or False
-//   LoB:           0. MD_office  1. HANA  2. SMB...
+//   LoB:           LoBID of an active LoB of the registry, 0. MD_office  1. HANA  2. SMB...
+//   MSPID:         MSP the participant enrolled with, e.g. Org1MSP
+//   CreditBase:    credit left behind in former LoBs, not counted in the LoB_TotalCredit of LoBID (participant_lob.go)
+//   LoBChange:     last move by ParticipantChangeLoB, each version in GetHistory keeps the move that made it
+//   Deactivated:   soft-deleted by deleteParticipant, the participant can no longer sign in or submit transactions
 type Participant struct {
 	UserID		string 		`json:"Participant_UserID"`
 	UserName    string 		`json:"Participant_UserName"`
-	Password    string  	`json:"Participant_Password"`
+	Password    string  	`json:"Participant_Password,omitempty"`
 
 	IsAdmin     bool 		`json:"Participant_IsAdmin"`
 	LoBID		int     	`json:"Participant_LoBID"`
+	MSPID		string		`json:"Participant_MSPID"`
+	CreditBase	int			`json:"Participant_CreditBase,omitempty"`
+	LoBChange	*LoBChange	`json:"Participant_LoBChange,omitempty"`
+	Deactivated	bool		`json:"Participant_Deactivated,omitempty"`
 }
 
 //Credit infomation
 //UserID:     iXXXXXX
 //Value:      123
 //TicketIDs:  1. TicketNumber
-//            2. Ticket array
+//            2. Ticket array, tickets already credited; every movement is journaled (journal.go)
 type Credit struct {
 	UserID		string  	`json:"Credit_UserID"`
 	Value       int     	`json:"Credit_Value"`
@@ -90,9 +93,24 @@ func (s Credits) Less(i, j int) bool {    // 重写 Less() 方法
 	return s[i].Value > s[j].Value
 }
 
+// LoB information, the registry of LoBs (lob_registry.go)
+//Name:             unique among all LoBs, changed with LoBRename
+//Archived:         set by LoBArchive, the LoB takes no new participants
+//ParentID:         LoB this one is a sub-unit of, nil for a top-level LoB (lob_tree.go)
+//TotalCredit:      sum of the credit of its participants, plus RetainedCredit
+//RetainedCredit:   credit former participants earned here and left behind (participant_lob.go)
+//RolledUpCredit:   TotalCredit of the LoB and of all its sub-LoBs
+//Budget:           credit the LoB can lock into the escrow of its participants' tickets, see LoBFund
+
 type LoB struct{
 	LoBID			int				`json:"LoB_LoBID"`
+	Name			string			`json:"LoB_Name"`
+	Archived		bool			`json:"LoB_Archived"`
+	ParentID		*int			`json:"LoB_ParentID,omitempty"`
 	TotalCredit 	int				`json:"LoB_TotalCredit"`
+	RetainedCredit	int				`json:"LoB_RetainedCredit,omitempty"`
+	RolledUpCredit	int				`json:"LoB_RolledUpCredit"`
+	Budget			int				`json:"LoB_Budget"`
 
 	UserIDs 		[]string 		`json:"LoB_UserIDs"`
 }
@@ -110,6 +128,10 @@ type LoB struct{
 //DeadLine:
 //Comment:
 //Policy:
+//Capacity:    seats for confirmed orders, applications to a full ticket are waitlisted; 0 is unlimited
+//Award:       how Value is split among the awarded users (award_policy.go), equally if nil
+//Awarded:     credit awarded so far, never more than Value
+//Funding:     where Value is locked from at creation (escrow.go), awards are new credit if empty
 
 type Ticket struct {
 	TicketID	string 		`json:"Ticket_TicketID"`
@@ -124,15 +146,21 @@ type Ticket struct {
 	DeadLine	time.Time 	`json:"Ticket_Deadline"`
 	Comment		string     	`json:"Ticket_Comment"`
 	Policy		string 		`json:"Ticket_Policy"`
+	Capacity	int			`json:"Ticket_Capacity"`
+	Award		*AwardPolicy	`json:"Ticket_Award,omitempty"`
+	Awarded		int			`json:"Ticket_Awarded"`
+	Funding		string		`json:"Ticket_Funding,omitempty"`
 }
 // Order information
 // TicketID:
 // UserID:           iXXXXXX
-// Status:           Created   ->  Applied  -> Ongoing  ->   Done
+// Status:           Applied  ->  Confirmed  ->  Done  ->  Awarded, see orderTransitions
+// Position:         place on the waitlist of a full ticket, 0 once the order is not waitlisted
 type Order struct {
 	TicketID 	string		`json:"TicketID"`
 	UserID		string		`json:"UserID"`
 	Status		int			`json:"Status"`
+	Position	int			`json:"Position,omitempty"`
 }
 
 //SmartContract - Chaincode for asset Reading
@@ -156,42 +184,66 @@ func main() {
 //Init - The chaincode Init function: No arguments, only initializes a ID array as Index for retrieval of all Readings
 func (rdg *SmartContract) Init(stub shim.ChaincodeStubInterface) peer.Response {
 
-	//UseIDs, different LoB info and TICKETID are persistent
+	//UseIDs, different LoB info and tickets are persistent
 
-	var readingIDs ReadingIDIndex
-	bytes, _ := stub.GetState("readingIDIndex")
-	if (len(bytes) == 0) {
-		bytes, _ := json.Marshal(readingIDs)
-		stub.PutState("readingIDIndex", bytes)
-	} else {
-	     stub.PutState("readingIDIndex", bytes)
+	// ==== The steps below read what the steps before wrote in this transaction (keys.go) ====
+	stub = newPendingStub(stub)
+
+	// ==== Roll up the LoB totals of records stored before the LoB tree, before anything is written ====
+	rolledUp, err := rollUpLoBs(stub)
+	if err != nil {
+		return errorResponse(err)
 	}
-	logger.Info("Func------Init----Get readingIDIndex" + string(bytes))
+	logger.Info("Func------Init----Rolled up LoBs", rolledUp)
+
+	// ==== Seed the default LoBs, records migrated below replace them ====
+	seeded, err := seedLoBs(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	logger.Info("Func------Init----Seeded LoBs", seeded)
+
+	// ==== Move records stored under plain keys to their composite keys (keys.go) ====
+	report, err := migrateKeys(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	logger.Info("Func------Init----Migrated keys", report)
+
+	// ==== Open the journal of credits stored before it (journal.go) ====
+	opened, err := migrateJournal(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	logger.Info("Func------Init----Opened journals", opened)
+
+	// ==== Orders closed before the transition table stored status 0 (order_status.go) ====
+	closed, err := migrateOrders(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	logger.Info("Func------Init----Migrated closed orders", closed)
 
-	var LobTemp LoB
-	iter := 0
-	for iter < NumberOfLoBs {
-		bytes, _ := stub.GetState(Lob_Name[iter])
-		if (len(bytes) == 0) {
-			LobTemp.LoBID = iter
-			LobTemp.TotalCredit = 0
-			bytes, _ := json.Marshal(LobTemp)
-			stub.PutState(Lob_Name[iter], bytes)
-		} else {
-			 stub.PutState(Lob_Name[iter], bytes)
+	// ==== An empty index on a new ledger, migrateKeys keeps an existing one ====
+	bytes, err := stub.GetState("readingIDIndex")
+	if err != nil {
+		return errorResponse(errInternal("Init: Error getting readingIDIndex array"))
+	}
+	if len(bytes) == 0 {
+		bytes, _ = json.Marshal(ReadingIDIndex{UserIDs: []string{}})
+		err = stub.PutState("readingIDIndex", bytes)
+		if err != nil {
+			return errorResponse(errInternal("Init: Error storing readingIDIndex"))
 		}
-		logger.Info("Func------Init----Get LoB info" + string(bytes))
-		iter = iter + 1
 	}
+	logger.Info("Func------Init----Get readingIDIndex" + string(bytes))
 
-	indexbytes, _ := stub.GetState("TICKETID")
-	if len(indexbytes) == 0 {
-		indexbytes, _ := json.Marshal(TICKETID)
-		stub.PutState("TICKETID", indexbytes)
-	} else {
-	  	stub.PutState("TICKETID", indexbytes)
- 	}
-	logger.Info("Func------Init----Get TICKETID" + string(indexbytes))
+	// ==== Move plaintext passwords of existing participants out of their records ====
+	migrated, err := migratePasswords(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	logger.Info("Func------Init----Migrated passwords", migrated)
 
 	return shim.Success(nil)
 }
@@ -201,6 +253,32 @@ func (rdg *SmartContract) Invoke(stub shim.ChaincodeStubInterface) peer.Response
 	function, args := stub.GetFunctionAndParameters()
 	logger.Info(" ****** Invoke: function: ", function)
 
+	// ==== Reject malformed calls before they reach a route (signature.go) ====
+	err := validateArgs(function, args)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	// ==== Enforce the access rule of the route against the caller ====
+	err = checkAccess(stub, function, args)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	// ==== Events are only set once the route succeeded ====
+	events := newEventStub(stub)
+	response := rdg.route(events, function, args)
+	if response.Status == shim.OK {
+		err = events.flush()
+		if err != nil {
+			return errorResponse(err)
+		}
+	}
+	return response
+}
+
+//Helper: dispatch an Invoke function to its route
+func (rdg *SmartContract) route(stub shim.ChaincodeStubInterface, function string, args []string) peer.Response {
 	switch function{
 	//Participant Read Delete Update Add
 	case "addParticipant":
@@ -212,7 +290,13 @@ func (rdg *SmartContract) Invoke(stub shim.ChaincodeStubInterface) peer.Response
 	case "updateParticipant":
 		return rdg.updateParticipant(stub, args)
 	case "deleteParticipant":
-		return rdg.deleteParticipant(stub, args[0])
+		return rdg.deleteParticipant(stub, args)
+	case "ChangePassword":
+		return rdg.ChangePassword(stub, args)
+	case "VerifyCredentials":
+		return rdg.VerifyCredentials(stub, args)
+	case "ParticipantQuery":
+		return rdg.richQuery(stub, "ParticipantQuery", args)
 
 	//Credit Read Delete Update Add
 	case "CreditCreate":
@@ -223,14 +307,34 @@ func (rdg *SmartContract) Invoke(stub shim.ChaincodeStubInterface) peer.Response
 		return rdg.CreditAdd(stub, args)
 	case "CreditDelete":
 		return rdg.CreditDelete(stub, args[0])
+	case "CreditTransfer":
+		return rdg.CreditTransfer(stub, args)
+	case "CreditReverse":
+		return rdg.CreditReverse(stub, args)
+	case "CreditJournal":
+		return rdg.CreditJournal(stub, args)
+	case "CreditVerify":
+		return rdg.CreditVerify(stub, args[0])
 	case "TopTenCredit":
 		return rdg.TopTenCredit(stub)
 
-	// Lob Read
+	// Lob Read and registry
 	case "LoBReadAll":
 		return rdg.LoBReadAll(stub)
 	case "LoBRead":
 		return rdg.LoBRead(stub, args[0])
+	case "LoBFund":
+		return rdg.LoBFund(stub, args)
+	case "LoBCreate":
+		return rdg.LoBCreate(stub, args)
+	case "LoBRename":
+		return rdg.LoBRename(stub, args)
+	case "LoBArchive":
+		return rdg.LoBArchive(stub, args[0])
+	case "LoBMove":
+		return rdg.LoBMove(stub, args)
+	case "ParticipantChangeLoB":
+		return rdg.ParticipantChangeLoB(stub, args)
 
 	//Ticket Read Delete Update Add
 	case "TicketCreate":
@@ -239,12 +343,20 @@ func (rdg *SmartContract) Invoke(stub shim.ChaincodeStubInterface) peer.Response
 		return rdg.TicketRead(stub, args[0])
 	case "TicketRead2":
 		return rdg.TicketRead2(stub)
+	case "TicketList":
+		return rdg.TicketList(stub, args)
+	case "TicketQuery":
+		return rdg.richQuery(stub, "TicketQuery", args)
 	case "TicketUpdate":
 		return rdg.TicketUpdate(stub, args)
 	case "AutoUpdateTicketStatus":
 		return rdg.AutoUpdateTicketStatus(stub, args[0])
 	case "TicketDelete":
 		return rdg.TicketDelete(stub, args[0])
+	case "ExpireTickets":
+		return rdg.ExpireTickets(stub)
+	case "EscrowRead":
+		return rdg.EscrowRead(stub, args[0])
 
 	//Order Read Delete Update Add
 	case "OrderCreate":
@@ -257,32 +369,30 @@ func (rdg *SmartContract) Invoke(stub shim.ChaincodeStubInterface) peer.Response
 		return rdg.OrderRead2(stub, args)
 	case "OrderUpdate":
 		return rdg.OrderUpdate(stub, args)
+	case "OrderQuery":
+		return rdg.richQuery(stub, "OrderQuery", args)
+
+	//History of any entity (history.go)
+	case "GetHistory":
+		return rdg.GetHistory(stub, args)
+
+	case "MigrateKeys":
+		return rdg.MigrateKeys(stub)
 
-        //Get HistoryTicket
-	case "history":
-		return rdg.TestGetHistoryTicket(stub, args)
+	//Ledger invariants (invariants.go)
+	case "AuditInvariants":
+		return rdg.AuditInvariants(stub)
+	case "Reconcile":
+		return rdg.Reconcile(stub)
 	default:
 		logger.Error("Received unknown function invocation: ", function)
 	}
-	return shim.Error("Received unknown function invocation")
+	return errorResponse(unknownFunction(function))
 }
 
 //getReadingFromArgs - construct a reading structure from string array of arguments
+//the input was validated by Invoke against the Participant / ParticipantUpdate schema (signature.go)
 func getParticipantFromArgs(args []string) (participant Participant, err error) {
-        //check inputs!
-	//  json:"Participant_UserID"
-	//  json:"Participant_UserName"
-	//  json:"Participant_Password"
-	//  json:"Participant_IsAdmin"
-	//  json:"Participant_LoB"
-	if  strings.Contains(args[0], "\"Participant_UserName\"") == false ||
-		strings.Contains(args[0], "\"Participant_UserID\"")   == false ||
-		strings.Contains(args[0], "\"Participant_Password\"") == false ||
-		strings.Contains(args[0], "\"Participant_IsAdmin\"")  == false ||
-		strings.Contains(args[0], "\"Participant_LoBID\"")      == false   {
-		return participant, errors.New("Unknown field: Input JSON does not comply to schema")
-	}
-
 	err = json.Unmarshal([]byte(args[0]), &participant)
 	if err != nil {
 		return participant, err
@@ -294,38 +404,90 @@ func getParticipantFromArgs(args []string) (participant Participant, err error)
 func (rdg *SmartContract) addParticipant(stub shim.ChaincodeStubInterface, args []string) peer.Response {
 	//get Participant
 	participant, err := getParticipantFromArgs(args)
-	logger.Info("Func------addParticipant----Participant.LoBID" + string(participant.LoBID))
+	logger.Info("Func------addParticipant----Participant.LoBID" + strconv.Itoa(participant.LoBID))
 
 	if err != nil {
-		return shim.Error("Reading participant is Corrupted")
+		return errorResponse(badRequest("addParticipant", "Reading participant is Corrupted"))
+	}
+
+	// ==== Bind the participant to the MSP of the registering identity ====
+	mspID, callerID, err := getClientIdentity(stub)
+	if err != nil {
+		return errorResponse(forbidden("addParticipant", err.Error()))
+	}
+	if callerID == participant.UserID {
+		participant.MSPID = mspID
+		// only the very first admin may register itself
+		if participant.IsAdmin {
+			adminExists, err := hasAdmin(stub)
+			if err != nil {
+				return errorResponse(err)
+			}
+			if adminExists {
+				return errorResponse(forbidden("addParticipant", "can not self-register an admin participant"))
+			}
+		}
+	} else if participant.MSPID == "" {
+		participant.MSPID = mspID
+	} else if participant.MSPID != mspID {
+		// an admin registers others from its own MSP, or from one a registered participant already enrolled with
+		known, err := knownMSP(stub, participant.MSPID)
+		if err != nil {
+			return errorResponse(err)
+		}
+		if !known {
+			return errorResponse(badRequest("addParticipant", "Participant_MSPID "+participant.MSPID+" is not the MSP of a registered participant"))
+		}
+	}
+	participant.CreditBase, participant.LoBChange, participant.Deactivated = 0, nil, false
+	err = checkParticipantLoB(stub, "addParticipant", participant.LoBID)
+	if err != nil {
+		return errorResponse(err)
 	}
 	//check Participant exists or not
-	record, err := stub.GetState(participant.UserID)
+	record, err := stub.GetState(participantKey(stub, participant.UserID))
+	if err != nil {
+		return errorResponse(errInternal("addParticipant: Error getting participant with ID: " + participant.UserID))
+	}
 	if record != nil {
-		return shim.Error("This participant already exists: " + participant.UserID)
+		return errorResponse(errAlreadyExists(ParticipantObjectType, participant.UserID))
+	}
+
+	// ==== The password comes in the transient map and is kept hashed in a private record, never in the participant ====
+	password, err := transientPassword(stub, "addParticipant", "Password")
+	if err != nil {
+		return errorResponse(err)
+	}
+	salt, err := transientSalt(stub, "addParticipant")
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = savePassword(stub, participant.UserID, password, salt)
+	if err != nil {
+		return errorResponse(err)
 	}
 
 	//if not exists, save
 	participantAsBytes, err := rdg.saveParticipant(stub, participant)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(err)
 	}
 
 	err = CreditInit(stub, participant.UserID, 0)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(err)
 	}
 
 	// updata LoB UserIDs array
 	_, err = rdg.updateLoBUsers(stub, participant)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(err)
 	}
 
 	// update the ID index of
 	_, err = rdg.updateReadingIDIndex(stub, participant)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(err)
 	}
 	return shim.Success(participantAsBytes)
 }
@@ -334,11 +496,11 @@ func (rdg *SmartContract) addParticipant(stub shim.ChaincodeStubInterface, args
 func (rdg *SmartContract) saveParticipant(stub shim.ChaincodeStubInterface, participant Participant) ([]byte, error) {
 	bytes, err := json.Marshal(participant)
 	if err != nil {
-		return bytes, errors.New("Error converting reading record JSON")
+		return bytes, errInternal("saveParticipant: Error converting reading record JSON")
 	}
-	err = stub.PutState(participant.UserID, bytes)
+	err = stub.PutState(participantKey(stub, participant.UserID), bytes)
 	if err != nil {
-		return bytes, errors.New("Error storing Reading record")
+		return bytes, errInternal("saveParticipant: Error storing Reading record")
 	}
 	return bytes, nil
 }
@@ -348,8 +510,8 @@ func (rdg *SmartContract) updateLoBUsers(stub shim.ChaincodeStubInterface, parti
 
 	var LoB_temp LoB
 
-	LobName := Lob_Name[participant.LoBID]
-	bytes, err := stub.GetState(LobName)
+	LobKey := lobKey(stub, participant.LoBID)
+	bytes, err := stub.GetState(LobKey)
 	if err != nil {
 		return false, errors.New("updateLoBUsers: Error getting LoB info from state")
 	}
@@ -368,7 +530,7 @@ func (rdg *SmartContract) updateLoBUsers(stub shim.ChaincodeStubInterface, parti
 		return false, errors.New("updateLoBUsers: Error marshalling new LoB info")
 	}
 
-	err = stub.PutState(LobName, bytes)
+	err = stub.PutState(LobKey, bytes)
 	if err != nil {
 		return false, errors.New("updateLoBUsers: Error storing new LoB info")
 	}
@@ -409,7 +571,7 @@ func (rdg *SmartContract) updateReadingIDIndex(stub shim.ChaincodeStubInterface,
 func (rdg *SmartContract) readParticipant(stub shim.ChaincodeStubInterface, participantID string) peer.Response {
 	participantAsByteArray, err := rdg.retrieveParticipant(stub, participantID)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(err)
 	}
 	return shim.Success(participantAsByteArray)
 }
@@ -419,15 +581,19 @@ func (rdg *SmartContract) readParticipant(stub shim.ChaincodeStubInterface, part
 func (rdg *SmartContract) retrieveParticipant(stub shim.ChaincodeStubInterface, participantID string) ([]byte, error) {
 	var participant Participant
 	var participantAsByteArray []byte
-	bytes, err := stub.GetState(participantID)
+	bytes, err := stub.GetState(participantKey(stub, participantID))
 
 	if err != nil {
 		return participantAsByteArray, errors.New("retrieveParticipant: Error retrieving participant with ID: " + participantID)
 	}
+	if bytes == nil {
+		return participantAsByteArray, errNotFound(ParticipantObjectType, participantID)
+	}
 	err = json.Unmarshal(bytes, &participant)
 	if err != nil {
 		return participantAsByteArray, errors.New("retrieveParticipant: Corrupt reading record " + string(bytes))
 	}
+	participant.Password = ""
 	participantAsByteArray, err = json.Marshal(participant)
 	if err != nil {
 		return participantAsByteArray, errors.New("readParticipant: Invalid participant Object - Not a valid JSON")
@@ -440,12 +606,12 @@ func (rdg *SmartContract) readAllParticipant(stub shim.ChaincodeStubInterface) p
 	var readingIDs ReadingIDIndex
 	bytes, err := stub.GetState("readingIDIndex")
 	if err != nil {
-		return shim.Error("readAllReadings: Error getting readingIDIndex array")
+		return errorResponse(errInternal("readAllParticipant: Error getting readingIDIndex array"))
 	}
 	logger.Info("Func------readAllParticipant----Get readingIDIndex" + string(bytes))
 	err = json.Unmarshal(bytes, &readingIDs)
 	if err != nil {
-		return shim.Error("readAllReadings: Error unmarshalling readingIDIndex array JSON")
+		return errorResponse(errInternal("readAllParticipant: Error unmarshalling readingIDIndex array JSON"))
 	}
 	result := "["
 
@@ -454,7 +620,7 @@ func (rdg *SmartContract) readAllParticipant(stub shim.ChaincodeStubInterface) p
 	for _, participantID := range readingIDs.UserIDs {
 		readingAsByteArray, err = rdg.retrieveParticipant(stub, participantID)
 		if err != nil {
-			return shim.Error("Failed to retrieve participant with ID: " + participantID)
+			return errorResponse(errInternal("readAllParticipant: Failed to retrieve participant with ID: " + participantID))
 		}
 		result += string(readingAsByteArray) + ","
 	}
@@ -466,23 +632,6 @@ func (rdg *SmartContract) readAllParticipant(stub shim.ChaincodeStubInterface) p
 	return shim.Success([]byte(result))
 }
 
-//Helper: Reading readingStruct //change template
-func (rdg *SmartContract) deleteParticipant(stub shim.ChaincodeStubInterface, participantID string) peer.Response {
-	_, err := rdg.retrieveParticipant(stub, participantID)
-	if err != nil {
-		return shim.Error(err.Error())
-	}
-	err = stub.DelState(participantID)
-	if err != nil {
-		return shim.Error(err.Error())
-	}
-	_, err = rdg.deleteReadingIDIndex(stub, participantID)
-		if err != nil {
-			return shim.Error(err.Error())
-		}
-	return shim.Success(nil)
-}
-
 //Helper: delete ID from readingStruct Holder
 func (rdg *SmartContract) deleteReadingIDIndex(stub shim.ChaincodeStubInterface, participantID string) (bool, error) {
 	var participantIDs ReadingIDIndex
@@ -496,7 +645,7 @@ func (rdg *SmartContract) deleteReadingIDIndex(stub shim.ChaincodeStubInterface,
 	}
 	participantIDs.UserIDs, err = deleteKeyFromStringArray(participantIDs.UserIDs, participantID)
 	if err != nil {
-		return false, errors.New(err.Error())
+		return false, err
 	}
 	bytes, err = json.Marshal(participantIDs)
 	if err != nil {
@@ -517,7 +666,7 @@ func deleteKeyFromStringArray(array []string, key string) (newArray []string, er
 		}
 	}
 	if len(newArray) == len(array) {
-		return newArray, errors.New("Specified Key: " + key + " not found in Array")
+		return newArray, newError(ErrNotFound, "Specified Key: " + key + " not found in Array")
 	}
 	return newArray, nil
 }
@@ -527,19 +676,43 @@ func (rdg *SmartContract) updateParticipant(stub shim.ChaincodeStubInterface, ar
 
 	var currParticipant Participant
 	newParticipant, err := getParticipantFromArgs(args)
+	if err != nil {
+		return errorResponse(badRequest("updateParticipant", err.Error()))
+	}
+
 	participantAsByteArray, err := rdg.retrieveParticipant(stub, newParticipant.UserID)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(err)
 	}
 
 	err = json.Unmarshal(participantAsByteArray, &currParticipant)
 	if err != nil {
-		return shim.Error("updateReading: Error unmarshalling readingStruct array JSON")
+		return errorResponse(errInternal("updateParticipant: Error unmarshalling participant JSON"))
+	}
+	// the enrolled MSP is bound at registration and can not be edited, passwords change via ChangePassword
+	newParticipant.MSPID = currParticipant.MSPID
+	newParticipant.Password = ""
+	newParticipant.CreditBase, newParticipant.LoBChange = currParticipant.CreditBase, currParticipant.LoBChange
+	newParticipant.Deactivated = currParticipant.Deactivated
+	// ==== The LoB totals and members move with ParticipantChangeLoB only ====
+	if newParticipant.LoBID != currParticipant.LoBID {
+		return errorResponse(badRequest("updateParticipant", "Participant_LoBID changes with ParticipantChangeLoB"))
+	}
+
+	// ==== Only admins grant or revoke admin rights ====
+	if newParticipant.IsAdmin != currParticipant.IsAdmin {
+		caller, err := getCaller(stub)
+		if err != nil {
+			return errorResponse(forbidden("updateParticipant", err.Error()))
+		}
+		if !caller.Participant.IsAdmin {
+			return errorResponse(forbidden("updateParticipant", "requires an admin participant to change Participant_IsAdmin"))
+		}
 	}
 
 	_, err = rdg.saveParticipant(stub, newParticipant)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(err)
 	}
 	return shim.Success(nil)
 }
@@ -549,18 +722,36 @@ func (rdg *SmartContract) CreditCreate(stub shim.ChaincodeStubInterface, args []
 	// ==== Check whether the participant already exsites. ====
 	// todo
 	// checke wether credit already exsites.
-	record, err := stub.GetState("Credit_UerID_"+args[0])
-
+	record, err := stub.GetState(creditKey(stub, args[0]))
+	if err != nil {
+		return errorResponse(errInternal("CreditCreate: Error getting credit of " + args[0]))
+	}
 	if record != nil {
-		return shim.Error("This Credit" + args[0] + " credit has already existed.")
+		return errorResponse(errAlreadyExists(CreditObjectType, args[0]))
 	}
 
 	userID := args[0]
 	value, err := strconv.Atoi(args[1])
+	if err != nil {
+		return errorResponse(badRequest("CreditCreate", "value must be an integer"))
+	}
 
 	err = CreditInit(stub, userID, value)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(err)
+	}
+
+	// ==== The initial balance opens the journal ====
+	if value != 0 {
+		timestamp, err := txTime(stub)
+		if err != nil {
+			return errorResponse(err)
+		}
+		err = saveJournalEntry(stub, JournalEntry{UserID: userID, TxID: stub.GetTxID(), Timestamp: timestamp,
+			Delta: value, Balance: value, Reason: JournalOpening}, 0)
+		if err != nil {
+			return errorResponse(err)
+		}
 	}
 
 	return shim.Success(nil)
@@ -578,7 +769,7 @@ func CreditInit(stub shim.ChaincodeStubInterface, userID string, value int) erro
 	}
 
 	// ==== Save Credit to state ====
-	err = stub.PutState("Credit_UerID_"+userID, creditAsByteArray)
+	err = stub.PutState(creditKey(stub, userID), creditAsByteArray)
 	if err != nil {
 		return errors.New(err.Error())
 	}
@@ -588,58 +779,56 @@ func CreditInit(stub shim.ChaincodeStubInterface, userID string, value int) erro
 
 func (rdg *SmartContract) CreditRead(stub shim.ChaincodeStubInterface, UserID string) peer.Response {
 	//to do
-	creditAsByteArray, err := retrieveSingleCreditAsByteArray(stub, "Credit_UerID_"+UserID)
+	creditAsByteArray, err := retrieveSingleCreditAsByteArray(stub, UserID)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(err)
 	}
 	return shim.Success(creditAsByteArray)
 }
 
-func retrieveSingleCredit(stub shim.ChaincodeStubInterface, creditID string) (Credit, error){
+func retrieveSingleCredit(stub shim.ChaincodeStubInterface, userID string) (Credit, error){
 	var credit Credit
 	var creditAsByteArray []byte
 	var err error
 
-	creditAsByteArray, err = stub.GetState(creditID)
+	creditAsByteArray, err = stub.GetState(creditKey(stub, userID))
 
 	if err != nil {
-		return credit, errors.New("CreditRead: Error credit read participant with ID: " + creditID)
+		return credit, errors.New("CreditRead: Error credit read participant with ID: " + userID)
+	}
+	if creditAsByteArray == nil {
+		return credit, errNotFound(CreditObjectType, userID)
 	}
-	// else if creditAsBytes == nil {
-    //  logger.Error("CreditRead:  Corrupt reading record ", err.Error())
-    //  return nil, errors.New("CreditRead: Credit does not exist " + creditID)
-    // }
 
-	// For log printing credit Information & check whether the credit does exist
+	// For log printing credit Information
 	err = json.Unmarshal(creditAsByteArray, &credit)
 	if err != nil {
-		return credit, errors.New("CreditRead: Credit does not exist "  + string(creditAsByteArray))
+		return credit, errors.New("CreditRead: Corrupt credit record "  + userID)
 	}
 	// For log printing credit Information
 
 	return credit, nil
 }
 
-func retrieveSingleCreditAsByteArray(stub shim.ChaincodeStubInterface, creditID string) ([]byte, error){
+func retrieveSingleCreditAsByteArray(stub shim.ChaincodeStubInterface, userID string) ([]byte, error){
 	var credit Credit
 	var creditAsByteArray []byte
 	var err error
 
-	logger.Info("-----retrieveSingleCreditAsByteArray :creditID---------", creditID)
-	creditAsByteArray, err = stub.GetState(creditID)
+	logger.Info("-----retrieveSingleCreditAsByteArray :userID---------", userID)
+	creditAsByteArray, err = stub.GetState(creditKey(stub, userID))
 
 	if err != nil {
-		return nil, errors.New("CreditRead: Error credit read participant with ID: " + creditID)
+		return nil, errors.New("CreditRead: Error credit read participant with ID: " + userID)
+	}
+	if creditAsByteArray == nil {
+		return nil, errNotFound(CreditObjectType, userID)
 	}
-	// else if creditAsBytes == nil {
-    //  logger.Error("CreditRead:  Corrupt reading record ", err.Error())
-    //  return nil, errors.New("CreditRead: Credit does not exist " + creditID)
-    // }
 
-	// For log printing credit Information & check whether the credit does exist
+	// For log printing credit Information
 	err = json.Unmarshal(creditAsByteArray, &credit)
 	if err != nil {
-		return nil, errors.New("CreditRead: Credit does not exist "  + string(creditAsByteArray))
+		return nil, errors.New("CreditRead: Corrupt credit record "  + userID)
 	}
 	// For log printing credit Information
 
@@ -651,58 +840,159 @@ func retrieveSingleCreditAsByteArray(stub shim.ChaincodeStubInterface, creditID
 
 func (rdg *SmartContract) CreditAdd(stub shim.ChaincodeStubInterface, args []string) peer.Response {
 	var credit Credit
-	var raw map[string]interface{}
+	var request struct {
+		UserID   string `json:"userID"`
+		Value    int    `json:"value"`
+		TicketID string `json:"ticketID"`
+	}
 
-	err := json.Unmarshal([]byte(args[0]), &raw)
+	err := json.Unmarshal([]byte(args[0]), &request)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(badRequest("CreditAdd", err.Error()))
 	}
 
 	// ==== Assign value to variable ====
-	userID := raw["userID"].(string)
-	logger.Info("*****CreditUpdate*******", userID)
-
-	value := int(raw["value"].(float64))
-	logger.Info("*****CreditUpdate*******", value)
-
-	ticketID := raw["ticketID"].(string)
-	logger.Info("*****CreditUpdate*******", ticketID)
+	userID := request.UserID
+	value := request.Value
+	ticketID := request.TicketID
+	logger.Info("*****CreditUpdate*******", userID, value, ticketID)
 
 	// === Check whether the credit already exist. ====
-	creditAsByteArray, err := stub.GetState("Credit_UerID_"+userID)
+	creditAsByteArray, err := stub.GetState(creditKey(stub, userID))
 	if err != nil {
-		return shim.Error("CreditUpdate: Failed to get credit :" + err.Error())
+		return errorResponse(errInternal("CreditAdd: Failed to get credit :" + err.Error()))
 	} else if creditAsByteArray == nil {
-		errs := fmt.Sprintf("CreditUpdate: Credit_UerID_%s does not exist.", userID)
-		logger.Info(" ****** " + errs)
-		return shim.Error(errs)
+		return errorResponse(errNotFound(CreditObjectType, userID))
 	}
 
 	err = json.Unmarshal(creditAsByteArray, &credit)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(err)
 	}
 
 	// === if ticket is a constan string which only represent add constant credit ===
+	movement := creditMovement{UserID: userID, Delta: value, Reason: JournalAdd, Ref: ticketID}
+	var ticket Ticket
 	if ticketID != "creditADD" {
 		// === check whether the ticket has been add ===
 		if ok := Is_Inarray(credit.TicketIDs, ticketID); ok {
-			return shim.Error("CreditUpdate: This ticket has been existed.")
+			return errorResponse(newError(ErrConflict, "CreditAdd: Ticket "+ticketID+" was already credited to "+userID).on(CreditObjectType, userID))
 		}
+		ticket, err = checkTicketCredit(stub, ticketID, userID, value)
+		if err != nil {
+			return errorResponse(err)
+		}
+		movement.Reason = JournalAward
+		movement.TicketID = ticketID
 	}
 
-	credit.Value += value
-	credit.TicketIDs = append(credit.TicketIDs, ticketID)
-
-	creditAsByteArray, err = json.Marshal(credit)
+	credits, err := applyCreditMovements(stub, []creditMovement{movement})
 	if err != nil {
-		return shim.Error("CreditUpdate: " + err.Error())
+		return errorResponse(err)
+	}
+	// === ticket credit counts against the ticket budget and its escrow like an award ===
+	if ticketID != "creditADD" {
+		_, err = releaseEscrow(stub, ticketID, value, false)
+		if err != nil {
+			return errorResponse(err)
+		}
+		ticket.Awarded += value
+		_, err = saveTicket(stub, ticket)
+		if err != nil {
+			return errorResponse(err)
+		}
+	}
+	creditAsByteArray, err = json.Marshal(credits[userID])
+	if err != nil {
+		return errorResponse(errInternal("CreditAdd: " + err.Error()))
 	}
 
-	err = stub.PutState("Credit_UerID_"+credit.UserID, creditAsByteArray)
+	err = emitEvent(stub, ExchainEvent{Name: EventCreditAdded, TicketID: ticketID, UserID: userID, Delta: value})
+	if err != nil {
+		return errorResponse(err)
+	}
 	return shim.Success(creditAsByteArray)
 }
 
+//CreditTransferRecord information, stored under the composite key ("CreditTransfer", TxID)
+//   From, To:     UserIDs debited and credited
+//   Value:        transferred credit, > 0
+//   Memo:         free text from the sender
+//   Timestamp:    transaction timestamp
+type CreditTransferRecord struct {
+	TxID		string		`json:"TxID"`
+	From		string		`json:"From"`
+	To			string		`json:"To"`
+	Value		int			`json:"Value"`
+	Memo		string		`json:"Memo"`
+	Timestamp	time.Time	`json:"Timestamp"`
+}
+
+//Invoke Route: CreditTransfer
+//   args[0]: {"From": "iXXXXXX", "To": "iYYYYYY", "Value": 10, "Memo": "thanks for the review"}
+func (rdg *SmartContract) CreditTransfer(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	var transfer CreditTransferRecord
+
+	err := json.Unmarshal([]byte(args[0]), &transfer)
+	if err != nil {
+		return errorResponse(badRequest("CreditTransfer", err.Error()))
+	}
+	if transfer.Value <= 0 {
+		return errorResponse(badRequest("CreditTransfer", "Value must be positive"))
+	}
+	if transfer.From == transfer.To {
+		return errorResponse(badRequest("CreditTransfer", "can not transfer credit to yourself"))
+	}
+
+	// ==== A deactivated receiver could never spend the credit, like a deactivated sender can not send it ====
+	receiverAsBytes, err := rdg.retrieveParticipant(stub, transfer.To)
+	if err != nil {
+		return errorResponse(err)
+	}
+	var receiver Participant
+	err = json.Unmarshal(receiverAsBytes, &receiver)
+	if err != nil {
+		return errorResponse(errInternal("CreditTransfer: Corrupt participant " + transfer.To))
+	}
+	if receiver.Deactivated {
+		return errorResponse(forbidden("CreditTransfer", "Participant "+transfer.To+" is deactivated"))
+	}
+
+	// ==== Debit and credit in one pass, overdrafts are rejected, LoB totals follow the credit ====
+	_, err = applyCreditMovements(stub, []creditMovement{
+		{UserID: transfer.From, Delta: -transfer.Value, Reason: JournalTransfer, Counterparty: transfer.To, Memo: transfer.Memo},
+		{UserID: transfer.To, Delta: transfer.Value, Reason: JournalTransfer, Counterparty: transfer.From, Memo: transfer.Memo}})
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	// ==== Record the transfer with its memo ====
+	transfer.TxID = stub.GetTxID()
+	transfer.Timestamp, err = txTime(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	transferAsBytes, err := json.Marshal(transfer)
+	if err != nil {
+		return errorResponse(errInternal("CreditTransfer: " + err.Error()))
+	}
+	key, _ := stub.CreateCompositeKey("CreditTransfer", []string{transfer.TxID})
+	err = stub.PutState(key, transferAsBytes)
+	if err != nil {
+		return errorResponse(errInternal("CreditTransfer: " + err.Error()))
+	}
+
+	err = emitEvent(stub, ExchainEvent{Name: EventCreditTransferred, UserID: transfer.From, Delta: -transfer.Value})
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = emitEvent(stub, ExchainEvent{Name: EventCreditTransferred, UserID: transfer.To, Delta: transfer.Value})
+	if err != nil {
+		return errorResponse(err)
+	}
+	return shim.Success(transferAsBytes)
+}
+
 func Is_Inarray(target []string, now string) bool {
 	for _, entry := range target {
 		if entry == now {
@@ -713,104 +1003,138 @@ func Is_Inarray(target []string, now string) bool {
 }
 
 
+//Invoke Route: CreditDelete
+//   args[0]: UserID
+//   removes the credit, its balance leaves the journal and the LoB totals as a delete entry
 func (rdg *SmartContract) CreditDelete(stub shim.ChaincodeStubInterface, userID string) peer.Response {
 	logger.Info(" ****** CreditDelete start ****** userID:" + userID)
-	err := stub.DelState("Credit_UerID_"+userID)
-	if err!= nil {
-		return shim.Error("CreditDelete: Failed to delete Credit state: " + err.Error())
+	credit, err := retrieveSingleCredit(stub, userID)
+	if err != nil {
+		return errorResponse(err)
+	}
+	var movements []creditMovement
+	if credit.Value != 0 {
+		movements = append(movements, creditMovement{UserID: userID, Delta: -credit.Value, Reason: JournalDelete})
 	}
 
-	//Log process for debug
-	credit, err := stub.GetState("Credit_UerID_"+userID)
-	logger.Info(" ****** CreditDelete ****** " + string(credit))
-
-	return shim.Success(nil)
-}
-
-func (rdg *SmartContract) LoBReadAll(stub shim.ChaincodeStubInterface) peer.Response {
-	var LoB_temp 	LoB
-	var result 		string
-
-	result += "["
-
-	iter := 0
-	for iter < NumberOfLoBs {
-		bytes, err := stub.GetState(Lob_Name[iter])
+	// ==== The debit counts against the LoB in full, the CreditBase of a participant was never counted there ====
+	totals := make(map[int]lobDelta)
+	var participant Participant
+	bytes, err := stub.GetState(participantKey(stub, userID))
+	if err != nil {
+		return errorResponse(errInternal("CreditDelete: Error getting participant with ID: " + userID))
+	}
+	if bytes != nil {
+		err = json.Unmarshal(bytes, &participant)
 		if err != nil {
-			return shim.Error("LoBReadAll: Error getting LoB info from state")
+			return errorResponse(errInternal("CreditDelete: Corrupt reading record " + userID))
 		}
-
-		err = json.Unmarshal(bytes, &LoB_temp)
+		err = addLoBCredit(stub, totals, participant.LoBID, participant.CreditBase)
 		if err != nil {
-			return shim.Error("LoBReadAll: Error unmarshalling LoB JSON")
+			return errorResponse(err)
 		}
-
-		LobAssest := LoB{LoBID: LoB_temp.LoBID, TotalCredit: LoB_temp.TotalCredit}
-		LobAssestAsByteArray, err := json.Marshal(LobAssest)
+	}
+	_, err = applyCreditMovementsWith(stub, movements, totals)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if participant.CreditBase != 0 {
+		participant.CreditBase = 0
+		_, err = rdg.saveParticipant(stub, participant)
 		if err != nil {
-			return shim.Error("LoBReadAll: Fail to Marshall LobAssest")
+			return errorResponse(err)
 		}
-		result += string(LobAssestAsByteArray) + ","
-		iter = iter + 1
 	}
 
-	result = result[:len(result)-1] + "]"
+	err = stub.DelState(creditKey(stub, userID))
+	if err != nil {
+		return errorResponse(errInternal("CreditDelete: Failed to delete Credit state: " + err.Error()))
+	}
+	return shim.Success(nil)
+}
 
-	return shim.Success([]byte(result))
+//Invoke Route: LoBReadAll - every LoB of the registry with its own and rolled-up totals, archived ones included
+func (rdg *SmartContract) LoBReadAll(stub shim.ChaincodeStubInterface) peer.Response {
+	lobs, err := listLoBs(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	totals := lobTotals(lobs)
+	for i := range totals {
+		totals[i].UserIDs = nil
+	}
+	result, err := json.Marshal(totals)
+	if err != nil {
+		return errorResponse(errInternal("LoBReadAll: Fail to Marshall LoBs"))
+	}
+	return shim.Success(result)
 }
 
+//LoBMember information, a participant as listed by LoBRead
+type LoBMember struct {
+	UserID		string		`json:"Participant_UserID"`
+	UserName	string		`json:"Participant_UserName"`
+	Credit		int			`json:"Credit_Value"`
+	LoBID		int			`json:"Participant_LoBID"`
+}
+
+//LoBReading information, result of LoBRead
+//   LoBTotals:      the LoB with its own and rolled-up totals and member counts, as LoBReadAll lists it
+//   Participants:   the members in LoB_UserIDs with their credit
+type LoBReading struct {
+	LoBTotals
+	Participants	[]LoBMember	`json:"LoB_Participants"`
+}
+
+//Query Route: LoBRead - one LoB with its totals and members
 func (rdg *SmartContract) LoBRead(stub shim.ChaincodeStubInterface, LoBid string) peer.Response {
-	var LoB_temp LoB
 	var participant_temp 		Participant
 	var participantAsByteArray 	[]byte
-	var credit_temp				Credit
-	var result string
 
-	LoBID, _ := strconv.Atoi(LoBid)
-	if (LoBID < 0 ||  LoBID >= NumberOfLoBs) {
-		return shim.Error("Input LoBID is invalid, LoBID")
+	LoBID, err := strconv.Atoi(LoBid)
+	if err != nil {
+		return errorResponse(badRequest("LoBRead", "LoBID must be an integer"))
 	}
-
-	LobName := Lob_Name[LoBID]
-	bytes, err := stub.GetState(LobName)
+	LoB_temp, err := retrieveLoB(stub, LoBID)
 	if err != nil {
-		return shim.Error("LoBRead: Error getting LoB info from state")
+		return errorResponse(err)
 	}
-
-	err = json.Unmarshal(bytes, &LoB_temp)
+	lobs, err := listLoBs(stub)
 	if err != nil {
-		return shim.Error("LoBRead: Error unmarshalling LoB JSON")
+		return errorResponse(err)
+	}
+	reading := LoBReading{LoBTotals: LoBTotals{LoB: LoB_temp}, Participants: []LoBMember{}}
+	for _, lob := range lobTotals(lobs) {
+		if lob.LoBID == LoBID {
+			reading.LoBTotals = lob
+		}
 	}
-
-	result += "["
-	credit := strconv.Itoa(LoB_temp.TotalCredit)
-	result += "TotalCredit: " + credit + ","
 
 	for _, participantID := range LoB_temp.UserIDs {
 		participantAsByteArray, err = rdg.retrieveParticipant(stub, participantID)
 		if err != nil {
-			return shim.Error("Failed to retrieve participant with ID: " + participantID)
+			return errorResponse(errInternal("LoBRead: Failed to retrieve participant with ID: " + participantID))
 		}
 
 		err = json.Unmarshal(participantAsByteArray, &participant_temp)
 		if err != nil {
-			return shim.Error("LoBRead: Error unmarshalling Participant JSON")
+			return errorResponse(errInternal("LoBRead: Error unmarshalling Participant JSON"))
 		}
 
-		credit_temp, _ = retrieveSingleCredit(stub, "Credit_UerID_"+participant_temp.UserID)
-
-		Participant_UserID := "{\"participant_UserID\": " + participant_temp.UserID + ","
-		Participant_UserName := "\"participant_UserName\": " + participant_temp.UserName + ","
-		Participant_credit := "\"participant_credit\": " + strconv.Itoa(credit_temp.Value) + ","
-		Participant_LoB := "\"participant_LoB\": " + strconv.Itoa(participant_temp.LoBID) + "},"
-		result += Participant_UserID + Participant_UserName + Participant_credit + Participant_LoB
+		// a participant without credit record has no credit
+		credit_temp, err := retrieveSingleCredit(stub, participant_temp.UserID)
+		if e, ok := err.(*ChaincodeError); err != nil && (!ok || e.Code != ErrNotFound) {
+			return errorResponse(err)
+		}
+		reading.Participants = append(reading.Participants, LoBMember{UserID: participant_temp.UserID,
+			UserName: participant_temp.UserName, Credit: credit_temp.Value, LoBID: participant_temp.LoBID})
 	}
-	if len(result) == 1 {
-		result = "[]"
-	} else {
-		result = result[:len(result)-1] + "\n]"
+
+	result, err := json.Marshal(reading)
+	if err != nil {
+		return errorResponse(errInternal("LoBRead: Fail to Marshall LoB"))
 	}
-	return shim.Success([]byte(result))
+	return shim.Success(result)
 }
 
 
@@ -828,11 +1152,11 @@ func (rdg *SmartContract) TopTenCredit(stub shim.ChaincodeStubInterface) peer.Re
 	bytes, _ := stub.GetState("readingIDIndex")
 	err = json.Unmarshal(bytes, &readingIDs)
 	if err != nil {
-		return shim.Error("TopTenCredit: Error unmarshalling readingIDIndex array JSON")
+		return errorResponse(errInternal("TopTenCredit: Error unmarshalling readingIDIndex array JSON"))
 	}
 
 	for _, participantID := range readingIDs.UserIDs {
-		credit_temp, _ = retrieveSingleCredit(stub, "Credit_UerID_"+participantID)
+		credit_temp, _ = retrieveSingleCredit(stub, participantID)
 		credits = append(credits, credit_temp)
 	}
 
@@ -842,7 +1166,7 @@ func (rdg *SmartContract) TopTenCredit(stub shim.ChaincodeStubInterface) peer.Re
 		participantAsBytes, _ = rdg.retrieveParticipant(stub, credits[i].UserID)
 		err = json.Unmarshal(participantAsBytes, &participant_temp)
 		if err != nil {
-			return shim.Error("TopTenParticipant: Error unmarshalling Participant JSON")
+			return errorResponse(errInternal("TopTenCredit: Error unmarshalling Participant JSON"))
 		}
 
 		Participant_UserID := "{\"participant_UserID\": \"" + participant_temp.UserID + "\","
@@ -868,18 +1192,14 @@ func Min(x, y int) int {
     return y
 }
 
+//the input was validated by Invoke against the TicketInit / Ticket schema (signature.go)
 func getTicketFromArgs(args string)(ticket Ticket, err error) {
-	if strings.Contains(args, "\"Ticket_Title\"") == false 		||
-	strings.Contains(args, "\"Ticket_Value\"") == false 		||
-	strings.Contains(args, "\"Ticket_UserID\"") == false 		||
-	strings.Contains(args, "\"Ticket_Type\"") == false {
-		return ticket, errors.New("Unknown field: Input JSON does not comly to schema")
-	}
-
 	err = json.Unmarshal([]byte(args), &ticket)
 	if err != nil {
 		return ticket, err
 	}
+	// ==== Deadlines are stored in UTC, whatever offset the client sent ====
+	ticket.DeadLine = ticket.DeadLine.UTC()
 
 	return ticket, nil
 }
@@ -890,7 +1210,7 @@ func saveTicket(stub shim.ChaincodeStubInterface, ticket Ticket)([]byte, error)
 	if err != nil {
 		return ticketAsBytes, errors.New("saveTicket: " + err.Error())
 	}
-	err = stub.PutState(ticket.TicketID, ticketAsBytes)
+	err = stub.PutState(ticketKey(stub, ticket.TicketID), ticketAsBytes)
 	if err != nil {
 		return ticketAsBytes, err
 	}
@@ -909,32 +1229,60 @@ func (sc *SmartContract)TicketCreate(stub shim.ChaincodeStubInterface, args []st
 	// 	DeadLine: time.Now()}
 
 	ticket, err := getTicketFromArgs(args[0])
-
-	TICKETIDAsBytes, _ := stub.GetState("TICKETID")
-	TICKETID, _ = strconv.Atoi(string(TICKETIDAsBytes))
-	TICKETID++
-	ticket.TicketID = strconv.Itoa(TICKETID)
-	ticket.Status = 1
 	if err != nil {
-		return shim.Error("TicketCreate: " + err.Error())
+		return errorResponse(badRequest("TicketCreate", err.Error()))
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if deadlinePassed(ticket, now) {
+		return errorResponse(badRequest("TicketCreate", "Ticket_Deadline must be after the transaction time "+now.Format(time.RFC3339)))
 	}
 
+	problems := append(validateAwardPolicy(ticket), validateFunding(ticket)...)
+	if len(problems) > 0 {
+		return errorResponse(badRequest("TicketCreate", problems...))
+	}
+
+	// ==== The tx ID is unique, so tickets created in the same block never conflict ====
+	ticket.TicketID = stub.GetTxID()
+	ticket.Status = Applied
+	ticket.Awarded = 0
+
 	// ==== Judge if the ticket already exists ====
-	// ticketAsBytes, err := stub.GetState(ticket.TicketID)
-	// if ticketAsBytes != nil {
-	// 	return shim.Error("TicketCreate: The ticket already exists." + string(ticketAsBytes))
-	// }
+	ticketAsBytes, err := stub.GetState(ticketKey(stub, ticket.TicketID))
+	if err != nil {
+		return errorResponse(errInternal("TicketCreate: " + err.Error()))
+	}
+	if ticketAsBytes != nil {
+		return errorResponse(errAlreadyExists(TicketObjectType, ticket.TicketID))
+	}
 	// todo
 	// check if userid is valid
 
 	// ==== Put the ticket into ledger ====
-	ticketAsBytes, err := saveTicket(stub, ticket)
+	ticketAsBytes, err = saveTicket(stub, ticket)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(err)
+	}
+
+	// ==== Lock the value of a funded ticket, the whole transaction fails if the funder can not cover it ====
+	if ticket.Funding != "" {
+		movement, err := lockEscrow(stub, ticket)
+		if err != nil {
+			return errorResponse(err)
+		}
+		_, err = applyCreditMovements(stub, []creditMovement{movement})
+		if err != nil {
+			return errorResponse(err)
+		}
 	}
 
-	TICKETIDAsBytes, _ = json.Marshal(TICKETID)
-	stub.PutState("TICKETID", TICKETIDAsBytes)
+	err = emitEvent(stub, ExchainEvent{Name: EventTicketCreated, TicketID: ticket.TicketID, UserID: ticket.UserID, NewStatus: intPtr(ticket.Status)})
+	if err != nil {
+		return errorResponse(err)
+	}
 	return shim.Success(ticketAsBytes)
 }
 
@@ -942,18 +1290,43 @@ func (sc *SmartContract)TicketCreate(stub shim.ChaincodeStubInterface, args []st
 func (sc *SmartContract)TicketDelete(stub shim.ChaincodeStubInterface, ticketID string) peer.Response {
 	// ==== Judge if the ticket already exists ====
 	var ticket Ticket
-	ticketAsBytes, err := stub.GetState(ticketID)
+	ticketAsBytes, err := stub.GetState(ticketKey(stub, ticketID))
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(errInternal("TicketDelete: " + err.Error()))
+	}
+	if ticketAsBytes == nil {
+		return errorResponse(errNotFound(TicketObjectType, ticketID))
 	}
 
 	err = json.Unmarshal(ticketAsBytes, &ticket)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(errInternal("TicketDelete: " + err.Error()))
 	}
 	logger.Info(" ****** TicketDelete:", ticket)
 
-	err = stub.DelState(ticketID)
+	// ==== What is still locked goes back to the funder ====
+	refunds, err := refundEscrow(stub, ticketID)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if len(refunds) > 0 {
+		_, err = applyCreditMovements(stub, refunds)
+		if err != nil {
+			return errorResponse(err)
+		}
+	}
+
+	// ==== Orders do not outlive their ticket ====
+	closed, err := deleteOrders(stub, ticketID)
+	if err != nil {
+		return errorResponse(err)
+	}
+	logger.Info(" ****** TicketDelete closed orders:", closed)
+
+	err = stub.DelState(ticketKey(stub, ticketID))
+	if err != nil {
+		return errorResponse(errInternal("TicketDelete: " + err.Error()))
+	}
 	return shim.Success(nil)
 }
 
@@ -972,22 +1345,91 @@ func (sc *SmartContract)TicketUpdate(stub shim.ChaincodeStubInterface, args []st
 	// participantID := args[0]
 	ticket, err := getTicketFromArgs(args[0])
 	if err != nil {
-		return shim.Error("TicketUpdate: " + err.Error())
+		return errorResponse(badRequest("TicketUpdate", err.Error()))
 	}
 	// ==== Judge if the ticket already exists ====
-	ticketAsBytes, err := stub.GetState(ticket.TicketID)
+	var currTicket Ticket
+	ticketAsBytes, err := stub.GetState(ticketKey(stub, ticket.TicketID))
+	if err != nil {
+		return errorResponse(errInternal("TicketUpdate: " + err.Error()))
+	}
 	if ticketAsBytes == nil {
-		return shim.Error("TicketCreate: The ticket does not exist.")
+		return errorResponse(errNotFound(TicketObjectType, ticket.TicketID))
+	}
+	err = json.Unmarshal(ticketAsBytes, &currTicket)
+	if err != nil {
+		return errorResponse(errInternal("TicketUpdate: " + err.Error()))
 	}
 
-	// if participantID != ticket.UserID {
-	// 	return shim.Error("TicketUpdate: You have no rights to update the ticket")
-	// }
+	// ==== The owner was checked by Invoke, ownership itself can not be handed over ====
+	if ticket.UserID != currTicket.UserID {
+		return errorResponse(forbidden("TicketUpdate", "can not change Ticket_UserID of ticket "+ticket.TicketID))
+	}
+
+	// ==== Expired is final, and moving the deadline of an overdue ticket would reopen it ====
+	now, err := txTime(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	if currTicket.Status == Expired {
+		return errorResponse(newError(ErrDeadlinePassed, "TicketUpdate: Ticket "+ticket.TicketID+" expired and can not change").
+			on(TicketObjectType, ticket.TicketID))
+	}
+	deadlineChanged := !ticket.DeadLine.Equal(currTicket.DeadLine)
+	if deadlineChanged && deadlinePassed(currTicket, now) {
+		return errorResponse(newError(ErrDeadlinePassed, "TicketUpdate: The deadline "+currTicket.DeadLine.Format(time.RFC3339)+
+			" of ticket "+ticket.TicketID+" has passed and can not move").on(TicketObjectType, ticket.TicketID))
+	}
+
+	problems := validateAwardPolicy(ticket)
+	if deadlineChanged && deadlinePassed(ticket, now) {
+		problems = append(problems, "Ticket_Deadline must be after the transaction time "+now.Format(time.RFC3339))
+	}
+	if ticket.Value < currTicket.Awarded {
+		problems = append(problems, "Ticket_Value can not drop below the "+strconv.Itoa(currTicket.Awarded)+" credit already awarded")
+	}
+	if currTicket.Funding != "" && ticket.Value != currTicket.Value {
+		problems = append(problems, "Ticket_Value of a funded ticket can not change")
+	}
+	if currTicket.Awarded > 0 && !sameAwardPolicy(awardPolicy(ticket), awardPolicy(currTicket)) {
+		problems = append(problems, "Ticket_Award can not change after the first award")
+	}
+	var orders []Order
+	if ticket.Capacity != currTicket.Capacity {
+		orders, err = ticketOrders(stub, ticket.TicketID, nil)
+		if err != nil {
+			return errorResponse(err)
+		}
+		if taken := takenSeats(orders); ticket.Capacity != 0 && ticket.Capacity < taken {
+			problems = append(problems, "Ticket_Capacity can not drop below the "+strconv.Itoa(taken)+" seats already taken")
+		}
+	}
+	if len(problems) > 0 {
+		return errorResponse(badRequest("TicketUpdate", problems...))
+	}
+	// ==== Only orders and ExpireTickets change the status, only awards what was awarded, the funding is fixed at creation ====
+	ticket.Status = currTicket.Status
+	ticket.Awarded = currTicket.Awarded
+	ticket.Funding = currTicket.Funding
 
 	// ==== Update the ledger ====
 	ticketAsBytes, err = saveTicket(stub, ticket)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(err)
+	}
+
+	err = emitEvent(stub, ExchainEvent{Name: EventTicketUpdated, TicketID: ticket.TicketID, UserID: ticket.UserID,
+		OldStatus: intPtr(currTicket.Status), NewStatus: intPtr(ticket.Status)})
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	// ==== Seats a larger capacity adds go to the waitlist, applications count for the ticket status as before ====
+	if ticket.Capacity != currTicket.Capacity {
+		_, err = promoteWaitlist(stub, ticket, make(map[string]Order))
+		if err != nil {
+			return errorResponse(err)
+		}
 	}
 	return shim.Success(ticketAsBytes)
 }
@@ -996,101 +1438,98 @@ func (sc *SmartContract)TicketUpdate(stub shim.ChaincodeStubInterface, args []st
 func (sc *SmartContract)TicketRead(stub shim.ChaincodeStubInterface, args string) peer.Response {
 	// ==== Read ticket from ledger ====
 	var ticket Ticket
-	ticketAsBytes, err := stub.GetState(args)
+	ticketAsBytes, err := stub.GetState(ticketKey(stub, args))
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(errInternal("TicketRead: " + err.Error()))
+	}
+	if ticketAsBytes == nil {
+		return errorResponse(errNotFound(TicketObjectType, args))
 	}
 
 	err = json.Unmarshal(ticketAsBytes, &ticket)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(err)
 	}
 	logger.Info(" ****** TicketRead:", ticket)
 	return shim.Success(ticketAsBytes)
 }
 
+//Query Route: TicketRead2 - every ticket as a plain array, kept for old clients; use TicketList (ticket_list.go)
 func (sc *SmartContract)TicketRead2(stub shim.ChaincodeStubInterface) peer.Response {
-	TICKETIDAsBytes, _ := stub.GetState("TICKETID")
-	TICKETID, _ = strconv.Atoi(string(TICKETIDAsBytes))
-
-	var buffer bytes.Buffer
-	buffer.WriteString("[")
-	bArrayMemberAlreadyWritten := false
-
-	i := 1
-	//var ticket Ticket
-
-    for i <= TICKETID {
-		if bArrayMemberAlreadyWritten == true {
-			buffer.WriteString(",")
-		}
-		//TicketArray = append(TicketArray, strconv.Itoa(i))
-		//TicketIDs = strconv.Itoa(i)
-		ticketAsBytes, _ := stub.GetState(strconv.Itoa(i))
-		//item, _ := json.Marshal(sc.TicketRead(stub, strconv.Itoa(i)))
-		// Record is a JSON object, so we write as-is
-		buffer.WriteString(string(ticketAsBytes))
-		bArrayMemberAlreadyWritten = true
-        i = i + 1
-	}
-	buffer.WriteString("]")
-	return shim.Success(buffer.Bytes())
-}
-
-
-func (sc *SmartContract) TestGetHistoryTicket(stub shim.ChaincodeStubInterface, args []string) peer.Response {
-	ticketInterator, err := stub.GetHistoryForKey("xxx1")
+	page, err := listTickets(stub, TicketFilter{}, 0, "")
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(err)
 	}
-
-	// defer ticketInterator.Close()
-
-	for ticketInterator.HasNext() {
-		queryResponse, err := ticketInterator.Next()
-		if err != nil {
-			return shim.Error(err.Error())
-		}
-
-		logger.Info("------Test----" + queryResponse.String())
-		item, _ := json.Marshal(queryResponse)
-		logger.Info("------Test 1----" + string(item))
-
+	ticketsAsBytes, err := json.Marshal(page.Items)
+	if err != nil {
+		return errorResponse(errInternal("TicketRead2: " + err.Error()))
 	}
-
-
-	return shim.Success(nil)
+	return shim.Success(ticketsAsBytes)
 }
 
+
 func (sc *SmartContract) OrderCreate(stub shim.ChaincodeStubInterface, args []string) peer.Response {
 	// json ticketID & userID
 	//
 
+	// validated by Invoke against the OrderInit schema (signature.go)
 	var order Order
-	if strings.Contains(args[0], "\"TicketID\"") == false 	||
-	strings.Contains(args[0], "\"UserID\"") == false {
-		return shim.Error("OrderCreate:Unknown field: Input JSON does not comly to schema")
-	}
-
 	err := json.Unmarshal([]byte(args[0]), &order)
 	if err != nil {
-		return shim.Error("OrderCreate:")
+		return errorResponse(badRequest("OrderCreate", err.Error()))
 	}
 	ticketID := order.TicketID
 	userID := order.UserID
 
-	key, _ := stub.CreateCompositeKey("Order", []string{ticketID, userID})
+	// ==== the ticket must exist and still take orders ====
+	var ticket Ticket
+	ticketAsBytes, err := stub.GetState(ticketKey(stub, ticketID))
+	if err != nil {
+		return errorResponse(errInternal("OrderCreate: " + err.Error()))
+	}
+	if ticketAsBytes == nil {
+		return errorResponse(errNotFound(TicketObjectType, ticketID))
+	}
+	err = json.Unmarshal(ticketAsBytes, &ticket)
+	if err != nil {
+		return errorResponse(errInternal("OrderCreate: " + err.Error()))
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return errorResponse(err)
+	}
+	err = checkTicketOpen("OrderCreate", ticket, now)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	key := orderKey(stub, ticketID, userID)
 	logger.Info("------OrderCreate:" + key)
 
 	// ==== check whether the order already exsit ====
-	orderAsByte, _ := stub.GetState(key)
+	orderAsByte, err := stub.GetState(key)
+	if err != nil {
+		return errorResponse(errInternal("OrderCreate: " + err.Error()))
+	}
 	if orderAsByte != nil {
-		return shim.Error("OrderCreate: You have applied this Ticket")
+		return errorResponse(newError(ErrAlreadyExists, "OrderCreate: "+userID+" has already applied for ticket "+ticketID).
+			on(OrderObjectType, orderID(ticketID, userID)))
 	}
 
-	order.Status = 1
-	orderAsByte, _ = json.Marshal(order)
-	stub.PutState(key, orderAsByte)
+	// ==== a full ticket puts the application on its waitlist ====
+	order, err = admitOrder(stub, ticket, order)
+	if err != nil {
+		return errorResponse(err)
+	}
+	orderAsByte, err = OrderSaving(stub, order)
+	if err != nil {
+		return errorResponse(err)
+	}
+
+	err = emitEvent(stub, ExchainEvent{Name: EventOrderCreated, TicketID: ticketID, UserID: userID, NewStatus: intPtr(order.Status)})
+	if err != nil {
+		return errorResponse(err)
+	}
 
 	return shim.Success(orderAsByte)
 }
@@ -1100,8 +1539,14 @@ func (sc *SmartContract) OrderRead(stub shim.ChaincodeStubInterface, args []stri
 	userID := args[1]
 
 	logger.Info("OrderRead :", ticketID, userID)
-	key, _ := stub.CreateCompositeKey("Order", []string{ticketID, userID})
-	orderAsByte, _ := stub.GetState(key)
+	key := orderKey(stub, ticketID, userID)
+	orderAsByte, err := stub.GetState(key)
+	if err != nil {
+		return errorResponse(errInternal("OrderRead: " + err.Error()))
+	}
+	if orderAsByte == nil {
+		return errorResponse(errNotFound(OrderObjectType, orderID(ticketID, userID)))
+	}
 
 	logger.Info("OrderRead orderAsByte:", orderAsByte)
 	var order Order
@@ -1115,8 +1560,12 @@ func (sc *SmartContract) OrderRead(stub shim.ChaincodeStubInterface, args []stri
 func (sc *SmartContract) OrderRead2(stub shim.ChaincodeStubInterface, args []string) peer.Response{
 	ticketID := args[0]
 
-	orderInterator, _ :=
-	stub.GetStateByPartialCompositeKey("Order", []string{ticketID})
+	orderInterator, err :=
+	stub.GetStateByPartialCompositeKey(OrderObjectType, []string{ticketID})
+	if err != nil {
+		return errorResponse(errInternal("OrderRead2: " + err.Error()))
+	}
+	defer orderInterator.Close()
 
 	var buffer bytes.Buffer
 	buffer.WriteString("[")
@@ -1128,7 +1577,7 @@ func (sc *SmartContract) OrderRead2(stub shim.ChaincodeStubInterface, args []str
 		queryResponse, err := orderInterator.Next()
 		logger.Info("OrderRead2 Interator :", queryResponse)
 		if err != nil {
-			return shim.Error(err.Error())
+			return errorResponse(err)
 		}
 
 		logger.Info("------Test----" + queryResponse.String())
@@ -1152,257 +1601,313 @@ func (sc *SmartContract) OrderRead2(stub shim.ChaincodeStubInterface, args []str
 func OrderSaving(stub shim.ChaincodeStubInterface, order Order) ([]byte, error) {
 	bytes, err := json.Marshal(order)
 	if err != nil {
-		return nil, err
+		return nil, errInternal("OrderSaving: " + err.Error())
 	}
-	key, _ := stub.CreateCompositeKey(
-		"Order",
-		[]string{order.TicketID, order.UserID})
-
-	err = stub.PutState(key, bytes)
+	err = stub.PutState(orderKey(stub, order.TicketID, order.UserID), bytes)
 	if err != nil {
-		return nil, err
+		return nil, errInternal("OrderSaving: " + err.Error())
 	}
 	return bytes, nil
 }
 
-func OrderBlukUpdate(stub shim.ChaincodeStubInterface, ticketID interface{}, userID_array []interface{}, status int) (bool, error) {
-	for _, userID := range userID_array {
-		order := Order{
-			TicketID: ticketID.(string),
-			UserID: userID.(string),
-			Status: status}
-		if status != 0{
-			key, _ := stub.CreateCompositeKey("Order", []string{ticketID.(string), userID.(string)})
-			orderAsByte, _ := stub.GetState(key)
-
-			var order Order
-			_ = json.Unmarshal(orderAsByte, &order)
-
-			if order.Status == status - 1{
-				order.Status = status
-				OrderSaving(stub, order)
-			}
-		} else {
-			order.Status = status
-			OrderSaving(stub, order)
+//Helper: credit userIDs for ticket by its award policy, returns the credit paid out. Once the orders of a
+//funded ticket are settled, what is left in its escrow goes back to the funder in the same transaction
+func award(stub shim.ChaincodeStubInterface, ticket Ticket, userIDs []string, pending map[string]Order)(int, error){
+	ticketID := ticket.TicketID
+	var recipients []string
+	for _, userID := range userIDs {
+		credit, err := retrieveSingleCredit(stub, userID)
+		if err != nil {
+			return 0, err
 		}
+		// the order has just moved to Awarded, skip it if the ticket was already credited
+		if Is_Inarray(credit.TicketIDs, ticketID) {
+			continue
+		}
+		recipients = append(recipients, userID)
 	}
-	return true, nil
-}
 
-func orderStatueEqual(stub shim.ChaincodeStubInterface, ticketID string, userID string, status int) bool {
-	key, _ := stub.CreateCompositeKey("Order", []string{ticketID, userID})
-	orderAsByte, _ := stub.GetState(key)
+	paid := 0
+	var movements []creditMovement
+	if len(recipients) > 0 {
+		shares, err := awardShares(ticket, recipients)
+		if err != nil {
+			return 0, err
+		}
+		for _, userID := range recipients {
+			movements = append(movements, creditMovement{UserID: userID, Delta: shares[userID], Reason: JournalAward, Ref: ticketID, TicketID: ticketID})
+			paid += shares[userID]
+		}
+	}
 
-	var order Order
-	_ = json.Unmarshal(orderAsByte, &order)
+	// funded tickets pay out of their escrow instead of creating credit
+	settled, err := ticketSettled(stub, ticketID, pending)
+	if err != nil {
+		return 0, err
+	}
+	refunds, err := releaseEscrow(stub, ticketID, paid, settled)
+	if err != nil {
+		return 0, err
+	}
 
-	if order.Status == status{
-		return true
-	} else {
-		return false
+	// credits, user's LoB total credit and journal, in one go as LoB totals are read from state
+	if len(movements)+len(refunds) > 0 {
+		_, err = applyCreditMovements(stub, append(movements, refunds...))
+		if err != nil {
+			return 0, err
+		}
 	}
-}
 
-func award(stub shim.ChaincodeStubInterface, ticketID string, userID_array []interface{}, value int)(bool, error){
-	for _, userID := range userID_array {
-		logger.Info("-----xxx---------", "Credit_UerID_"+userID.(string))
-		credit, _ := retrieveSingleCredit(stub, "Credit_UerID_"+userID.(string))
-		// if order is done and ticketID not in credit.TicketIDs
-		if (orderStatueEqual(stub, ticketID, userID.(string), 4) &&
-			!Is_Inarray(credit.TicketIDs, ticketID)) {
-			credit.Value += value
-			credit.TicketIDs = append(credit.TicketIDs, ticketID)
-			logger.Info("-----xxx---------", credit)
-			creditAsByteArray, _ := json.Marshal(credit)
-			stub.PutState("Credit_UerID_"+credit.UserID, creditAsByteArray)
-
-			// update user's LoB total credit
-			_, err := updateLoBCredit(stub, userID.(string), value)
-			if err != nil {
-				return false, err
-			}
+	for _, movement := range movements {
+		err = emitEvent(stub, ExchainEvent{Name: EventCreditAwarded, TicketID: ticketID, UserID: movement.UserID, Delta: movement.Delta})
+		if err != nil {
+			return 0, err
 		}
 	}
-	return true, nil
+	return paid, nil
 }
 
-func updateLoBCredit(stub shim.ChaincodeStubInterface, userID string, value int)(bool, error){
-	var participant Participant
+//Helper: add delta to the totals of a LoB, called once per LoB and transaction by applyCreditMovements
+//and LoBMove. A Budget below zero is rejected.
+func updateLoBCredit(stub shim.ChaincodeStubInterface, LoBID int, delta lobDelta)(bool, error){
 	var LoB_temp LoB
 
-	bytes, err := stub.GetState(userID)
-	if err != nil {
-		return false, errors.New("updateLoBCredit: Error get participant with ID: " + userID)
-	}
-	err = json.Unmarshal(bytes, &participant)
-	if err != nil {
-		return false, errors.New("updateLoBCredit: Corrupt reading record " + string(bytes))
-	}
-
-	LobName := Lob_Name[participant.LoBID]
-	bytes, err = stub.GetState(LobName)
+	bytes, err := stub.GetState(lobKey(stub, LoBID))
 	if err != nil {
 		return false, errors.New("updateLoBCredit: Error getting LoB info from state")
 	}
+	if bytes == nil {
+		return false, errNotFound(LoBObjectType, strconv.Itoa(LoBID))
+	}
 
 	err = json.Unmarshal(bytes, &LoB_temp)
 	if err != nil {
 		return false, errors.New("updateLoBCredit: Error unmarshalling LoB JSON")
 	}
-	LoB_temp.TotalCredit += value
+	if delta.Budget < 0 && LoB_temp.Budget+delta.Budget < 0 {
+		return false, newError(ErrInsufficientCredit, fmt.Sprintf("Insufficient budget, LoB %d has %d and can not be debited %d",
+			LoBID, LoB_temp.Budget, -delta.Budget)).on(LoBObjectType, strconv.Itoa(LoBID))
+	}
+	LoB_temp.TotalCredit += delta.Credit
+	LoB_temp.RetainedCredit += delta.Retained
+	LoB_temp.RolledUpCredit += delta.RolledUp
+	LoB_temp.Budget += delta.Budget
+	if delta.Leave != "" {
+		LoB_temp.UserIDs, _ = deleteKeyFromStringArray(LoB_temp.UserIDs, delta.Leave)
+	}
+	if delta.Join != "" && !Is_Inarray(LoB_temp.UserIDs, delta.Join) {
+		LoB_temp.UserIDs = append(LoB_temp.UserIDs, delta.Join)
+	}
 	bytes, err = json.Marshal(LoB_temp)
 	if err != nil {
 		return false, errors.New("updateLoBCredit: Error marshalling new LoB info")
 	}
 
-	err = stub.PutState(LobName, bytes)
+	err = stub.PutState(lobKey(stub, LoBID), bytes)
 	if err != nil {
 		return false, err
 	}
 	return true, nil
 }
 
+//Invoke Route: OrderUpdate
+//   args[0]: {"TicketID": "1", "Confirm": [UserIDs], "Reject": [...], "Done": [...], "Award": [...], "Withdraw": [...], "Close": [...]}
+//   every listed order is moved through orderTransitions; the response reports the outcome per user
 func (sc *SmartContract) OrderUpdate(stub shim.ChaincodeStubInterface, args []string) peer.Response {
-	var raw map[string]interface{}
+	var raw map[string]json.RawMessage
+	var ticketID string
+	var ticket Ticket
 
 	err := json.Unmarshal([]byte(args[0]), &raw)
 	if err != nil {
-		return shim.Error(err.Error())
+		return errorResponse(badRequest("OrderUpdate", err.Error()))
+	}
+	if raw["TicketID"] == nil || json.Unmarshal(raw["TicketID"], &ticketID) != nil {
+		return errorResponse(badRequest("OrderUpdate", "TicketID is needed"))
 	}
 
-	var data []interface{}
-	var status = 0
-	ticketID := raw["TicketID"]
-	confirm := raw["Confirm"]
-	close := raw["Close"]
-	done := raw["Done"]
-	awarded := raw["Award"]
-
-	if ticketID == nil {
-		return shim.Error("OrderUpdate: TicketID is needed")
+	ticketAsBytes, err := stub.GetState(ticketKey(stub, ticketID))
+	if err != nil {
+		return errorResponse(errInternal("OrderUpdate: " + err.Error()))
 	}
-	if close != nil {
-		_, err = OrderBlukUpdate(stub, ticketID, close.([]interface{}), status)
-		if err != nil {
-			return shim.Error("OrderUpdate:" + err.Error())
-		}
+	if ticketAsBytes == nil {
+		return errorResponse(errNotFound(TicketObjectType, ticketID))
 	}
-
-	logger.Info("[OrderUpdate]--------------", raw)
-	logger.Info("[OrderUpdate]-----if start---------")
-	if confirm != nil {
-		data = confirm.([]interface{})
-		status = 2
-		_, err = OrderBlukUpdate(stub, ticketID, data, status)
-	} else if done != nil {
-		data = done.([]interface{})
-		status = 3
-		_, err = OrderBlukUpdate(stub, ticketID, data, status)
-	} else if awarded != nil {
-		data = awarded.([]interface{})
-		status = 4
-		_, err = OrderBlukUpdate(stub, ticketID, data, status)
-
-		logger.Info("-----1---------")
-		// Award user
-		var ticket Ticket
-		ticketAsBytes, err := stub.GetState(ticketID.(string))
-		if err != nil {
-			return shim.Error(err.Error())
-		}
-
-		err = json.Unmarshal(ticketAsBytes, &ticket)
-		if err != nil {
-			return shim.Error(err.Error())
-		}
-		award(stub, ticket.TicketID, data, ticket.Value)
+	err = json.Unmarshal(ticketAsBytes, &ticket)
+	if err != nil {
+		return errorResponse(errInternal("OrderUpdate: " + err.Error()))
 	}
-	logger.Info("[OrderUpdate]-----if end---------")
 
+	caller, err := getCaller(stub)
 	if err != nil {
-		return shim.Error("OrderUpdate:" + err.Error())
+		return errorResponse(forbidden("OrderUpdate", err.Error()))
 	}
 
-	logger.Info("[OrderUpdate]-----order read2 start---------")
-	sc.OrderRead2(stub, []string{ticketID.(string)})
-	logger.Info("[OrderUpdate]-----order read2 start---------")
-
-	logger.Info("[OrderUpdate]-----time sleep start---------")
-	//time.Sleep(time.Second*30)
-	logger.Info("[OrderUpdate]-----time sleep end---------")
-	// update ticket status
-
-	logger.Info("[OrderUpdate]-----AutoUpdateTicketStatus start---------")
-	sc.AutoUpdateTicketStatus(stub, ticketID.(string))
-	logger.Info("[OrderUpdate]-----AutoUpdateTicketStatus end---------")
-	// ticket id & ticket object list
+	// orders written in this transaction, GetState would still return their old status
+	pending := make(map[string]Order)
+	results := []OrderTransitionResult{}
+	var awarded []string
 
+	for _, transition := range orderTransitions {
+		if raw[transition.Action] == nil {
+			continue
+		}
+		var userIDs []string
+		err = json.Unmarshal(raw[transition.Action], &userIDs)
+		if err != nil {
+			return errorResponse(badRequest("OrderUpdate", transition.Action+" must be an array of UserIDs"))
+		}
 
-	logger.Info("[OrderUpdate]-----order read2 2 start---------")
-	sc.OrderRead2(stub, []string{ticketID.(string)})
-	logger.Info("[OrderUpdate]-----order read2 2 start---------")
+		for _, userID := range userIDs {
+			result := OrderTransitionResult{UserID: userID, Action: transition.Action}
+			order, ok := pending[userID]
+			if !ok {
+				stored, err := retrieveOrder(stub, ticketID, userID)
+				if err != nil || stored == nil {
+					result.Code = ErrNotFound
+					result.Error = "Order of " + userID + " on ticket " + ticketID + " does not exist"
+					results = append(results, result)
+					continue
+				}
+				order = *stored
+			}
 
-	// to do
-	return shim.Success(nil)
-}
+			result.From = orderStatusName(order.Status)
+			oldStatus := order.Status
+			order, refused := transition.apply(caller, ticket, order)
+			if refused != nil {
+				result.Code = refused.Code
+				result.Error = refused.Message
+				results = append(results, result)
+				continue
+			}
+			if orderStatuses[order.Status].Seat && !orderStatuses[oldStatus].Seat {
+				full, err := ticketFull(stub, ticket, pending)
+				if err != nil {
+					return errorResponse(err)
+				}
+				if full {
+					result.Code = ErrCapacityReached
+					result.Error = "All " + strconv.Itoa(ticket.Capacity) + " seats of ticket " + ticketID + " are taken"
+					results = append(results, result)
+					continue
+				}
+			}
+			_, err = OrderSaving(stub, order)
+			if err != nil {
+				return errorResponse(err)
+			}
+			pending[userID] = order
+			result.To = orderStatusName(order.Status)
+			results = append(results, result)
 
+			err = emitEvent(stub, ExchainEvent{Name: EventOrderUpdated, TicketID: ticketID, UserID: userID,
+				OldStatus: intPtr(oldStatus), NewStatus: intPtr(order.Status)})
+			if err != nil {
+				return errorResponse(err)
+			}
 
-func (sc *SmartContract) AutoUpdateTicketStatus(stub shim.ChaincodeStubInterface, args string) peer.Response {
-	var maxStatus = 0
-	var ticketID =  args
-	var order Order
-	var ticket Ticket
-	logger.Info("AutoUpdateTicketStatus ticketID:", ticketID)
-	// Get all order to get max status
-	orderInterator, err :=
-	stub.GetStateByPartialCompositeKey("Order", []string{ticketID})
+			if order.Status == OrderAwarded {
+				awarded = append(awarded, userID)
+			}
+		}
+	}
 
+	// ==== Seats freed by closed or withdrawn orders go to the waitlist ====
+	promoted, err := promoteWaitlist(stub, ticket, pending)
 	if err != nil {
-		logger.Info("AutoUpdateTicketStatus Error:", err)
+		return errorResponse(err)
 	}
+	results = append(results, promoted...)
 
-	var lognum = 1
-	logger.Info("AutoUpdateTicketStatus orderInterator 1 :", orderInterator)
+	// ==== Award users whose order just moved to Awarded, by the award policy of the ticket ====
+	paid, err := award(stub, ticket, awarded, pending)
+	if err != nil {
+		return errorResponse(err)
+	}
+	ticket.Awarded += paid
 
-	queryResponse, _ := orderInterator.Next()
-	json.Unmarshal(queryResponse.Value, &order)
+	// ==== update ticket status ====
+	_, err = updateTicketStatus(stub, ticket, pending)
+	if err != nil {
+		return errorResponse(err)
+	}
 
-	if maxStatus < order.Status{
-		maxStatus = order.Status
+	resultAsBytes, err := json.Marshal(map[string]interface{}{"TicketID": ticketID, "Results": results})
+	if err != nil {
+		return errorResponse(errInternal("OrderUpdate: " + err.Error()))
 	}
-	logger.Info("AutoUpdateTicketStatus order  : ", lognum, order)
+	return shim.Success(resultAsBytes)
+}
 
-	logger.Info("AutoUpdateTicketStatus Interator for start...")
-	for orderInterator.HasNext() {
-		queryResponse, _ := orderInterator.Next()
+//Helper: set the ticket status from its orders, see ticketStatusFromOrders
+func refreshTicketStatus(stub shim.ChaincodeStubInterface, ticketID string, pending map[string]Order) ([]byte, error) {
+	var ticket Ticket
+	ticketAsBytes, err := stub.GetState(ticketKey(stub, ticketID))
+	if err != nil {
+		return nil, errors.New("refreshTicketStatus: Error getting ticket " + ticketID)
+	}
+	if ticketAsBytes == nil {
+		return nil, errNotFound(TicketObjectType, ticketID)
+	}
+	err = json.Unmarshal(ticketAsBytes, &ticket)
+	if err != nil {
+		return nil, errors.New("refreshTicketStatus: " + err.Error())
+	}
+	return updateTicketStatus(stub, ticket, pending)
+}
 
-		json.Unmarshal(queryResponse.Value, &order)
+//Helper: store ticket with the status of its orders, ticket may carry other changes of this transaction
+func updateTicketStatus(stub shim.ChaincodeStubInterface, ticket Ticket, pending map[string]Order) ([]byte, error) {
+	ticketID := ticket.TicketID
+	// ==== an expired ticket keeps its status, its orders were closed with it ====
+	if ticket.Status == Expired {
+		return saveTicket(stub, ticket)
+	}
+	var err error
+	oldStatus := ticket.Status
+	ticket.Status, err = ticketStatusFromOrders(stub, ticketID, pending)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("updateTicketStatus:", ticketID, ticket.Status)
+	ticketAsBytes, err := saveTicket(stub, ticket)
+	if err != nil {
+		return nil, err
+	}
 
-		lognum = lognum + 1
-		logger.Info("AutoUpdateTicketStatus order :", lognum, order)
-		if maxStatus < order.Status{
-			maxStatus = order.Status
+	if ticket.Status != oldStatus {
+		err = emitEvent(stub, ExchainEvent{Name: EventTicketUpdated, TicketID: ticketID, UserID: ticket.UserID,
+			OldStatus: intPtr(oldStatus), NewStatus: intPtr(ticket.Status)})
+		if err != nil {
+			return nil, err
 		}
 	}
+	return ticketAsBytes, nil
+}
 
-	ticketAsBytes, _  := stub.GetState(ticketID)
-	json.Unmarshal(ticketAsBytes, &ticket)
-
-	ticket.Status = maxStatus
-	logger.Info("AutoUpdateTicketStatus:", maxStatus)
-
-	ticketAsBytes, _ = json.Marshal(ticket)
-	stub.PutState(ticket.TicketID, ticketAsBytes)
+func (sc *SmartContract) AutoUpdateTicketStatus(stub shim.ChaincodeStubInterface, args string) peer.Response {
+	ticketAsBytes, err := refreshTicketStatus(stub, args, nil)
+	if err != nil {
+		return errorResponse(err)
+	}
 	return shim.Success(ticketAsBytes)
 }
 
+//Helper: parse an RFC 3339 timestamp into UTC, the same on every endorser whatever its time zone
 func string2time(st string) (theTime time.Time, err error) {
-	timeFormated := "2018-11-26 18:05:00"
-	loc, _ := time.LoadLocation("Local")
-	theTime, err = time.ParseInLocation(st, timeFormated, loc)
+	theTime, err = time.Parse(time.RFC3339, st)
 	if err != nil {
 		return theTime, err
 	}
-	return theTime, nil
+	return theTime.UTC(), nil
+}
+
+//Helper: transaction timestamp, identical on every endorser
+func txTime(stub shim.ChaincodeStubInterface) (time.Time, error) {
+	timestamp, err := stub.GetTxTimestamp()
+	if err != nil {
+		return time.Time{}, errors.New("txTime: Error getting transaction timestamp: " + err.Error())
+	}
+	return time.Unix(timestamp.Seconds, int64(timestamp.Nanos)).UTC(), nil
 }
\ No newline at end of file
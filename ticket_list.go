@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+// DefaultTicketPageSize - page size of TicketList when none is given
+const DefaultTicketPageSize = 20
+
+// MaxTicketPageSize - largest page TicketList returns
+const MaxTicketPageSize = 100
+
+// TicketFilter information, first argument of TicketList; fields left out match every ticket
+//
+//	Status, Type:               exact match
+//	UserID:                     creator of the ticket
+//	DeadlineFrom, DeadlineTo:   inclusive range of Ticket_Deadline, tickets without deadline do not match
+//	MinValue, MaxValue:         inclusive range of Ticket_Value
+type TicketFilter struct {
+	Status       *int       `json:"Status"`
+	Type         *int       `json:"Type"`
+	UserID       string     `json:"UserID"`
+	DeadlineFrom *time.Time `json:"DeadlineFrom"`
+	DeadlineTo   *time.Time `json:"DeadlineTo"`
+	MinValue     *int       `json:"MinValue"`
+	MaxValue     *int       `json:"MaxValue"`
+}
+
+// TicketPage information, response of TicketList
+//
+//	Items:      matching tickets in key order
+//	Count:      len(Items)
+//	Bookmark:   pass it to get the next page, empty on the last page
+type TicketPage struct {
+	Items    []Ticket `json:"Items"`
+	Count    int      `json:"Count"`
+	Bookmark string   `json:"Bookmark"`
+}
+
+func (f TicketFilter) matches(ticket Ticket) bool {
+	if f.Status != nil && ticket.Status != *f.Status {
+		return false
+	}
+	if f.Type != nil && ticket.Type != *f.Type {
+		return false
+	}
+	if f.UserID != "" && ticket.UserID != f.UserID {
+		return false
+	}
+	if (f.DeadlineFrom != nil || f.DeadlineTo != nil) && ticket.DeadLine.IsZero() {
+		return false
+	}
+	if f.DeadlineFrom != nil && ticket.DeadLine.Before(*f.DeadlineFrom) {
+		return false
+	}
+	if f.DeadlineTo != nil && ticket.DeadLine.After(*f.DeadlineTo) {
+		return false
+	}
+	if f.MinValue != nil && ticket.Value < *f.MinValue {
+		return false
+	}
+	if f.MaxValue != nil && ticket.Value > *f.MaxValue {
+		return false
+	}
+	return true
+}
+
+// Helper: add the tickets of iterator matching filter to page
+func collectTickets(iterator shim.StateQueryIteratorInterface, filter TicketFilter, page *TicketPage) error {
+	for iterator.HasNext() {
+		queryResponse, err := iterator.Next()
+		if err != nil {
+			return errInternal("listTickets: " + err.Error())
+		}
+		var ticket Ticket
+		err = json.Unmarshal(queryResponse.Value, &ticket)
+		if err != nil {
+			return errInternal("listTickets: Corrupt ticket " + queryResponse.Key)
+		}
+		if filter.matches(ticket) {
+			page.Items = append(page.Items, ticket)
+		}
+	}
+	return nil
+}
+
+// Helper: the tickets matching filter from bookmark on, at most pageSize of them, all if pageSize is 0.
+// The peer pages through the tickets; each round asks for as many as the page still misses, so the
+// bookmark the peer returns last is where the next page starts.
+func listTickets(stub shim.ChaincodeStubInterface, filter TicketFilter, pageSize int, bookmark string) (TicketPage, error) {
+	page := TicketPage{Items: []Ticket{}}
+	if pageSize == 0 {
+		iterator, err := stub.GetStateByPartialCompositeKey(TicketObjectType, []string{})
+		if err != nil {
+			return page, errInternal("listTickets: " + err.Error())
+		}
+		defer iterator.Close()
+		err = collectTickets(iterator, filter, &page)
+		page.Count = len(page.Items)
+		return page, err
+	}
+
+	for {
+		iterator, metadata, err := stub.GetStateByPartialCompositeKeyWithPagination(TicketObjectType, []string{},
+			int32(pageSize-len(page.Items)), bookmark)
+		if err != nil {
+			return page, errInternal("listTickets: " + err.Error())
+		}
+		err = collectTickets(iterator, filter, &page)
+		iterator.Close()
+		if err != nil {
+			return page, err
+		}
+		bookmark = metadata.Bookmark
+		if len(page.Items) == pageSize || bookmark == "" {
+			break
+		}
+	}
+	page.Bookmark = bookmark
+	page.Count = len(page.Items)
+	return page, nil
+}
+
+// Query Route: TicketList
+//
+//	args: [filter [, pageSize [, bookmark]]], filter is a TicketFilter JSON, every argument may be empty
+//	returns a TicketPage
+func (sc *SmartContract) TicketList(stub shim.ChaincodeStubInterface, args []string) peer.Response {
+	var filter TicketFilter
+	pageSize := DefaultTicketPageSize
+	bookmark := ""
+	if len(args) > 0 && args[0] != "" {
+		err := json.Unmarshal([]byte(args[0]), &filter)
+		if err != nil {
+			return errorResponse(badRequest("TicketList", err.Error()))
+		}
+	}
+	if len(args) > 1 && args[1] != "" {
+		size, err := strconv.Atoi(args[1])
+		if err != nil || size <= 0 || size > MaxTicketPageSize {
+			return errorResponse(badRequest("TicketList", "pageSize must be between 1 and "+strconv.Itoa(MaxTicketPageSize)))
+		}
+		pageSize = size
+	}
+	if len(args) > 2 {
+		bookmark = args[2]
+	}
+
+	var problems []string
+	if filter.DeadlineFrom != nil && filter.DeadlineTo != nil && filter.DeadlineFrom.After(*filter.DeadlineTo) {
+		problems = append(problems, "DeadlineFrom must not be after DeadlineTo")
+	}
+	if filter.MinValue != nil && filter.MaxValue != nil && *filter.MinValue > *filter.MaxValue {
+		problems = append(problems, "MinValue must not be greater than MaxValue")
+	}
+	if len(problems) > 0 {
+		return errorResponse(badRequest("TicketList", problems...))
+	}
+
+	page, err := listTickets(stub, filter, pageSize, bookmark)
+	if err != nil {
+		return errorResponse(err)
+	}
+	pageAsBytes, err := json.Marshal(page)
+	if err != nil {
+		return errorResponse(errInternal("TicketList: " + err.Error()))
+	}
+	return shim.Success(pageAsBytes)
+}
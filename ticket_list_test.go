@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"testing"
+)
+
+func (c *testChain) createTicket(userID string, ticketType int, value int, deadline string) string {
+	c.t.Helper()
+	fields := map[string]interface{}{"Ticket_Title": "Ticket of " + userID, "Ticket_Type": ticketType, "Ticket_Value": value, "Ticket_UserID": userID}
+	if deadline != "" {
+		fields["Ticket_Deadline"] = deadline
+	}
+	bytes, _ := json.Marshal(fields)
+	c.mustInvoke(userID, "TicketCreate", string(bytes))
+	return c.lastTxID()
+}
+
+func (c *testChain) ticketList(args ...string) TicketPage {
+	c.t.Helper()
+	var page TicketPage
+	c.mustUnmarshal(c.mustInvoke("", "TicketList", args...), &page)
+	if page.Count != len(page.Items) {
+		c.t.Fatalf("Count %d of %d items", page.Count, len(page.Items))
+	}
+	return page
+}
+
+func ticketIDs(page TicketPage) []string {
+	var ids []string
+	for _, ticket := range page.Items {
+		ids = append(ids, ticket.TicketID)
+	}
+	return ids
+}
+
+func TestTicketListFilters(t *testing.T) {
+	c := newExchain(t)
+	early := c.createTicket(owner, 0, 10, "2030-01-01T00:00:00Z")
+	late := c.createTicket(owner, 1, 50, "2030-06-01T00:00:00Z")
+	open := c.createTicket(applicant, 1, 30, "")
+
+	cases := []struct {
+		filter string
+		want   []string
+	}{
+		{``, []string{early, late, open}},
+		{`{}`, []string{early, late, open}},
+		{`{"UserID": "` + owner + `"}`, []string{early, late}},
+		{`{"Type": 1}`, []string{late, open}},
+		{`{"Status": 1}`, []string{early, late, open}},
+		{`{"Status": 3}`, nil},
+		{`{"MinValue": 30}`, []string{late, open}},
+		{`{"MinValue": 10, "MaxValue": 30}`, []string{early, open}},
+		{`{"DeadlineFrom": "2030-01-01T00:00:00Z"}`, []string{early, late}},
+		{`{"DeadlineTo": "2030-03-01T00:00:00+01:00"}`, []string{early}},
+		{`{"UserID": "` + owner + `", "Type": 1, "MaxValue": 50}`, []string{late}},
+	}
+	for _, tc := range cases {
+		page := c.ticketList(tc.filter)
+		if got := ticketIDs(page); len(got) != len(tc.want) || (len(got) > 0 && !equalStrings(got, tc.want)) {
+			t.Errorf("filter %s: got %v, expected %v", tc.filter, got, tc.want)
+		}
+		if page.Bookmark != "" {
+			t.Errorf("filter %s: a single page has no bookmark", tc.filter)
+		}
+	}
+
+	c.mustFail("MinValue must not be greater than MaxValue", "", "TicketList", `{"MinValue": 10, "MaxValue": 5}`)
+	c.mustFail("DeadlineFrom must not be after DeadlineTo", "", "TicketList",
+		`{"DeadlineFrom": "2030-02-01T00:00:00Z", "DeadlineTo": "2030-01-01T00:00:00Z"}`)
+	c.mustFail("field DeadlineFrom must be an RFC 3339 date-time", "", "TicketList", `{"DeadlineFrom": "2030-02-01"}`)
+	c.mustFail("unknown field Creator", "", "TicketList", `{"Creator": "`+owner+`"}`)
+	c.mustFail("pageSize must be between 1 and 100", "", "TicketList", "", "0")
+	c.mustFail("pageSize must be between 1 and 100", "", "TicketList", "", "101")
+}
+
+func TestTicketListPages(t *testing.T) {
+	c := newExchain(t)
+	var created []string
+	for i := 0; i < 5; i++ {
+		created = append(created, c.createTicket(owner, i%2, 10, ""))
+	}
+	c.createTicket(applicant, 0, 10, "")
+
+	// pages of two owner tickets: 2 + 2 + 1
+	var listed []string
+	bookmark := ""
+	for pages := 1; ; pages++ {
+		page := c.ticketList(`{"UserID": "`+owner+`"}`, "2", bookmark)
+		listed = append(listed, ticketIDs(page)...)
+		if page.Bookmark == "" {
+			if pages != 3 || page.Count != 1 {
+				t.Errorf("expected the third page to be the last with one ticket, page %d has %d", pages, page.Count)
+			}
+			break
+		}
+		if page.Count != 2 || pages > 3 {
+			t.Fatalf("unexpected page %d: %+v", pages, page)
+		}
+		bookmark = page.Bookmark
+	}
+	// pages follow the key order
+	sort.Strings(created)
+	if !equalStrings(listed, created) {
+		t.Errorf("listed %v, expected %v", listed, created)
+	}
+
+	// a full last page has no bookmark either
+	page := c.ticketList(`{"Type": 1}`, "2")
+	if page.Count != 2 || page.Bookmark != "" {
+		t.Errorf("unexpected page %+v", page)
+	}
+
+	// the default page size
+	for i := 0; i < DefaultTicketPageSize; i++ {
+		c.createTicket(owner, 0, 10, "")
+	}
+	if page = c.ticketList(); page.Count != DefaultTicketPageSize || page.Bookmark == "" {
+		t.Errorf("unexpected default page of %d tickets", page.Count)
+	}
+
+	// TicketRead2 still returns every ticket as an array
+	var tickets []Ticket
+	c.mustUnmarshal(c.mustInvoke("", "TicketRead2"), &tickets)
+	if len(tickets) != 6+DefaultTicketPageSize {
+		t.Errorf("TicketRead2 returned %d tickets", len(tickets))
+	}
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,641 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	"github.com/hyperledger/fabric/protos/peer"
+)
+
+const (
+	admin     = "i000001"
+	owner     = "i000002"
+	applicant = "i000003"
+	other     = "i000004"
+)
+
+// newExchain - chain with an admin in MD_office, a ticket owner in HANA and two applicants in SMB
+func newExchain(t *testing.T) *testChain {
+	c := newTestChain(t)
+	c.register(admin, MD_office, true)
+	c.register(owner, HANA, false)
+	c.register(applicant, SMB, false)
+	c.register(other, SMB, false)
+	return c
+}
+
+func orderUpdate(ticketID string, action string, userIDs ...string) string {
+	bytes, _ := json.Marshal(map[string]interface{}{"TicketID": ticketID, action: userIDs})
+	return string(bytes)
+}
+
+func (c *testChain) orderUpdate(userID string, ticketID string, action string, userIDs ...string) []OrderTransitionResult {
+	c.t.Helper()
+	var result struct {
+		TicketID string
+		Results  []OrderTransitionResult
+	}
+	c.mustUnmarshal(c.mustInvoke(userID, "OrderUpdate", orderUpdate(ticketID, action, userIDs...)), &result)
+	return result.Results
+}
+
+func TestInit(t *testing.T) {
+	c := newTestChain(t)
+
+	var lobs []LoB
+	c.mustUnmarshal(c.mustInvoke("", "LoBReadAll"), &lobs)
+	if len(lobs) != len(defaultLoBs) {
+		t.Fatalf("expected %d LoBs, got %d", len(defaultLoBs), len(lobs))
+	}
+	for LoBID, lob := range lobs {
+		if lob.LoBID != LoBID || lob.Name != defaultLoBs[LoBID] || lob.TotalCredit != 0 {
+			t.Errorf("unexpected LoB %+v", lob)
+		}
+	}
+	if string(c.mustInvoke("", "readAllParticipant")) != "[]" {
+		t.Error("expected no participants")
+	}
+
+	// Init is run again on upgrade and keeps the state
+	c.register(admin, MD_office, true)
+	response := c.run("", func(stub *testStub) peer.Response { return c.cc.Init(stub) })
+	if response.Status != shim.OK {
+		t.Fatalf("second Init failed: %s", response.Message)
+	}
+	c.mustInvoke("", "readParticipant", admin)
+}
+
+func TestUnknownFunction(t *testing.T) {
+	c := newExchain(t)
+	c.mustFail("Received unknown function invocation", admin, "CreditUpdate", applicant, "10", "1")
+	c.mustFail("Received unknown function invocation", admin, "")
+}
+
+func TestParticipants(t *testing.T) {
+	c := newExchain(t)
+
+	var participant Participant
+	c.mustUnmarshal(c.mustInvoke("", "readParticipant", owner), &participant)
+	if participant.UserID != owner || participant.LoBID != HANA || participant.MSPID != testMSPID {
+		t.Errorf("unexpected participant %+v", participant)
+	}
+	if participant.Password != "" {
+		t.Error("password must not be returned")
+	}
+
+	var participants []Participant
+	c.mustUnmarshal(c.mustInvoke("", "readAllParticipant"), &participants)
+	if len(participants) != 4 {
+		t.Errorf("expected 4 participants, got %d", len(participants))
+	}
+	if c.credit(owner) != 0 {
+		t.Error("a new participant starts with 0 credit")
+	}
+
+	// ==== registration errors ====
+	c.mustFail("Participant i000002 already exists", owner, "addParticipant", participantJSON(owner, HANA, false))
+	c.mustFail("Bad request: addParticipant argument Participant must be a JSON object", "i000005", "addParticipant", "{not json")
+	c.mustFail("Bad request: addParticipant missing argument Participant", "i000005", "addParticipant")
+	c.mustFail("missing field Participant_UserName", "i000005", "addParticipant", `{"Participant_UserID": "i000005"}`)
+	c.mustFail("can not self-register an admin", "i000005", "addParticipant", participantJSON("i000005", HANA, true))
+	c.mustFail("Forbidden", owner, "addParticipant", participantJSON("i000005", HANA, false))
+	// the password never travels in the arguments
+	c.mustFail("unknown field Participant_Password", "i000005", "addParticipant",
+		`{"Participant_UserID": "i000005", "Participant_UserName": "x", "Participant_Password": "x", "Participant_IsAdmin": false, "Participant_LoBID": 1}`)
+	c.mustFail("missing transient field Password", "i000005", "addParticipant", participantJSON("i000005", HANA, false))
+	c.secret("Password", "x", "Salt", "too short")
+	c.mustFail("transient field Salt must be 16 to 1024 bytes", "i000005", "addParticipant", participantJSON("i000005", HANA, false))
+	c.mustFail("Forbidden", "", "addParticipant", participantJSON("i000005", HANA, false))
+
+	// admins register others, from their own MSP unless another one is known from a registered participant
+	c.passwordSecret("i000005").mustInvoke(admin, "addParticipant", participantJSON("i000005", IoT, false))
+	foreign := `{"Participant_UserID": "i000006", "Participant_UserName": "x", "Participant_IsAdmin": false, "Participant_LoBID": 1, "Participant_MSPID": "Org2MSP"}`
+	c.passwordSecret("i000006")
+	c.mustFail("Participant_MSPID Org2MSP is not the MSP of a registered participant", admin, "addParticipant", foreign)
+	c.run("", func(stub *testStub) peer.Response {
+		bytes, _ := json.Marshal(Participant{UserID: "i000007", UserName: "Org2 user", LoBID: HANA, MSPID: "Org2MSP"})
+		stub.PutState(participantKey(stub, "i000007"), bytes)
+		var index ReadingIDIndex
+		json.Unmarshal(stub.State["readingIDIndex"], &index)
+		bytes, _ = json.Marshal(ReadingIDIndex{UserIDs: append(index.UserIDs, "i000007")})
+		stub.PutState("readingIDIndex", bytes)
+		return shim.Success(nil)
+	})
+	c.passwordSecret("i000006").mustInvoke(admin, "addParticipant", foreign)
+	c.mustUnmarshal(c.mustInvoke("", "readParticipant", "i000006"), &participant)
+	if participant.MSPID != "Org2MSP" {
+		t.Errorf("unexpected MSP %q", participant.MSPID)
+	}
+
+	// ==== reads of missing participants ====
+	c.mustFail("Participant i999999 does not exist", "", "readParticipant", "i999999")
+
+	// ==== update ====
+	update := `{"Participant_UserID": "` + owner + `", "Participant_UserName": "Renamed", "Participant_IsAdmin": false, "Participant_LoBID": 1}`
+	c.mustInvoke(owner, "updateParticipant", update)
+	c.mustUnmarshal(c.mustInvoke("", "readParticipant", owner), &participant)
+	if participant.UserName != "Renamed" || participant.MSPID != testMSPID {
+		t.Errorf("unexpected participant after update %+v", participant)
+	}
+	c.mustFail("Forbidden", applicant, "updateParticipant", update)
+	c.mustFail("Forbidden", owner, "updateParticipant",
+		`{"Participant_UserID": "`+owner+`", "Participant_UserName": "x", "Participant_IsAdmin": true, "Participant_LoBID": 1}`)
+	c.mustFail("missing field", owner, "updateParticipant", `{"Participant_UserID": "`+owner+`"}`)
+	c.mustInvoke(admin, "updateParticipant",
+		`{"Participant_UserID": "`+owner+`", "Participant_UserName": "x", "Participant_IsAdmin": true, "Participant_LoBID": 1}`)
+
+	// ==== delete ====
+	c.mustFail("Forbidden", applicant, "deleteParticipant", other)
+	c.mustFail("Participant i999999 does not exist", admin, "deleteParticipant", "i999999")
+	c.mustInvoke(admin, "deleteParticipant", other)
+	c.mustFail("Participant i000004 does not exist", "", "readParticipant", other)
+	c.mustFail("No participant registered", other, "TicketCreate", ticketJSON(other, 10))
+}
+
+func TestPasswords(t *testing.T) {
+	c := newExchain(t)
+
+	verify := func(password string) bool { return c.credentialsValid(owner, password) }
+	if !verify("pw-" + owner) {
+		t.Error("the registered password must be valid")
+	}
+	if verify("wrong") {
+		t.Error("a wrong password must be invalid")
+	}
+
+	// the record is private data, public state keeps neither the hash nor the salt
+	key, _ := passwordKey(c.stub, owner)
+	if c.stub.State[key] != nil || c.stub.PvtState[CredentialCollection][key] == nil {
+		t.Error("the password record must be kept in the credential collection only")
+	}
+
+	change := `{"UserID": "` + owner + `"}`
+	c.secret("OldPassword", "pw-"+owner, "NewPassword", "secret", "Salt", "another random salt")
+	c.mustFail("Forbidden", applicant, "ChangePassword", change)
+	c.secret("OldPassword", "wrong", "NewPassword", "secret", "Salt", "another random salt")
+	c.mustFail("old password does not match", owner, "ChangePassword", change)
+	c.secret("OldPassword", "pw-"+owner, "Salt", "another random salt")
+	c.mustFail("missing transient field NewPassword", owner, "ChangePassword", change)
+	c.mustFail("unknown field OldPassword", owner, "ChangePassword", `{"UserID": "`+owner+`", "OldPassword": "pw-`+owner+`"}`)
+	c.secret("OldPassword", "pw-"+owner, "NewPassword", "secret", "Salt", "another random salt")
+	c.mustInvoke(owner, "ChangePassword", change)
+	if !verify("secret") || verify("pw-"+owner) {
+		t.Error("ChangePassword did not replace the password")
+	}
+
+	c.mustFail("must be a JSON object", "", "VerifyCredentials", "{")
+	c.mustFail("missing transient field Password", "", "VerifyCredentials", `{"UserID": "`+owner+`"}`)
+	// unknown users are just invalid, VerifyCredentials does not reveal who is registered
+	if c.credentialsValid("i999999", "x") {
+		t.Error("an unknown user must be invalid")
+	}
+}
+
+func TestTickets(t *testing.T) {
+	c := newExchain(t)
+
+	c.mustInvoke(owner, "TicketCreate", ticketJSON(owner, 50))
+	ticketID := c.lastTxID()
+	if events := c.events(); len(events) == 0 || events[len(events)-1] != EventTicketCreated {
+		t.Errorf("expected %s, got %v", EventTicketCreated, events)
+	}
+
+	ticket := c.ticket(ticketID)
+	if ticket.TicketID != ticketID || ticket.Status != Applied || ticket.Value != 50 || ticket.UserID != owner {
+		t.Errorf("unexpected ticket %+v", ticket)
+	}
+
+	// ==== create errors ====
+	c.mustFail("Forbidden", applicant, "TicketCreate", ticketJSON(owner, 50))
+	c.mustFail("must be a JSON object", owner, "TicketCreate", "{")
+	c.mustFail("missing argument Ticket", owner, "TicketCreate")
+	c.mustFail("missing field Ticket_Title", owner, "TicketCreate", `{"Ticket_UserID": "`+owner+`"}`)
+	c.mustFail("", "", "TicketRead", "no-such-ticket")
+
+	// ==== update ====
+	ticket.Title = "Review the release notes again"
+	ticket.Value = 60
+	c.mustInvoke(owner, "TicketUpdate", ticketUpdateJSON(ticket))
+	if updated := c.ticket(ticketID); updated.Title != ticket.Title || updated.Value != 60 {
+		t.Errorf("unexpected ticket after update %+v", updated)
+	}
+	c.mustFail("Forbidden", applicant, "TicketUpdate", ticketUpdateJSON(ticket))
+	c.mustInvoke(admin, "TicketUpdate", ticketUpdateJSON(ticket))
+	bytes, _ := json.Marshal(ticket)
+	c.mustFail("unknown field Ticket_Status", owner, "TicketUpdate", string(bytes))
+
+	ticket.UserID = applicant
+	c.mustFail("can not change Ticket_UserID", owner, "TicketUpdate", ticketUpdateJSON(ticket))
+
+	ticket.TicketID = "no-such-ticket"
+	c.mustFail("does not exist", owner, "TicketUpdate", ticketUpdateJSON(ticket))
+
+	// ==== list ====
+	c.mustInvoke(applicant, "TicketCreate", ticketJSON(applicant, 5))
+	secondID := c.lastTxID()
+	var tickets []Ticket
+	c.mustUnmarshal(c.mustInvoke("", "TicketRead2"), &tickets)
+	if len(tickets) != 2 {
+		t.Fatalf("expected 2 tickets, got %d", len(tickets))
+	}
+
+	// ==== status from orders ====
+	var refreshed Ticket
+	c.mustUnmarshal(c.mustInvoke(owner, "AutoUpdateTicketStatus", ticketID), &refreshed)
+	if refreshed.Status != Applied {
+		t.Errorf("a ticket without orders stays Applied, got %d", refreshed.Status)
+	}
+	c.mustFail("Forbidden", applicant, "AutoUpdateTicketStatus", ticketID)
+	c.mustFail("does not exist", admin, "AutoUpdateTicketStatus", "no-such-ticket")
+
+	// ==== delete ====
+	c.mustFail("Forbidden", owner, "TicketDelete", secondID)
+	c.mustFail("missing argument TicketID", owner, "TicketDelete")
+	c.mustInvoke(applicant, "TicketDelete", secondID)
+	c.mustFail("", "", "TicketRead", secondID)
+	c.mustUnmarshal(c.mustInvoke("", "TicketRead2"), &tickets)
+	if len(tickets) != 1 || tickets[0].TicketID != ticketID {
+		t.Errorf("unexpected tickets after delete %+v", tickets)
+	}
+}
+
+func TestOrders(t *testing.T) {
+	c := newExchain(t)
+	c.mustInvoke(owner, "TicketCreate", ticketJSON(owner, 50))
+	ticketID := c.lastTxID()
+
+	apply := `{"TicketID": "` + ticketID + `", "UserID": "` + applicant + `"}`
+	c.mustInvoke(applicant, "OrderCreate", apply)
+	if order := c.order(ticketID, applicant); order.Status != OrderApplied {
+		t.Errorf("unexpected order %+v", order)
+	}
+	c.mustFail("has already applied for ticket", applicant, "OrderCreate", apply)
+	c.mustFail("Forbidden", other, "OrderCreate", apply)
+	c.mustFail("missing field TicketID", applicant, "OrderCreate", `{"UserID": "`+applicant+`"}`)
+	c.mustFail("must be a JSON object", applicant, "OrderCreate", "[")
+
+	c.mustInvoke(other, "OrderCreate", `{"TicketID": "`+ticketID+`", "UserID": "`+other+`"}`)
+	var orders []Order
+	c.mustUnmarshal(c.mustInvoke("", "OrderRead2", ticketID), &orders)
+	if len(orders) != 2 {
+		t.Errorf("expected 2 orders, got %d", len(orders))
+	}
+	if e := c.failure("", "OrderRead", ticketID, "i999999"); e.Code != ErrNotFound || e.ID != ticketID+"/i999999" {
+		t.Errorf("expected no order for an unknown user, got %+v", e)
+	}
+
+	// ==== transitions ====
+	results := c.orderUpdate(applicant, ticketID, "Confirm", applicant)
+	if !strings.Contains(results[0].Error, "Forbidden") {
+		t.Errorf("an applicant can not confirm its own order: %+v", results)
+	}
+	results = c.orderUpdate(owner, ticketID, "Confirm", applicant, "i999999")
+	if results[0].Error != "" || results[0].To != "Confirmed" || !strings.Contains(results[1].Error, "does not exist") {
+		t.Errorf("unexpected results %+v", results)
+	}
+	if ticket := c.ticket(ticketID); ticket.Status != Ongoing {
+		t.Errorf("a confirmed order makes the ticket Ongoing, got %d", ticket.Status)
+	}
+
+	results = c.orderUpdate(owner, ticketID, "Done", other)
+	if !strings.Contains(results[0].Error, "Invalid transition") {
+		t.Errorf("Done needs a confirmed order: %+v", results)
+	}
+	results = c.orderUpdate(other, ticketID, "Withdraw", other)
+	if results[0].To != "Withdrawn" {
+		t.Errorf("unexpected results %+v", results)
+	}
+	results = c.orderUpdate(owner, ticketID, "Reject", applicant)
+	if !strings.Contains(results[0].Error, "Invalid transition") {
+		t.Errorf("a confirmed order can not be rejected: %+v", results)
+	}
+
+	// ==== request errors ====
+	c.mustFail("missing field TicketID", owner, "OrderUpdate", `{"Confirm": ["`+applicant+`"]}`)
+	c.mustFail("Ticket no-such-ticket does not exist", owner, "OrderUpdate", orderUpdate("no-such-ticket", "Confirm", applicant))
+	c.mustFail("field Confirm must be an array of strings", owner, "OrderUpdate", `{"TicketID": "`+ticketID+`", "Confirm": "`+applicant+`"}`)
+	c.mustFail("OrderUpdate", owner, "OrderUpdate", "{")
+	c.mustFail("No participant registered", "i999999", "OrderUpdate", orderUpdate(ticketID, "Confirm", applicant))
+}
+
+func TestTicketOrderAwardFlow(t *testing.T) {
+	c := newExchain(t)
+	c.mustInvoke(owner, "TicketCreate", ticketJSON(owner, 50))
+	ticketID := c.lastTxID()
+
+	c.mustInvoke(applicant, "OrderCreate", `{"TicketID": "`+ticketID+`", "UserID": "`+applicant+`"}`)
+	c.mustInvoke(other, "OrderCreate", `{"TicketID": "`+ticketID+`", "UserID": "`+other+`"}`)
+	c.orderUpdate(owner, ticketID, "Confirm", applicant, other)
+	c.orderUpdate(owner, ticketID, "Done", applicant, other)
+	if ticket := c.ticket(ticketID); ticket.Status != Done {
+		t.Errorf("expected ticket Done, got %d", ticket.Status)
+	}
+
+	// only admins award
+	results := c.orderUpdate(owner, ticketID, "Award", applicant)
+	if !strings.Contains(results[0].Error, "Forbidden") {
+		t.Errorf("the ticket owner can not award: %+v", results)
+	}
+	c.events()
+	results = c.orderUpdate(admin, ticketID, "Award", applicant, other)
+	for _, result := range results {
+		if result.Error != "" || result.To != "Awarded" {
+			t.Errorf("unexpected result %+v", result)
+		}
+	}
+	if events := c.events(); len(events) != 1 || events[0] != EventBatch {
+		t.Errorf("expected one %s, got %v", EventBatch, events)
+	}
+
+	if ticket := c.ticket(ticketID); ticket.Status != Awarded {
+		t.Errorf("expected ticket Awarded, got %d", ticket.Status)
+	}
+	if order := c.order(ticketID, applicant); order.Status != OrderAwarded {
+		t.Errorf("expected order Awarded, got %d", order.Status)
+	}
+	// without award policy the two share the ticket value
+	if c.credit(applicant) != 25 || c.credit(other) != 25 || c.credit(owner) != 0 {
+		t.Errorf("unexpected credits %d %d %d", c.credit(applicant), c.credit(other), c.credit(owner))
+	}
+	if c.lobTotal(SMB) != 50 || c.lobTotal(HANA) != 0 {
+		t.Errorf("unexpected LoB totals SMB %d HANA %d", c.lobTotal(SMB), c.lobTotal(HANA))
+	}
+
+	// an awarded order is final and never credited twice
+	results = c.orderUpdate(admin, ticketID, "Award", applicant)
+	if !strings.Contains(results[0].Error, "Invalid transition") {
+		t.Errorf("unexpected results %+v", results)
+	}
+	c.mustFail("was already credited to", owner, "CreditAdd",
+		`{"userID": "`+applicant+`", "value": 50, "ticketID": "`+ticketID+`"}`)
+	if c.credit(applicant) != 25 {
+		t.Errorf("credit changed to %d", c.credit(applicant))
+	}
+
+	var journal struct {
+		Entries []JournalEntry
+		Count   int
+	}
+	c.mustUnmarshal(c.mustInvoke("", "CreditJournal", applicant), &journal)
+	if journal.Count != 1 || journal.Entries[0].Reason != JournalAward || journal.Entries[0].Ref != ticketID {
+		t.Errorf("unexpected journal %+v", journal)
+	}
+	var verify struct{ Consistent bool }
+	c.mustUnmarshal(c.mustInvoke("", "CreditVerify", applicant), &verify)
+	if !verify.Consistent {
+		t.Error("journal and balance disagree")
+	}
+}
+
+func TestCredits(t *testing.T) {
+	c := newExchain(t)
+
+	// ==== constant credit, admins only ====
+	c.mustInvoke(admin, "CreditAdd", `{"userID": "`+applicant+`", "value": 30, "ticketID": "creditADD"}`)
+	c.mustInvoke(admin, "CreditAdd", `{"userID": "`+owner+`", "value": 5, "ticketID": "creditADD"}`)
+	c.mustFail("Forbidden", owner, "CreditAdd", `{"userID": "`+owner+`", "value": 30, "ticketID": "creditADD"}`)
+	c.mustFail("does not exist", admin, "CreditAdd", `{"userID": "i999999", "value": 30, "ticketID": "creditADD"}`)
+	// debits have routes of their own
+	c.mustFail("field value must be at least 1", admin, "CreditAdd", `{"userID": "`+other+`", "value": -1, "ticketID": "creditADD"}`)
+	if c.credit(applicant) != 30 || c.lobTotal(SMB) != 30 || c.lobTotal(HANA) != 5 {
+		t.Errorf("unexpected credit %d, LoB totals SMB %d HANA %d", c.credit(applicant), c.lobTotal(SMB), c.lobTotal(HANA))
+	}
+
+	// ==== ticket credit, the ticket owner only, for an Awarded order within the ticket budget ====
+	c.mustInvoke(owner, "TicketCreate", ticketJSON(owner, 50))
+	ticketID := c.lastTxID()
+	c.mustFail("i000004 has no Awarded order on ticket", owner, "CreditAdd", `{"userID": "`+other+`", "value": 7, "ticketID": "`+ticketID+`"}`)
+	c.run("", func(stub *testStub) peer.Response {
+		// awarded before awards were credited
+		OrderSaving(stub, Order{TicketID: ticketID, UserID: other, Status: OrderAwarded})
+		return shim.Success(nil)
+	})
+	c.mustFail("field value must be at least 1", owner, "CreditAdd", `{"userID": "`+other+`", "value": -7, "ticketID": "`+ticketID+`"}`)
+	if e := c.failure(owner, "CreditAdd", `{"userID": "`+other+`", "value": 51, "ticketID": "`+ticketID+`"}`); e.Code != ErrBudgetExceeded {
+		t.Errorf("unexpected error %+v", e)
+	}
+	c.mustInvoke(owner, "CreditAdd", `{"userID": "`+other+`", "value": 7, "ticketID": "`+ticketID+`"}`)
+	awardTxID := c.lastTxID()
+	if c.ticket(ticketID).Awarded != 7 {
+		t.Errorf("unexpected ticket %+v", c.ticket(ticketID))
+	}
+	c.mustFail("Forbidden", applicant, "CreditAdd", `{"userID": "`+applicant+`", "value": 7, "ticketID": "`+ticketID+`"}`)
+	c.mustFail("missing field ticketID", owner, "CreditAdd", `{"userID": "`+other+`", "value": 7}`)
+
+	// ==== transfer ====
+	var transfer CreditTransferRecord
+	c.mustUnmarshal(c.mustInvoke(applicant, "CreditTransfer", `{"From": "`+applicant+`", "To": "`+owner+`", "Value": 20, "Memo": "thanks"}`), &transfer)
+	transferTxID := c.lastTxID()
+	if transfer.TxID != transferTxID || transfer.Value != 20 {
+		t.Errorf("unexpected transfer %+v", transfer)
+	}
+	if c.credit(applicant) != 10 || c.credit(owner) != 25 || c.lobTotal(SMB) != 17 || c.lobTotal(HANA) != 25 {
+		t.Errorf("unexpected credits after transfer %d %d, LoB totals SMB %d HANA %d",
+			c.credit(applicant), c.credit(owner), c.lobTotal(SMB), c.lobTotal(HANA))
+	}
+	c.mustFail("Forbidden", owner, "CreditTransfer", `{"From": "`+applicant+`", "To": "`+owner+`", "Value": 1}`)
+	c.mustFail("Insufficient credit", applicant, "CreditTransfer", `{"From": "`+applicant+`", "To": "`+owner+`", "Value": 11}`)
+	c.mustFail("field Value must be at least 1", applicant, "CreditTransfer", `{"From": "`+applicant+`", "To": "`+owner+`", "Value": 0}`)
+	c.mustFail("to yourself", applicant, "CreditTransfer", `{"From": "`+applicant+`", "To": "`+applicant+`", "Value": 1}`)
+	c.mustFail("Participant i999999 does not exist", applicant, "CreditTransfer", `{"From": "`+applicant+`", "To": "i999999", "Value": 1}`)
+
+	// ==== reverse the transfer on both sides ====
+	reverse := `{"UserID": "` + applicant + `", "TxID": "` + transferTxID + `", "Memo": "mistake"}`
+	c.mustFail("Forbidden", applicant, "CreditReverse", reverse)
+	c.mustInvoke(admin, "CreditReverse", reverse)
+	if c.credit(applicant) != 30 || c.credit(owner) != 5 || c.lobTotal(SMB) != 37 || c.lobTotal(HANA) != 5 {
+		t.Errorf("unexpected credits after reversal %d %d, LoB totals SMB %d HANA %d",
+			c.credit(applicant), c.credit(owner), c.lobTotal(SMB), c.lobTotal(HANA))
+	}
+	c.mustFail("already reversed", admin, "CreditReverse", reverse)
+	c.mustFail("No movement", admin, "CreditReverse", `{"UserID": "`+other+`", "TxID": "`+transferTxID+`"}`)
+	c.mustFail("missing field TxID", admin, "CreditReverse", `{"UserID": "`+other+`"}`)
+	c.mustFail("field TxID must not be empty", admin, "CreditReverse", `{"UserID": "`+other+`", "TxID": ""}`)
+
+	// ==== a reversed award no longer counts as credited ====
+	c.mustInvoke(admin, "CreditReverse", `{"UserID": "`+other+`", "TxID": "`+awardTxID+`"}`)
+	var credit Credit
+	c.mustUnmarshal(c.mustInvoke("", "CreditRead", other), &credit)
+	if credit.Value != 0 || Is_Inarray(credit.TicketIDs, ticketID) {
+		t.Errorf("unexpected credit %+v", credit)
+	}
+
+	// ==== journal paging ====
+	var page struct {
+		Entries  []JournalEntry
+		Count    int
+		Bookmark string
+	}
+	c.mustUnmarshal(c.mustInvoke("", "CreditJournal", applicant, "2"), &page)
+	if page.Count != 2 || page.Bookmark == "" {
+		t.Fatalf("unexpected first page %+v", page)
+	}
+	c.mustUnmarshal(c.mustInvoke("", "CreditJournal", applicant, "2", page.Bookmark), &page)
+	if page.Count != 1 || page.Bookmark != "" || page.Entries[0].Reason != JournalReversal {
+		t.Errorf("unexpected last page %+v", page)
+	}
+	c.mustFail("argument pageSize must be an integer", "", "CreditJournal", applicant, "x")
+	c.mustFail("pageSize must be a positive integer", "", "CreditJournal", applicant, "0")
+	for _, userID := range []string{admin, owner, applicant, other} {
+		var verify struct{ Consistent bool }
+		c.mustUnmarshal(c.mustInvoke("", "CreditVerify", userID), &verify)
+		if !verify.Consistent {
+			t.Errorf("journal and balance of %s disagree", userID)
+		}
+	}
+
+	// ==== ranking ====
+	var top []struct {
+		UserID string `json:"participant_UserID"`
+		Credit int    `json:"participant_credit"`
+	}
+	c.mustUnmarshal(c.mustInvoke("", "TopTenCredit"), &top)
+	if len(top) != 4 || top[0].UserID != applicant || top[0].Credit != 30 {
+		t.Errorf("unexpected ranking %+v", top)
+	}
+
+	// ==== create, read and delete credits of their own ====
+	c.mustFail("Forbidden", owner, "CreditCreate", "i000009", "10")
+	c.mustFail("argument Value must be an integer", admin, "CreditCreate", "i000009", "ten")
+	c.mustFail("Credit i000002 already exists", admin, "CreditCreate", owner, "10")
+	c.mustInvoke(admin, "CreditCreate", "i000009", "10")
+	if c.credit("i000009") != 10 {
+		t.Errorf("unexpected credit %d", c.credit("i000009"))
+	}
+	c.mustFail("Forbidden", owner, "CreditDelete", "i000009")
+	c.mustInvoke(admin, "CreditDelete", "i000009")
+	c.mustFail("Credit i000009 does not exist", "", "CreditRead", "i000009")
+	c.mustFail("Credit i000009 does not exist", "", "CreditVerify", "i000009")
+	c.mustFail("Credit i000009 does not exist", admin, "CreditDelete", "i000009")
+
+	// ==== the credit of a participant leaves its LoB total and the journal ====
+	total, balance := c.lobTotal(SMB), c.credit(applicant)
+	c.mustInvoke(admin, "CreditDelete", applicant)
+	if c.lobTotal(SMB) != total-balance {
+		t.Errorf("unexpected LoB total %d", c.lobTotal(SMB))
+	}
+	var journal struct{ Entries []JournalEntry }
+	c.mustUnmarshal(c.mustInvoke("", "CreditJournal", applicant, "100"), &journal)
+	if last := journal.Entries[len(journal.Entries)-1]; last.Reason != JournalDelete || last.Delta != -balance || last.Balance != 0 {
+		t.Errorf("unexpected journal entry %+v", last)
+	}
+	if report := c.audit(); len(report.Violations) != 0 {
+		t.Errorf("unexpected violations %+v", report.Violations)
+	}
+}
+
+func TestLoBRead(t *testing.T) {
+	c := newExchain(t)
+	c.mustInvoke(admin, "CreditAdd", `{"userID": "`+applicant+`", "value": 12, "ticketID": "creditADD"}`)
+
+	var lob LoBReading
+	c.mustUnmarshal(c.mustInvoke("", "LoBRead", strconv.Itoa(SMB)), &lob)
+	if lob.TotalCredit != 12 || lob.Members != 2 || len(lob.Participants) != 2 {
+		t.Fatalf("unexpected LoB %+v", lob)
+	}
+	if member := lob.Participants[0]; member.UserID != applicant || member.Credit != 12 || member.LoBID != SMB || lob.Participants[1].UserID != other {
+		t.Errorf("unexpected members %+v", lob.Participants)
+	}
+	c.mustFail("LoBID must be an integer", "", "LoBRead", "SMB")
+	c.mustFail("LoB 8 does not exist", "", "LoBRead", strconv.Itoa(len(defaultLoBs)))
+	c.mustFail("LoB -1 does not exist", "", "LoBRead", "-1")
+}
+
+func TestMigrateKeys(t *testing.T) {
+	c := newTestChain(t)
+
+	// ==== state written by the chaincode before keys were namespaced ====
+	c.run("", func(stub *testStub) peer.Response {
+		participant, _ := json.Marshal(Participant{UserID: "i000009", UserName: "Legacy", Password: "old", LoBID: HANA})
+		stub.PutState("i000009", participant)
+		stub.PutState("readingIDIndex", []byte(`{"UserIDs": ["i000009", "i000009", "lost"]}`))
+		stub.PutState("Credit_UerID_i000009", []byte(`{"Credit_UserID": "i000009", "Credit_Value": 7}`))
+		stub.PutState(creditKey(stub, "i000008"), []byte(`{"Credit_UserID": "i000008", "Credit_Value": 5}`))
+		stub.PutState("HANA", []byte(`{"LoB_LoBID": 1, "LoB_TotalCredit": 7, "LoB_UserIDs": ["i000009", "lost"]}`))
+		stub.PutState("TICKETID", []byte("1"))
+		stub.PutState("1", []byte(`{"Ticket_TicketID": "1", "Ticket_UserID": "i000009", "Ticket_Value": 3}`))
+		stub.PutState(orderKey(stub, "1", "i000008"), []byte(`{"TicketID": "1", "UserID": "i000008", "Status": 0}`))
+		stub.PutState(orderKey(stub, "1", "i000007"), []byte(`{"TicketID": "1", "UserID": "i000007", "Status": 3}`))
+		key, _ := passwordKey(stub, "i000008")
+		stub.PutState(key, []byte(`{"UserID": "i000008", "Salt": "00", "Hash": "00", "Iterations": 1}`))
+		return shim.Success(nil)
+	})
+	response := c.run("", func(stub *testStub) peer.Response { return c.cc.Init(stub) })
+	if e := chaincodeError(t, response); e.Code != ErrBadRequest || !strings.Contains(e.Message, "missing transient field Salt") {
+		t.Fatalf("plaintext passwords need a salt, got %+v", e)
+	}
+	response = c.secret("Salt", "salt of the upgrade").run("", func(stub *testStub) peer.Response { return c.cc.Init(stub) })
+	if response.Status != shim.OK {
+		t.Fatalf("Init failed: %s", response.Message)
+	}
+
+	for _, key := range []string{"i000009", "Credit_UerID_i000009", "HANA", "TICKETID", "1"} {
+		if c.stub.State[key] != nil {
+			t.Errorf("legacy key %s was not removed", key)
+		}
+	}
+	var participant Participant
+	c.mustUnmarshal(c.mustInvoke("", "readParticipant", "i000009"), &participant)
+	if participant.UserName != "Legacy" || participant.Password != "" {
+		t.Errorf("unexpected participant %+v", participant)
+	}
+	// Close was stored as status 0, the other statuses kept their values
+	if c.order("1", "i000008").Status != OrderClosed || c.order("1", "i000007").Status != OrderDone {
+		t.Errorf("unexpected orders %+v %+v", c.order("1", "i000008"), c.order("1", "i000007"))
+	}
+	if c.credit("i000009") != 7 || c.lobTotal(HANA) != 7 || c.ticket("1").Value != 3 || c.lobs()[HANA].Name != "HANA" {
+		t.Error("legacy records were not moved")
+	}
+	var participants []Participant
+	c.mustUnmarshal(c.mustInvoke("", "readAllParticipant"), &participants)
+	if len(participants) != 1 {
+		t.Errorf("lost and duplicate UserIDs must be dropped, got %+v", participants)
+	}
+
+	// the testStub reads like a peer, the steps of Init build on each other's writes nonetheless
+	var stored Participant
+	c.mustUnmarshal(c.stub.State[participantKey(c.stub, "i000009")], &stored)
+	if stored.Password != "" || !c.credentialsValid("i000009", "old") {
+		t.Errorf("the legacy password was not moved into a password record: %+v", stored)
+	}
+	credentialKey, _ := passwordKey(c.stub, "i000008")
+	if c.stub.State[credentialKey] != nil || c.stub.PvtState[CredentialCollection][credentialKey] == nil {
+		t.Error("the public password record was not moved into the credential collection")
+	}
+	var index ReadingIDIndex
+	c.mustUnmarshal(c.stub.State["readingIDIndex"], &index)
+	var lob LoB
+	c.mustUnmarshal(c.stub.State[lobKey(c.stub, HANA)], &lob)
+	if !equalStrings(index.UserIDs, []string{"i000009"}) || !equalStrings(lob.UserIDs, []string{"i000009"}) {
+		t.Errorf("unexpected index %v and LoB_UserIDs %v", index.UserIDs, lob.UserIDs)
+	}
+
+	// balances from before the journal open it, once
+	response = c.run("", func(stub *testStub) peer.Response { return c.cc.Init(stub) })
+	if response.Status != shim.OK {
+		t.Fatalf("Init failed: %s", response.Message)
+	}
+	for userID, value := range map[string]int{"i000009": 7, "i000008": 5} {
+		var verify struct {
+			JournalBalance int
+			Entries        int
+			Consistent     bool
+		}
+		c.mustUnmarshal(c.mustInvoke("", "CreditVerify", userID), &verify)
+		if !verify.Consistent || verify.Entries != 1 || verify.JournalBalance != value {
+			t.Errorf("unexpected journal of %s %+v", userID, verify)
+		}
+	}
+
+	// the migration is idempotent
+	c.register(admin, MD_office, true)
+	var report KeyMigrationReport
+	c.mustUnmarshal(c.mustInvoke(admin, "MigrateKeys"), &report)
+	if report.Participants != 0 || report.Credits != 0 || report.LoBs != 0 || report.Tickets != 0 || len(report.Dropped) != 0 {
+		t.Errorf("unexpected report of a second run %+v", report)
+	}
+	c.mustFail("Forbidden", "i000009", "MigrateKeys")
+}